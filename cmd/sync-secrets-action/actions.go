@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// setOutput sets an output of the action by appending it to the file GitHub Actions names in GITHUB_OUTPUT.
+// Outside of GitHub Actions it does nothing.
+func setOutput(name, value string) error {
+	path := os.Getenv("GITHUB_OUTPUT")
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer f.Close()
+
+	if strings.Contains(value, "\n") {
+		// Multiline values are wrapped in a random delimiter that can't appear in the value.
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("failed to generate output delimiter: %w", err)
+		}
+		delimiter := "ghadelimiter_" + hex.EncodeToString(b)
+		_, err = fmt.Fprintf(f, "%s<<%s\n%s\n%s\n", name, delimiter, value, delimiter)
+	} else {
+		_, err = fmt.Fprintf(f, "%s=%s\n", name, value)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write output %s: %w", name, err)
+	}
+	return nil
+}
+
+// appendStepSummary appends markdown to the summary of the job, shown on the page of the workflow run, by writing it
+// to the file GitHub Actions names in GITHUB_STEP_SUMMARY. Outside of GitHub Actions it does nothing.
+func appendStepSummary(markdown string) error {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open step summary: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.WriteString(f, markdown); err != nil {
+		return fmt.Errorf("failed to write step summary: %w", err)
+	}
+	return nil
+}
+
+// inGitHubActions reports whether the binary runs as part of a GitHub Actions workflow.
+func inGitHubActions() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// addMask asks the runner to mask value in all subsequent log output of the job by writing an add-mask workflow command to w.
+// The runner matches masks line by line, so every line of a multiline value is masked on its own.
+func addMask(w io.Writer, value string) {
+	for _, line := range strings.Split(strings.ReplaceAll(value, "\r\n", "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fmt.Fprintf(w, "::add-mask::%s\n", escapeCommandData(line))
+	}
+}
+
+// maskFunc returns a function masking values in the log of the job, or nil outside of GitHub Actions.
+func maskFunc() func(value string) {
+	if !inGitHubActions() {
+		return nil
+	}
+	return func(value string) {
+		addMask(os.Stdout, value)
+	}
+}
+
+// maskValues masks all values in the log of the job, except those marking keys for deletion.
+func maskValues(values map[string]string) {
+	mask := maskFunc()
+	if mask == nil {
+		return
+	}
+	for _, key := range sortedKeys(values) {
+		if values[key] != deleteSentinel {
+			mask(values[key])
+		}
+	}
+}
+
+// debugEnabled logs debug messages outside of GitHub Actions, as set by --debug.
+var debugEnabled bool
+
+// debugf logs a debug message to the logger of ctx. In GitHub Actions it's written as debug workflow command, which
+// the runner only shows if debug logging is enabled for the run, so it's logged as well if debugEnabled is set.
+// Commands of a repository whose output is grouped are written along with the rest of its output.
+func debugf(ctx context.Context, format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	if inGitHubActions() && !debugEnabled {
+		command := fmt.Sprintf("::debug::%s\n", escapeCommandData(msg))
+		if group, ok := ctx.Value(logGroupContextKey{}).(*logGroup); ok {
+			group.write(command)
+			return
+		}
+		fmt.Fprint(os.Stdout, command)
+		return
+	}
+	if debugEnabled {
+		loggerFrom(ctx).Printf("Debug: %s\n", msg)
+	}
+}
+
+// annotate writes an error or warning workflow command to w, which the runner shows as annotation of the run with
+// the given title, so failures surface on the page of the run instead of being buried in its log.
+func annotate(w io.Writer, level, title, message string) {
+	fmt.Fprintf(w, "::%s title=%s::%s\n", level, escapeCommandProperty(title), escapeCommandData(message))
+}
+
+// escapeCommandProperty escapes a property of a workflow command, which additionally can't contain colons and commas.
+func escapeCommandProperty(s string) string {
+	return strings.NewReplacer(":", "%3A", ",", "%2C").Replace(escapeCommandData(s))
+}
+
+// escapeCommandData escapes the data of a workflow command, which the runner unescapes again.
+func escapeCommandData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
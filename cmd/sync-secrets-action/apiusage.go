@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// API call categories, in the order they are summarized.
+const (
+	callSearch    = "search"
+	callList      = "list"
+	callPut       = "put"
+	callDelete    = "delete"
+	callRateLimit = "rate-limit"
+)
+
+var callCategories = []string{callSearch, callList, callPut, callDelete, callRateLimit}
+
+// apiUsage counts the requests sent to the API by category and tracks the rate limits reported by the responses,
+// so users can tell how close a run comes to exhausting the token.
+type apiUsage struct {
+	mu      sync.Mutex
+	calls   map[string]int
+	tracker *rateLimitTracker
+	// start is the core rate limit at the start of the run, if known, see markStart.
+	start *github.Rate
+}
+
+func newAPIUsage() *apiUsage {
+	return &apiUsage{calls: make(map[string]int), tracker: newRateLimitTracker()}
+}
+
+// callCategory returns the category of a request. Reads of single resources like public keys count as lists.
+func callCategory(req *http.Request) string {
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/rate_limit"):
+		return callRateLimit
+	case strings.Contains(req.URL.Path, "/search/"):
+		return callSearch
+	}
+	switch req.Method {
+	case http.MethodDelete:
+		return callDelete
+	case http.MethodPut, http.MethodPost, http.MethodPatch:
+		return callPut
+	}
+	return callList
+}
+
+// count records a request.
+func (u *apiUsage) count(req *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.calls[callCategory(req)]++
+}
+
+// Summary returns the number of requests per category and the remaining rate limits, if known.
+func (u *apiUsage) Summary() string {
+	u.mu.Lock()
+	total := 0
+	parts := make([]string, 0, len(callCategories))
+	for _, category := range callCategories {
+		parts = append(parts, fmt.Sprintf("%d %s", u.calls[category], category))
+		total += u.calls[category]
+	}
+	u.mu.Unlock()
+
+	summary := fmt.Sprintf("API calls: %s (%d total)", strings.Join(parts, ", "), total)
+	for _, resource := range []string{coreResource, searchResource} {
+		if rate, ok := u.tracker.rate(resource); ok {
+			summary += fmt.Sprintf(", %s rate limit %d/%d remaining until %s", resource, rate.Remaining, rate.Limit, rate.Reset.Format(time.RFC3339))
+		}
+	}
+	return summary
+}
+
+// markStart remembers the current core rate limit, so the quota consumed by the run can be told at its end.
+func (u *apiUsage) markStart() {
+	rate, ok := u.tracker.rate(coreResource)
+	if !ok {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.start = &rate
+}
+
+// consumed returns the core quota used since markStart and the quota remaining now. If the rate limit was reset in
+// between, only the quota used since the reset is known.
+func (u *apiUsage) consumed() (used, remaining int, ok bool) {
+	end, ok := u.tracker.rate(coreResource)
+	if !ok {
+		return 0, 0, false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.start == nil {
+		return 0, 0, false
+	}
+	if u.start.Reset.Equal(end.Reset) {
+		return u.start.Remaining - end.Remaining, end.Remaining, true
+	}
+	return end.Limit - end.Remaining, end.Remaining, true
+}
+
+// usageTransport counts all requests sent through it.
+type usageTransport struct {
+	base  http.RoundTripper
+	usage *apiUsage
+}
+
+func (t *usageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.usage.count(req)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// AuditCmd produces an inventory of the secrets and variables of all matched repositories.
+type AuditCmd struct {
+	Format     string
+	StaleAfter string
+}
+
+// AuditEntry describes a single secret or variable found during an audit.
+type AuditEntry struct {
+	Repository  string    `json:"repository"`
+	Environment string    `json:"environment,omitempty"`
+	Type        string    `json:"type"`
+	Kind        string    `json:"kind"`
+	Name        string    `json:"name"`
+	Value       string    `json:"value,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Stale       bool      `json:"stale,omitempty"`
+}
+
+// parseAge parses a duration that may be given in days, e.g. 90d, in addition to the units of time.ParseDuration.
+func parseAge(age string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(age, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", age)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(age)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", age)
+	}
+	return d, nil
+}
+
+// markStaleSecrets flags all secrets last updated before the given time and returns their count.
+// Variables are never flagged since their values are readable and can be reviewed directly.
+func markStaleSecrets(entries []AuditEntry, before time.Time) int {
+	stale := 0
+	for i := range entries {
+		if entries[i].Kind == "secret" && entries[i].UpdatedAt.Before(before) {
+			entries[i].Stale = true
+			stale++
+		}
+	}
+	return stale
+}
+
+// auditRepository lists the secrets and variables of a repository and all of its environments.
+// Sections the token has no access to, e.g. Codespaces secrets on repositories without Codespaces, are skipped.
+func auditRepository(ctx context.Context, client GitHubActionClient, repo *github.Repository, perPage int) ([]AuditEntry, error) {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	fullName := owner + "/" + name
+
+	var entries []AuditEntry
+	addSecrets := func(typ, environment string, secrets []*github.Secret) {
+		for _, secret := range secrets {
+			entries = append(entries, AuditEntry{
+				Repository:  fullName,
+				Environment: environment,
+				Type:        typ,
+				Kind:        "secret",
+				Name:        secret.Name,
+				UpdatedAt:   secret.UpdatedAt.Time,
+			})
+		}
+	}
+	addVariables := func(environment string, variables []*github.ActionsVariable) {
+		for _, variable := range variables {
+			entries = append(entries, AuditEntry{
+				Repository:  fullName,
+				Environment: environment,
+				Type:        string(Actions),
+				Kind:        "variable",
+				Name:        variable.Name,
+				Value:       variable.Value,
+				UpdatedAt:   variable.GetUpdatedAt().Time,
+			})
+		}
+	}
+	skipInaccessible := func(section string, err error) error {
+		if isPermissionError(err) {
+			loggerFrom(ctx).Printf("Skipping %s of %s: %v\n", section, fullName, err)
+			return nil
+		}
+		return fmt.Errorf("failed to list %s of %s: %w", section, fullName, err)
+	}
+
+	secretLists := []struct {
+		typ  TargetType
+		list func(ctx context.Context, owner, repo string, opts *github.ListOptions) (*github.Secrets, *github.Response, error)
+	}{
+		{Actions, client.ListRepoSecrets},
+		{Dependabot, client.ListDependabotSecrets},
+		{Codespaces, client.ListCodespacesSecrets},
+	}
+	for _, l := range secretLists {
+		secrets, err := listAll(perPage, func(opts *github.ListOptions) ([]*github.Secret, *github.Response, error) {
+			s, resp, err := l.list(ctx, owner, name, opts)
+			if err != nil {
+				return nil, resp, err
+			}
+			return s.Secrets, resp, nil
+		})
+		if err != nil {
+			if err := skipInaccessible(string(l.typ)+" secrets", err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		addSecrets(string(l.typ), "", secrets)
+	}
+
+	variables, err := listAll(perPage, func(opts *github.ListOptions) ([]*github.ActionsVariable, *github.Response, error) {
+		v, resp, err := client.ListRepoVariables(ctx, owner, name, opts)
+		if err != nil {
+			return nil, resp, err
+		}
+		return v.Variables, resp, nil
+	})
+	if err != nil {
+		if err := skipInaccessible("variables", err); err != nil {
+			return nil, err
+		}
+	}
+	addVariables("", variables)
+
+	environments, err := listAll(perPage, func(opts *github.ListOptions) ([]*github.Environment, *github.Response, error) {
+		e, resp, err := client.ListEnvironments(ctx, owner, name, &github.EnvironmentListOptions{ListOptions: *opts})
+		if err != nil {
+			return nil, resp, err
+		}
+		return e.Environments, resp, nil
+	})
+	if err != nil {
+		if err := skipInaccessible("environments", err); err != nil {
+			return nil, err
+		}
+	}
+	if len(environments) == 0 {
+		return entries, nil
+	}
+
+	repoID, err := repositoryID(ctx, client, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, environment := range environments {
+		envName := environment.GetName()
+		secrets, err := listAll(perPage, func(opts *github.ListOptions) ([]*github.Secret, *github.Response, error) {
+			s, resp, err := client.ListEnvSecrets(ctx, repoID, envName, opts)
+			if err != nil {
+				return nil, resp, err
+			}
+			return s.Secrets, resp, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets of environment %s in %s: %w", envName, fullName, err)
+		}
+		addSecrets(string(Actions), envName, secrets)
+
+		variables, err := listAll(perPage, func(opts *github.ListOptions) ([]*github.ActionsVariable, *github.Response, error) {
+			v, resp, err := client.ListEnvVariables(ctx, owner, name, envName, opts)
+			if err != nil {
+				return nil, resp, err
+			}
+			return v.Variables, resp, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list variables of environment %s in %s: %w", envName, fullName, err)
+		}
+		addVariables(envName, variables)
+	}
+	return entries, nil
+}
+
+// writeAuditEntries writes the entries in the given format.
+func writeAuditEntries(w io.Writer, format string, entries []AuditEntry) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"repository", "environment", "type", "kind", "name", "value", "updated_at", "stale"})
+		for _, e := range entries {
+			_ = cw.Write([]string{e.Repository, e.Environment, e.Type, e.Kind, e.Name, e.Value, e.UpdatedAt.Format(time.RFC3339), strconv.FormatBool(e.Stale)})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unsupported audit format %q, must be csv or json", format)
+	}
+}
+
+// runAudit walks all matched repositories and writes an inventory of their secrets and variables.
+func runAudit(ctx context.Context, cmd *AuditCmd, args EnvArgs, client GitHubActionClient, w io.Writer) error {
+	if cmd.Format != "csv" && cmd.Format != "json" {
+		return fmt.Errorf("unsupported audit format %q, must be csv or json", cmd.Format)
+	}
+	var staleAfter time.Duration
+	if cmd.StaleAfter != "" {
+		var err error
+		if staleAfter, err = parseAge(cmd.StaleAfter); err != nil {
+			return err
+		}
+	}
+
+	repos, err := resolveRepositories(ctx, args, client)
+	if err != nil {
+		return err
+	}
+
+	var entries []AuditEntry
+	for repo, err := range repos {
+		if err != nil {
+			return err
+		}
+		loggerFrom(ctx).Printf("Auditing %s/%s\n", repo.GetOwner().GetLogin(), repo.GetName())
+		repoEntries, err := auditRepository(ctx, client, repo, args.PerPage)
+		if err != nil {
+			if args.Query == "" || !isPermissionError(err) {
+				return err
+			}
+			loggerFrom(ctx).Printf("Skipping %s/%s: insufficient permissions: %v\n", repo.GetOwner().GetLogin(), repo.GetName(), err)
+			continue
+		}
+		entries = append(entries, repoEntries...)
+	}
+
+	if staleAfter > 0 {
+		stale := markStaleSecrets(entries, time.Now().Add(-staleAfter))
+		loggerFrom(ctx).Printf("%d secrets have not been updated within %s\n", stale, cmd.StaleAfter)
+	}
+	return writeAuditEntries(w, cmd.Format, entries)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// BootstrapCmd provisions new repositories from a template repository.
+type BootstrapCmd struct {
+	Template string
+}
+
+// runBootstrap copies the Actions variables and the environments of the template repository to all matched
+// repositories, along with the secrets of the input, and adds the results to the report. Variables of the input take
+// precedence over those of the template. Only keys a repository doesn't have yet are written and nothing is pruned,
+// so running it again on a provisioned repository only adds what's missing.
+func runBootstrap(ctx context.Context, cmd *BootstrapCmd, args EnvArgs, client GitHubActionClient, secrets, variables map[string]string, report *Report) error {
+	template, err := parseVariableSource(cmd.Template)
+	if err != nil {
+		return err
+	}
+	if template.Environment != "" {
+		return fmt.Errorf("invalid template %q, expected owner/repo", cmd.Template)
+	}
+
+	values, err := fetchVariables(ctx, client, template, args.PerPage)
+	if err != nil {
+		return err
+	}
+	maps.Copy(values, variables)
+	if args.SkipVariables {
+		values = nil
+	}
+	if args.SkipSecrets {
+		secrets = nil
+	}
+	environments, err := listAll(args.PerPage, func(opts *github.ListOptions) ([]*github.Environment, *github.Response, error) {
+		e, resp, err := client.ListEnvironments(ctx, template.Owner, template.Repo, &github.EnvironmentListOptions{ListOptions: *opts})
+		if err != nil {
+			return nil, resp, err
+		}
+		return e.Environments, resp, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list environments of %s: %w", template, err)
+	}
+
+	repos, err := resolveRepositories(ctx, args, client)
+	if err != nil {
+		return err
+	}
+	for repo, err := range repos {
+		if err != nil {
+			return err
+		}
+		owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+		if strings.EqualFold(owner+"/"+name, template.String()) {
+			continue
+		}
+		result, err := bootstrapRepository(ctx, args, client, repo, template, environments, secrets, values)
+		report.Add(result)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bootstrapRepository creates the environments in repo and writes the secrets and variables it doesn't have yet.
+func bootstrapRepository(ctx context.Context, args EnvArgs, client GitHubActionClient, repo *github.Repository, template variableSource, environments []*github.Environment, secrets, variables map[string]string) (RepoResult, error) {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	result := RepoResult{Repository: owner + "/" + name, Type: Actions}
+	keys := &keyRecorder{}
+	ctx = withKeyRecorder(ctx, keys)
+	fail := func(err error) (RepoResult, error) {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+		result.Keys = keys.Results()
+		return result, err
+	}
+
+	log.Printf("Bootstrapping %s/%s from %s\n", owner, name, template)
+	for _, environment := range environments {
+		if _, _, err := client.CreateEnvironment(ctx, owner, name, environment.GetName()); err != nil {
+			return fail(fmt.Errorf("failed to create environment %s in %s/%s: %w", environment.GetName(), owner, name, err))
+		}
+	}
+	if len(variables) > 0 {
+		existing, err := fetchVariables(ctx, client, variableSource{Owner: owner, Repo: name}, args.PerPage)
+		if err != nil {
+			return fail(err)
+		}
+		if variables = missingValues(variables, slices.Collect(maps.Keys(existing))); len(variables) > 0 {
+			if err := client.PutRepoVariables(ctx, owner, name, variables); err != nil {
+				return fail(fmt.Errorf("failed to put variables of %s/%s: %w", owner, name, err))
+			}
+		}
+	}
+	if len(secrets) > 0 {
+		existing, err := listAll(args.PerPage, func(opts *github.ListOptions) ([]*github.Secret, *github.Response, error) {
+			s, resp, err := client.ListRepoSecrets(ctx, owner, name, opts)
+			if err != nil {
+				return nil, resp, err
+			}
+			return s.Secrets, resp, nil
+		})
+		if err != nil {
+			return fail(fmt.Errorf("failed to list secrets of %s/%s: %w", owner, name, err))
+		}
+		names := make([]string, 0, len(existing))
+		for _, secret := range existing {
+			names = append(names, secret.Name)
+		}
+		if secrets = missingValues(secrets, names); len(secrets) > 0 {
+			if err := client.PutRepoSecrets(ctx, owner, name, secrets); err != nil {
+				return fail(fmt.Errorf("failed to put secrets of %s/%s: %w", owner, name, err))
+			}
+		}
+	}
+	log.Printf("Bootstrapped %s/%s with %d environments, %d new variables, and %d new secrets\n", owner, name, len(environments), len(variables), len(secrets))
+
+	result.Keys = keys.Results()
+	result.Status = syncedStatus(result.Keys)
+	return result, nil
+}
+
+// missingValues returns the values whose keys aren't among the existing names, which GitHub compares case-insensitively.
+func missingValues(values map[string]string, existing []string) map[string]string {
+	missing := maps.Clone(values)
+	for _, name := range existing {
+		for key := range missing {
+			if strings.EqualFold(key, name) {
+				delete(missing, key)
+			}
+		}
+	}
+	return missing
+}
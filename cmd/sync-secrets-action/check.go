@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// CheckCmd verifies that all matched repositories are provisioned with the required keys.
+type CheckCmd struct {
+	RequireKeys string
+}
+
+// existingKeyNames returns the names of all secrets and variables of a repository for the configured type and environment.
+func existingKeyNames(ctx context.Context, client GitHubActionClient, args EnvArgs, repo *github.Repository) (map[string]bool, error) {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	names := make(map[string]bool)
+
+	listSecrets := func(list func(opts *github.ListOptions) (*github.Secrets, *github.Response, error)) error {
+		secrets, err := listAll(args.PerPage, func(opts *github.ListOptions) ([]*github.Secret, *github.Response, error) {
+			s, resp, err := list(opts)
+			if err != nil {
+				return nil, resp, err
+			}
+			return s.Secrets, resp, nil
+		})
+		for _, secret := range secrets {
+			names[secret.Name] = true
+		}
+		return err
+	}
+	listVariables := func(list func(opts *github.ListOptions) (*github.ActionsVariables, *github.Response, error)) error {
+		variables, err := listAll(args.PerPage, func(opts *github.ListOptions) ([]*github.ActionsVariable, *github.Response, error) {
+			v, resp, err := list(opts)
+			if err != nil {
+				return nil, resp, err
+			}
+			return v.Variables, resp, nil
+		})
+		for _, variable := range variables {
+			names[variable.Name] = true
+		}
+		return err
+	}
+
+	var err error
+	switch TargetType(args.Type) {
+	case Actions:
+		if args.Environment == "" {
+			err = listSecrets(func(opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
+				return client.ListRepoSecrets(ctx, owner, name, opts)
+			})
+			if err == nil {
+				err = listVariables(func(opts *github.ListOptions) (*github.ActionsVariables, *github.Response, error) {
+					return client.ListRepoVariables(ctx, owner, name, opts)
+				})
+			}
+			break
+		}
+		var repoID int
+		repoID, err = repositoryID(ctx, client, repo)
+		if err != nil {
+			return nil, err
+		}
+		err = listSecrets(func(opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
+			return client.ListEnvSecrets(ctx, repoID, args.Environment, opts)
+		})
+		if err == nil {
+			err = listVariables(func(opts *github.ListOptions) (*github.ActionsVariables, *github.Response, error) {
+				return client.ListEnvVariables(ctx, owner, name, args.Environment, opts)
+			})
+		}
+	case Dependabot:
+		err = listSecrets(func(opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
+			return client.ListDependabotSecrets(ctx, owner, name, opts)
+		})
+	case Codespaces:
+		err = listSecrets(func(opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
+			return client.ListCodespacesSecrets(ctx, owner, name, opts)
+		})
+	default:
+		return nil, fmt.Errorf("unsupported target: %s", args.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys of %s/%s: %w", owner, name, err)
+	}
+	return names, nil
+}
+
+// missingKeys returns the required keys not contained in existing, in the order they were required.
+func missingKeys(required []string, existing map[string]bool) []string {
+	var missing []string
+	for _, key := range required {
+		if !existing[key] {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// runCheck verifies every matched repository has the required keys and prints the violators.
+// It returns the number of repositories that violate the requirements.
+func runCheck(ctx context.Context, cmd *CheckCmd, args EnvArgs, client GitHubActionClient, w io.Writer) (int, error) {
+	var required []string
+	for _, key := range splitList(cmd.RequireKeys) {
+		required = append(required, strings.ToUpper(key))
+	}
+	if len(required) == 0 {
+		return 0, fmt.Errorf("no keys to check, use --require-keys")
+	}
+
+	targets, err := matrixTargets(args.Type, args.Environment)
+	if err != nil {
+		return 0, err
+	}
+
+	repos, err := resolveRepositories(ctx, args, client)
+	if err != nil {
+		return 0, err
+	}
+
+	var violators []string
+	checked := 0
+	for repo, err := range repos {
+		if err != nil {
+			return 0, err
+		}
+		for _, target := range targets {
+			checked++
+			name := target.describe(repo.GetOwner().GetLogin() + "/" + repo.GetName())
+			log.Printf("Checking %s\n", name)
+
+			existing, err := existingKeyNames(ctx, client, args.withTarget(target), repo)
+			if err != nil {
+				if args.Query == "" || !isPermissionError(err) {
+					return 0, err
+				}
+				log.Printf("Skipping %s: insufficient permissions: %v\n", name, err)
+				continue
+			}
+			if missing := missingKeys(required, existing); len(missing) > 0 {
+				violators = append(violators, fmt.Sprintf("%s: missing %s", name, strings.Join(missing, ", ")))
+			}
+		}
+	}
+
+	sort.Strings(violators)
+	for _, violator := range violators {
+		fmt.Fprintln(w, violator)
+	}
+	fmt.Fprintf(w, "%d of %d repositories are missing required keys\n", len(violators), checked)
+	return len(violators), nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envAnnotation marks flags that fall back to an environment variable.
+const envAnnotation = "sync-secrets-action/env"
+
+// parseArgs parses the command line and the environment into args, writing help and completion scripts to out.
+// It returns false if the invocation was already handled, e.g. by printing the help, the version, or a completion script.
+func parseArgs(argv []string, out io.Writer) (EnvArgs, bool, error) {
+	var args EnvArgs
+	var audit AuditCmd
+	var check CheckCmd
+	var version VersionCmd
+	var bootstrap BootstrapCmd
+	ran := false
+
+	root := &cobra.Command{
+		Use:   "sync-secrets-action",
+		Short: "Sync secrets and variables to GitHub repositories",
+		Long: `Sync Actions, Dependabot and Codespaces secrets and variables to the repository given by --target
+or to all repositories matching --query.
+
+Every flag of the sync can also be set through the environment variable named after it,
+e.g. --max-retries through MAX_RETRIES, or through INPUT_MAX-RETRIES as set by the Actions runner
+for the input of the same name. Flags take precedence over the environment.`,
+		Example: `  sync-secrets-action --github-token "$TOKEN" --target org/service --secrets "$(cat secrets.env)"
+  sync-secrets-action --github-token "$TOKEN" --query "org:myorg topic:docker" --type dependabot --dry-run --secrets "$(cat secrets.env)"`,
+		Version: EnvArgs{}.Version(),
+		Args:    cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			return applyEnv(cmd.Flags())
+		},
+		Run: func(*cobra.Command, []string) {
+			ran = true
+		},
+	}
+	root.SetVersionTemplate("{{.Version}}")
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&args.Config, "config", "", "YAML manifest of sync jobs to run instead of a single sync, see validate-config")
+	flags.StringVar(&args.TargetRepo, "target", "", "repository to sync to as owner/repo, mutually exclusive with --query and --repos")
+	flags.StringVar(&args.Repos, "repos", "", "comma or newline separated repositories to sync to as owner/repo, mutually exclusive with --target and --query")
+	flags.StringVar(&args.ReposFile, "repos-file", "", "file listing repositories to sync to as owner/repo, one per line, lines starting with # are comments")
+	flags.StringVar(&args.Organization, "organization", "", "organization to sync Codespaces secrets to instead of repositories, mutually exclusive with --target, --query, and --repos")
+	flags.StringVar(&args.OrgSecretVisibility, "org-secret-visibility", "", "newline-separated KEY=VISIBILITY pairs declaring the visibility of organization secrets: all, private, or selected:repo-a,repo-b")
+	flags.StringVar(&args.Query, "query", "", "search queries selecting the repositories to sync to, one per line, e.g. org:myorg topic:docker")
+	flags.StringVar(&args.ExcludeQuery, "exclude-query", "", "search queries whose repositories are removed from the selection, one per line")
+	flags.StringVar(&args.NameRegex, "name-regex", "", "regular expression the full name (owner/repo) of selected repositories must match")
+	flags.StringVar(&args.Filter, "filter", "", "CEL expression selecting repositories by their attributes, e.g. !repo.archived && \"docker\" in repo.topics")
+	flags.StringVar(&args.GithubToken, "github-token", "", "token used to access the GitHub API")
+	flags.BoolVar(&args.DryRun, "dry-run", false, "log the changes without applying them")
+	flags.BoolVar(&args.Preflight, "preflight", false, "verify the token has admin access to all repositories, or to the organization, before changing any of them")
+	flags.StringVar(&args.Secrets, "secrets", "", "newline separated KEY=value pairs of secrets to sync")
+	flags.StringVar(&args.Variables, "variables", "", "newline separated KEY=value pairs of variables to sync")
+	flags.StringVar(&args.FromEnvironment, "from-environment", "", "sync the Actions variables of this environment as owner/repo:environment instead of --variables")
+	flags.StringVar(&args.AllowKeys, "allow-keys", "", "comma separated keys promoted with --from-environment, other keys of the source are left out")
+	flags.BoolVar(&args.RateLimit, "rate-limit", false, "check the rate limit before every request")
+	flags.IntVar(&args.MaxRetries, "max-retries", 3, "maximum number of retries for failed requests")
+	flags.IntVar(&args.Concurrency, "concurrency", 1, "number of repositories of an owner synced at the same time")
+	flags.BoolVar(&args.Debug, "debug", false, "log debug messages, like every retried request")
+	flags.BoolVar(&args.Prune, "prune", false, "delete secrets and variables that aren't part of the input")
+	flags.StringVar(&args.PruneProtect, "prune-protect", "", "comma or newline separated globs, or /regexes/, of keys never deleted by --prune")
+	flags.StringVar(&args.ManagedPrefix, "managed-prefix", "", "prefix of the keys managed by the tool, --prune only deletes keys with it")
+	flags.BoolVar(&args.AllowEmptyPrune, "allow-empty-prune", false, "allow --prune without secrets or variables, deleting every existing key")
+	flags.StringVar(&args.Delete, "delete", "", "comma separated keys of secrets and variables to delete, without --prune")
+	flags.BoolVar(&args.SkipSecrets, "skip-secrets", false, "neither write nor prune secrets, only manage variables")
+	flags.BoolVar(&args.SkipVariables, "skip-variables", false, "neither write nor prune variables, only manage secrets")
+	flags.StringVar(&args.Environment, "environment", "", "comma separated Actions environments to sync to")
+	flags.BoolVar(&args.CreateEnvironment, "create-environment", false, "create missing environments without protection rules before syncing to them")
+	flags.BoolVar(&args.SkipMissingEnv, "skip-missing-environment", false, "skip repositories missing an environment instead of failing")
+	flags.StringVar(&args.EnvironmentPattern, "environment-pattern", "", "comma separated globs, or /regexes/, of the environments of each repository to sync to")
+	flags.StringVar(&args.Type, "type", string(Actions), "comma separated types to sync: actions, dependabot, codespaces")
+	flags.StringVar(&args.Order, "order", string(OrderAlpha), "order in which matched repositories are processed: alpha, pushed, created, random, search; only search streams the results page by page, the others wait for the complete search result")
+	flags.StringVar(&args.ReportFile, "report-file", "", "write a JSON report of the per-repository results to this file")
+	flags.StringVar(&args.Output, "output", outputText, "json writes the changes, or planned changes of a dry run, as JSON document at the end of the run, text leaves them to the log")
+	flags.StringVar(&args.OutputFile, "output-file", "", "file to write the JSON document of output json to, by default it's the changes output in GitHub Actions and stdout elsewhere")
+	flags.StringVar(&args.PlanOut, "plan-out", "", "write the planned changes of a dry run, or the applied changes, as plain text to this file")
+	flags.BoolVar(&args.SkipEmpty, "skip-empty", false, "ignore keys with an empty value instead of failing")
+	flags.BoolVar(&args.RawInput, "raw-input", false, "keep carriage returns and byte order marks of secrets and variables instead of removing them")
+	flags.StringVar(&args.ExpectKeys, "expect-keys", "", "comma separated keys the input must contain")
+	flags.BoolVar(&args.StrictValues, "strict-values", false, "fail on suspicious values instead of warning")
+	flags.BoolVar(&args.TemplateValues, "template-values", false, "render values as Go templates per repository, with functions like randAlphaNum, b64enc, uuidv4, and now")
+	flags.BoolVar(&args.MetadataVars, "metadata-variables", false, "also set the variables REPO_NAME, REPO_OWNER, DEFAULT_BRANCH, and SYNC_SOURCE derived from each repository")
+	flags.BoolVar(&args.ContinueOnError, "continue-on-error", false, "continue with the other repositories when one fails and fail the run at the end, instead of stopping at the first failure")
+	flags.Var(optionalBool{&args.FailFast}, "fail-fast", "stop at the first repository that fails, the default unless --continue-on-error is set; --fail-fast=false is the same as --continue-on-error")
+	flags.Lookup("fail-fast").NoOptDefVal = "true"
+	flags.BoolVar(&args.DetailedExitCode, "detailed-exitcode", false, "exit with 2 if a dry run or check finds pending changes, errors exit with 1")
+	flags.StringVar(&args.SkipRepos, "skip-repos", "", "comma or newline separated repositories that are never touched")
+	flags.StringVar(&args.SkipReposFile, "skip-repos-file", "", "file listing repositories that are never touched, one per line")
+	flags.StringVar(&args.RequireFile, "require-file", "", "only sync to repositories containing this file or directory, e.g. .github/workflows")
+	flags.StringVar(&args.RepoConfig, "repo-config", "", "path of the file repositories can override the sync with, e.g. .github/sync-secrets.yml")
+	flags.StringVar(&args.RateLimitPolicy, "rate-limit-policy", string(RateLimitWait), "what to do when the rate limit is close to being exceeded: wait or fail")
+	flags.DurationVar(&args.RateLimitMaxWait, "rate-limit-max-wait", 0, "maximum time to wait for a rate limit reset, 0 waits as long as needed")
+	flags.DurationVar(&args.HTTPTimeout, "http-timeout", 60*time.Second, "timeout of a single request, 0 disables the timeout")
+	flags.IntVar(&args.HTTPMaxIdleConns, "http-max-idle-conns", 10, "maximum number of idle connections kept open")
+	flags.DurationVar(&args.HTTPKeepAlive, "http-keep-alive", 30*time.Second, "interval of TCP keep-alive probes of connections, 0 uses the default of Go")
+	flags.BoolVar(&args.HTTPNoKeepAlive, "http-no-keep-alive", false, "open a new connection for every request instead of reusing idle ones")
+	flags.IntVar(&args.PerPage, "per-page", defaultPerPage, "number of items requested per page of list and search operations, at most 100")
+	flags.StringVar(&args.CacheDir, "cache-dir", "", "directory to cache API responses in between runs, revalidated with every request")
+	flags.StringVar(&args.BaseURL, "base-url", "", "URL of a GitHub Enterprise Server instance, e.g. https://github.example.com, /api/v3/ is appended if missing and *.ghe.com hosts are mapped to api.<host>")
+	flags.StringVar(&args.UploadURL, "upload-url", "", "upload URL of a GitHub Enterprise Server instance, defaults to the host of --base-url")
+	flags.StringVar(&args.CACert, "ca-cert", "", "PEM bundle of certificate authorities trusted for the API in addition to those of the system")
+	flags.BoolVar(&args.InsecureSkipTLS, "insecure-skip-tls-verify", false, "don't verify the certificate of the API, discouraged as it exposes the token to interception")
+	flags.StringVar(&args.MockServer, "mock-server", "", "run against an in-memory GitHub API double serving the repositories of this YAML fixture")
+	flags.StringVar(&args.CPUProfile, "cpuprofile", "", "write a CPU profile of the run to this file")
+	flags.StringVar(&args.PprofAddr, "pprof-addr", "", "serve the runtime profiles on this loopback address while running, e.g. localhost:6060")
+	bindEnv(flags)
+	// Profiling is meant for maintainers, not part of the documented interface.
+	_ = flags.MarkHidden("cpuprofile")
+	_ = flags.MarkHidden("pprof-addr")
+
+	_ = root.MarkPersistentFlagFilename("skip-repos-file")
+	_ = root.MarkPersistentFlagFilename("report-file", "json")
+	_ = root.MarkPersistentFlagFilename("plan-out")
+	_ = root.MarkPersistentFlagFilename("output-file", "json")
+	_ = root.MarkPersistentFlagFilename("ca-cert", "pem", "crt")
+	_ = root.MarkPersistentFlagFilename("mock-server", "yml", "yaml")
+	_ = root.MarkPersistentFlagDirname("cache-dir")
+	_ = root.RegisterFlagCompletionFunc("type", completeList(string(Actions), string(Dependabot), string(Codespaces)))
+	_ = root.RegisterFlagCompletionFunc("order", completeList(string(OrderAlpha), string(OrderPushed), string(OrderCreated), string(OrderRandom), string(OrderSearch)))
+	_ = root.RegisterFlagCompletionFunc("rate-limit-policy", completeList(string(RateLimitWait), string(RateLimitFail)))
+
+	diffCmd := &cobra.Command{
+		Use:   "diff <left> [right]",
+		Short: "Compare Actions variables between two repositories or environments",
+		Long: `Compare the Actions variables of two repositories or environments, given as owner/repo or owner/repo:environment.
+Without a right side, the left one is compared against the --variables input.`,
+		Example: `  sync-secrets-action --github-token "$TOKEN" diff org/service:staging org/service:production
+  sync-secrets-action --github-token "$TOKEN" --variables "$(cat variables.env)" diff org/service`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(_ *cobra.Command, positional []string) {
+			args.Diff = &DiffCmd{Left: positional[0]}
+			if len(positional) > 1 {
+				args.Diff.Right = positional[1]
+			}
+			ran = true
+		},
+	}
+
+	auditCmd := &cobra.Command{
+		Use:     "audit",
+		Short:   "Write an inventory of secrets and variables of all matched repositories",
+		Example: `  sync-secrets-action --github-token "$TOKEN" --query "org:myorg" audit --format json > inventory.json`,
+		Args:    cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			args.Audit = &audit
+			ran = true
+		},
+	}
+	auditCmd.Flags().StringVar(&audit.Format, "format", "csv", "output format: csv or json")
+	auditCmd.Flags().StringVar(&audit.StaleAfter, "stale-after", "", "flag secrets not updated within this age, e.g. 90d or 720h")
+	_ = auditCmd.RegisterFlagCompletionFunc("format", completeList("csv", "json"))
+
+	checkCmd := &cobra.Command{
+		Use:     "check",
+		Short:   "Verify all matched repositories have the required keys",
+		Example: `  sync-secrets-action --github-token "$TOKEN" --query "org:myorg topic:docker" check --require-keys DOCKER_USER,DOCKER_PASS`,
+		Args:    cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			args.Check = &check
+			ran = true
+		},
+	}
+	checkCmd.Flags().StringVar(&check.RequireKeys, "require-keys", "", "comma separated secret or variable names every repository must have")
+	bindEnv(checkCmd.Flags())
+
+	validateConfigCmd := &cobra.Command{
+		Use:     "validate-config <path>",
+		Short:   "Validate a YAML manifest without making any API call",
+		Example: `  sync-secrets-action validate-config sync.yaml`,
+		Args:    cobra.ExactArgs(1),
+		ValidArgsFunction: func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+			return []string{"yaml", "yml"}, cobra.ShellCompDirectiveFilterFileExt
+		},
+		Run: func(_ *cobra.Command, positional []string) {
+			args.ValidateConfig = &ValidateConfigCmd{Path: positional[0]}
+			ran = true
+		},
+	}
+
+	versionCmd := &cobra.Command{
+		Use:     "version",
+		Short:   "Print the build information",
+		Example: `  sync-secrets-action version --json`,
+		Args:    cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			args.PrintVersion = &version
+			ran = true
+		},
+	}
+	versionCmd.Flags().BoolVar(&version.JSON, "json", false, "print the build information as JSON")
+
+	healthcheckCmd := &cobra.Command{
+		Use:   "healthcheck",
+		Short: "Check the API endpoint accepts the token and print the result as JSON",
+		Long: `Print the build information, the API endpoint, and whether it accepts the token as JSON.
+Exits with 1 if the check fails, so it can be used as Docker HEALTHCHECK.`,
+		Example: `  sync-secrets-action --github-token "$TOKEN" healthcheck`,
+		Args:    cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			args.Healthcheck = &HealthcheckCmd{}
+			ran = true
+		},
+	}
+
+	whoamiCmd := &cobra.Command{
+		Use:     "whoami",
+		Short:   "Print who the token authenticates as, its scopes, and the rate limit",
+		Example: `  sync-secrets-action --github-token "$TOKEN" whoami`,
+		Args:    cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			args.Whoami = &WhoamiCmd{}
+			ran = true
+		},
+	}
+
+	bootstrapCmd := &cobra.Command{
+		Use:   "bootstrap",
+		Short: "Provision repositories with the variables and environments of a template repository",
+		Long: `Copy the Actions variables and the environments of the template repository to the repository given by --target
+or to all repositories matching --query, along with the secrets of --secrets. Variables of --variables take
+precedence over those of the template. Nothing is pruned.`,
+		Example: `  sync-secrets-action --github-token "$TOKEN" --target org/new-service --secrets "$(cat secrets.env)" bootstrap --template org/service-template`,
+		Args:    cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			args.Bootstrap = &bootstrap
+			ran = true
+		},
+	}
+	bootstrapCmd.Flags().StringVar(&bootstrap.Template, "template", "", "repository to copy the variables and environments of, as owner/repo")
+	_ = bootstrapCmd.MarkFlagRequired("template")
+
+	root.AddCommand(diffCmd, auditCmd, checkCmd, validateConfigCmd, versionCmd, healthcheckCmd, whoamiCmd, bootstrapCmd)
+
+	root.SetArgs(argv)
+	root.SetOut(out)
+	if err := root.Execute(); err != nil {
+		return args, false, err
+	}
+	return args, ran, nil
+}
+
+// bindEnv lets all flags of the set fall back to the environment variable named after them with the SYNC_SECRETS_
+// prefix, e.g. SYNC_SECRETS_MAX_RETRIES for --max-retries, and to the INPUT_* variable of the action input of the same name.
+func bindEnv(flags *pflag.FlagSet) {
+	flags.VisitAll(func(f *pflag.Flag) {
+		envs := flagEnv(f.Name)
+		f.Usage += fmt.Sprintf(" [env: %s]", envs[0])
+		_ = flags.SetAnnotation(f.Name, envAnnotation, envs)
+	})
+}
+
+// envPrefix prefixes the environment variables of flags. Bare names like DELETE or CONFIG are common in the env of
+// workflows and jobs, and reading them would change what an unrelated step does.
+const envPrefix = "SYNC_SECRETS_"
+
+// bareEnvFlags are the flags that were read from their bare environment variable, e.g. TARGET for --target, before
+// the prefix was introduced. They keep reading it.
+var bareEnvFlags = map[string]bool{
+	"target":       true,
+	"github-token": true,
+	"dry-run":      true,
+	"secrets":      true,
+	"variables":    true,
+	"rate-limit":   true,
+	"max-retries":  true,
+	"prune":        true,
+	"environment":  true,
+	"type":         true,
+	"query":        true,
+}
+
+// flagEnv returns the environment variables the flag name is read from, in order of precedence: the bare name of
+// bareEnvFlags, the prefixed name, and the INPUT_* variable the Actions runner sets for the input named after the flag,
+// which keeps hyphens, e.g. INPUT_GITHUB-TOKEN. Shells can't set names with hyphens, so wrappers of composite actions
+// may export INPUT_GITHUB_TOKEN instead.
+func flagEnv(name string) []string {
+	env := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	var names []string
+	if bareEnvFlags[name] {
+		names = append(names, env)
+	}
+	names = append(names, envPrefix+env, "INPUT_"+strings.ToUpper(name))
+	if strings.Contains(name, "-") {
+		names = append(names, "INPUT_"+env)
+	}
+	return names
+}
+
+// applyEnv sets all flags that weren't given on the command line from the first of their environment variables that is set.
+func applyEnv(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		envs, ok := f.Annotations[envAnnotation]
+		if err != nil || !ok || f.Changed {
+			return
+		}
+		for _, env := range envs {
+			value, ok := os.LookupEnv(env)
+			if !ok || value == "" {
+				continue
+			}
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q of environment variable %s: %w", value, env, setErr)
+			}
+			return
+		}
+	})
+	return err
+}
+
+// optionalBool is a boolean flag that stays nil unless it's set, so an explicit value can be told from the default.
+// An empty value, like that of an action input without default, leaves it unset.
+type optionalBool struct {
+	value **bool
+}
+
+func (b optionalBool) String() string {
+	if *b.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(**b.value)
+}
+
+func (b optionalBool) Set(s string) error {
+	if s == "" {
+		*b.value = nil
+		return nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b.value = &v
+	return nil
+}
+
+func (b optionalBool) Type() string {
+	return "bool"
+}
+
+// completeList completes a flag with the given fixed values.
+func completeList(values ...string) cobra.CompletionFunc {
+	return cobra.FixedCompletions(values, cobra.ShellCompDirectiveNoFileComp)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the declarative manifest describing one or more sync jobs.
+// Keep it in sync with schema/config.schema.json.
+type Config struct {
+	Jobs []ConfigJob `yaml:"jobs"`
+}
+
+// ConfigJob describes a single sync job of the manifest.
+type ConfigJob struct {
+	Name         string            `yaml:"name"`
+	Target       string            `yaml:"target"`
+	Query        string            `yaml:"query"`
+	Repos        []string          `yaml:"repos"`
+	Organization string            `yaml:"organization"`
+	Type         string            `yaml:"type"`
+	Types        []string          `yaml:"types"`
+	Environment  string            `yaml:"environment"`
+	Environments []string          `yaml:"environments"`
+	Prune        *bool             `yaml:"prune"`
+	Secrets      map[string]string `yaml:"secrets"`
+	Variables    map[string]string `yaml:"variables"`
+}
+
+// envReference matches the references to environment variables in the values of a job, like ${DOCKER_PASSWORD}.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the references to environment variables in value by their values, as returned by lookupEnv.
+// Unset variables are an error, so a missing secret of the step isn't synced as an empty value.
+func expandEnv(value string, lookupEnv func(string) (string, bool)) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		v, ok := lookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s isn't set", name)
+		}
+		return v
+	})
+	return expanded, err
+}
+
+// jobValues returns the values of a job with their keys normalized like by parseKeyValuePairs and the references
+// to environment variables expanded. Values that expand to nothing are dropped with skipEmpty, or are an error.
+func jobValues(values map[string]string, skipEmpty bool, lookupEnv func(string) (string, bool)) (map[string]string, error) {
+	result := make(map[string]string, len(values))
+	for _, key := range sortedKeys(values) {
+		value, err := expandEnv(values[key], lookupEnv)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if value == "" {
+			if skipEmpty {
+				continue
+			}
+			return nil, fmt.Errorf("%s: value is empty", key)
+		}
+		result[strings.ToUpper(strings.TrimSpace(key))] = value
+	}
+	return result, nil
+}
+
+// apply returns a copy of args that runs the job, along with its secrets and variables. The fields of the job
+// replace the corresponding arguments, the others apply to all jobs. Prune is only replaced if the job sets it. Lists of types and environments are synced as
+// matrix of all their combinations, like the lists of the type and environment arguments.
+func (job ConfigJob) apply(args EnvArgs, lookupEnv func(string) (string, bool)) (EnvArgs, map[string]string, map[string]string, error) {
+	args.TargetRepo = job.Target
+	args.Query = job.Query
+	args.Repos = strings.Join(job.Repos, ",")
+	args.Organization = job.Organization
+	args.Type = job.Type
+	if len(job.Types) > 0 {
+		args.Type = strings.Join(job.Types, ",")
+	}
+	if args.Type == "" {
+		args.Type = string(Actions)
+	}
+	args.Environment = job.Environment
+	if len(job.Environments) > 0 {
+		args.Environment = strings.Join(job.Environments, ",")
+	}
+	if job.Prune != nil {
+		args.Prune = *job.Prune
+	}
+
+	secrets, err := jobValues(job.Secrets, args.SkipEmpty, lookupEnv)
+	if err != nil {
+		return args, nil, nil, fmt.Errorf("secret %w", err)
+	}
+	variables, err := jobValues(job.Variables, args.SkipEmpty, lookupEnv)
+	if err != nil {
+		return args, nil, nil, fmt.Errorf("variable %w", err)
+	}
+	return args, secrets, variables, nil
+}
+
+// ValidateConfigCmd checks a manifest without making any API call.
+type ValidateConfigCmd struct {
+	Path string
+}
+
+// keyNamePattern matches valid secret and variable names.
+var keyNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateKeyName checks a secret or variable name against GitHub's naming rules.
+func validateKeyName(name string) error {
+	if !keyNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid name %q, names may only contain letters, digits, and underscores and must not start with a digit", name)
+	}
+	if strings.HasPrefix(strings.ToUpper(name), "GITHUB_") {
+		return fmt.Errorf("invalid name %q, names must not start with the GITHUB_ prefix", name)
+	}
+	return nil
+}
+
+// ConfigError describes a problem at a specific position of the manifest.
+type ConfigError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// configValidator collects all problems of a manifest instead of stopping at the first.
+type configValidator struct {
+	errs []ConfigError
+}
+
+func (v *configValidator) addf(node *yaml.Node, format string, a ...any) {
+	v.errs = append(v.errs, ConfigError{Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, a...)})
+}
+
+// expectKind reports an error if node isn't of the given kind and tag.
+func (v *configValidator) expectKind(node *yaml.Node, field string, kind yaml.Kind, tag string) bool {
+	if node.Kind != kind || (tag != "" && node.Tag != tag) {
+		want := map[yaml.Kind]string{yaml.MappingNode: "a mapping", yaml.SequenceNode: "a list"}[kind]
+		if want == "" {
+			want = map[string]string{"!!str": "a string", "!!bool": "a boolean"}[tag]
+		}
+		v.addf(node, "%s must be %s", field, want)
+		return false
+	}
+	return true
+}
+
+// mappingFields returns the key and value nodes of a mapping, reporting unknown and duplicate keys.
+func (v *configValidator) mappingFields(node *yaml.Node, context string, allowed []string) map[string][2]*yaml.Node {
+	fields := make(map[string][2]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch {
+		case allowed != nil && !slices.Contains(allowed, key.Value):
+			v.addf(key, "unknown field %q in %s", key.Value, context)
+		case fields[key.Value][0] != nil:
+			v.addf(key, "duplicate field %q in %s", key.Value, context)
+		default:
+			fields[key.Value] = [2]*yaml.Node{key, value}
+		}
+	}
+	return fields
+}
+
+var configJobFields = []string{"name", "target", "query", "repos", "organization", "type", "types", "environment", "environments", "prune", "secrets", "variables"}
+
+// listField returns the items of the non-empty list of strings in the field name of fields, reporting other values.
+func (v *configValidator) listField(fields map[string][2]*yaml.Node, name string) []*yaml.Node {
+	f, ok := fields[name]
+	if !ok || !v.expectKind(f[1], name, yaml.SequenceNode, "") {
+		return nil
+	}
+	if len(f[1].Content) == 0 {
+		v.addf(f[1], "%s must not be empty", name)
+	}
+	var items []*yaml.Node
+	for _, item := range f[1].Content {
+		if v.expectKind(item, name+" item", yaml.ScalarNode, "!!str") {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (v *configValidator) validateJob(node *yaml.Node, index int) {
+	context := fmt.Sprintf("job %d", index+1)
+	if !v.expectKind(node, context, yaml.MappingNode, "") {
+		return
+	}
+	fields := v.mappingFields(node, context, configJobFields)
+
+	for _, name := range []string{"name", "target", "query", "organization", "type", "environment"} {
+		if f, ok := fields[name]; ok {
+			v.expectKind(f[1], name, yaml.ScalarNode, "!!str")
+		}
+	}
+	if f, ok := fields["prune"]; ok {
+		v.expectKind(f[1], "prune", yaml.ScalarNode, "!!bool")
+	}
+
+	selectors := 0
+	for _, name := range []string{"target", "query", "repos", "organization"} {
+		if _, ok := fields[name]; ok {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		v.addf(node, "%s must set exactly one of target, query, repos, or organization", context)
+	}
+	if f, ok := fields["target"]; ok && f[1].Kind == yaml.ScalarNode {
+		if owner, repo, found := strings.Cut(f[1].Value, "/"); !found || owner == "" || repo == "" {
+			v.addf(f[1], "invalid target %q, expected owner/repo", f[1].Value)
+		}
+	}
+	for _, item := range v.listField(fields, "repos") {
+		if owner, repo, found := strings.Cut(item.Value, "/"); !found || owner == "" || repo == "" {
+			v.addf(item, "invalid repository %q, expected owner/repo", item.Value)
+		}
+	}
+
+	var types []string
+	validType := func(node *yaml.Node) {
+		switch TargetType(node.Value) {
+		case Actions, Dependabot, Codespaces:
+			types = append(types, node.Value)
+		default:
+			v.addf(node, "unsupported type %q, must be one of: actions, dependabot, codespaces", node.Value)
+		}
+	}
+	if f, ok := fields["type"]; ok {
+		validType(f[1])
+	}
+	for _, item := range v.listField(fields, "types") {
+		validType(item)
+	}
+	for _, item := range v.listField(fields, "environments") {
+		if item.Value == "" {
+			v.addf(item, "environments item must not be empty")
+		}
+	}
+	for _, pair := range [][2]string{{"type", "types"}, {"environment", "environments"}} {
+		if f, ok := fields[pair[1]]; ok && fields[pair[0]][0] != nil {
+			v.addf(f[0], "%s cannot be combined with %s", pair[1], pair[0])
+		}
+	}
+	if len(types) == 0 {
+		types = []string{string(Actions)}
+	}
+	others := slices.DeleteFunc(slices.Clone(types), func(t string) bool { return t == string(Codespaces) })
+	if f, ok := fields["organization"]; ok && len(others) > 0 {
+		v.addf(f[0], "organization is only supported for type codespaces, not %s", strings.Join(others, ", "))
+	}
+	// Environments and variables only need actions among the types, the other types of the matrix go without them.
+	if !slices.Contains(types, string(Actions)) {
+		for _, name := range []string{"environment", "environments"} {
+			if f, ok := fields[name]; ok {
+				v.addf(f[0], "%s is only supported for type actions, not %s", name, strings.Join(types, ", "))
+			}
+		}
+		if f, ok := fields["variables"]; ok {
+			v.addf(f[0], "variables are only supported for type actions, not %s", strings.Join(types, ", "))
+		}
+	}
+
+	for _, name := range []string{"secrets", "variables"} {
+		f, ok := fields[name]
+		if !ok || !v.expectKind(f[1], name, yaml.MappingNode, "") {
+			continue
+		}
+		seen := make(map[string]bool)
+		for key, kv := range v.mappingFields(f[1], name, nil) {
+			if err := validateKeyName(key); err != nil {
+				v.addf(kv[0], "%v", err)
+			}
+			if seen[strings.ToUpper(key)] {
+				v.addf(kv[0], "duplicate key %q in %s, names are case-insensitive", key, name)
+			}
+			seen[strings.ToUpper(key)] = true
+			v.expectKind(kv[1], name+"."+key, yaml.ScalarNode, "")
+		}
+	}
+}
+
+// validateConfig checks the structure of a manifest and returns all problems found, ordered by position.
+func validateConfig(data []byte) []ConfigError {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return []ConfigError{{Line: 1, Column: 1, Message: err.Error()}}
+	}
+
+	v := &configValidator{}
+	if len(doc.Content) == 0 {
+		v.addf(&doc, "manifest is empty")
+		return v.errs
+	}
+
+	root := doc.Content[0]
+	if !v.expectKind(root, "manifest", yaml.MappingNode, "") {
+		return v.errs
+	}
+	fields := v.mappingFields(root, "manifest", []string{"jobs"})
+	jobs, ok := fields["jobs"]
+	if !ok {
+		v.addf(root, "manifest must define jobs")
+		return v.errs
+	}
+	if v.expectKind(jobs[1], "jobs", yaml.SequenceNode, "") {
+		if len(jobs[1].Content) == 0 {
+			v.addf(jobs[1], "jobs must not be empty")
+		}
+		for i, job := range jobs[1].Content {
+			v.validateJob(job, i)
+		}
+	}
+
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].Line != v.errs[j].Line {
+			return v.errs[i].Line < v.errs[j].Line
+		}
+		return v.errs[i].Column < v.errs[j].Column
+	})
+	return v.errs
+}
+
+// loadConfig reads the manifest at path. It's validated like by validate-config first, so all problems are
+// reported with their position before any API call is made.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if errs := validateConfig(data); len(errs) > 0 {
+		problems := make([]string, 0, len(errs))
+		for _, e := range errs {
+			problems = append(problems, fmt.Sprintf("%s:%s", path, e))
+		}
+		return nil, fmt.Errorf("invalid config:\n%s", strings.Join(problems, "\n"))
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// configRuns returns the syncs of all jobs of cfg, which add their results to report.
+// Every job is checked before anything is synced. The values of the jobs may reference the environment of the process.
+func configRuns(cfg *Config, args EnvArgs, client GitHubActionClient, report *Report) ([]*syncRun, error) {
+	runs := make([]*syncRun, 0, len(cfg.Jobs))
+	for i, job := range cfg.Jobs {
+		name := job.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i+1)
+		}
+		jobArgs, secrets, variables, err := job.apply(args, os.LookupEnv)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", name, err)
+		}
+		maskValues(secrets)
+		secrets, variables, err = prepareValues(jobArgs, secrets, variables)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", name, err)
+		}
+		run, err := newSyncRun(jobArgs, client, secrets, variables, report)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", name, err)
+		}
+		run.name = name
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// runValidateConfig validates the manifest of cmd and prints all problems found.
+// It returns the number of problems.
+func runValidateConfig(cmd *ValidateConfigCmd, w io.Writer) (int, error) {
+	data, err := os.ReadFile(cmd.Path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	errs := validateConfig(data)
+	for _, e := range errs {
+		fmt.Fprintf(w, "%s:%s\n", cmd.Path, e)
+	}
+	if len(errs) == 0 {
+		fmt.Fprintf(w, "%s: valid\n", cmd.Path)
+	}
+	return len(errs), nil
+}
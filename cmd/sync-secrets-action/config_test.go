@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidateConfig(t *testing.T) {
+	testCases := []struct {
+		name     string
+		manifest string
+		expected []string
+	}{
+		{
+			name: "Valid manifest",
+			manifest: `jobs:
+  - name: docker
+    query: "org:example topic:docker"
+    secrets:
+      DOCKER_USER: bot
+  - target: example/service
+    environment: production
+    prune: true
+    variables:
+      LOG_LEVEL: info
+`,
+			expected: nil,
+		},
+		{
+			name:     "Missing jobs",
+			manifest: "version: 1\n",
+			expected: []string{`1:1: unknown field "version" in manifest`, "1:1: manifest must define jobs"},
+		},
+		{
+			name: "Invalid job",
+			manifest: `jobs:
+  - target: example/service
+    query: org:example
+    type: dependabot
+    environment: production
+    prune: "yes"
+    secrets:
+      1TOKEN: value
+      GITHUB_TOKEN: value
+    unknown: value
+`,
+			expected: []string{
+				"2:5: job 1 must set exactly one of target, query, repos, or organization",
+				"5:5: environment is only supported for type actions, not dependabot",
+				"6:12: prune must be a boolean",
+				`8:7: invalid name "1TOKEN", names may only contain letters, digits, and underscores and must not start with a digit`,
+				`9:7: invalid name "GITHUB_TOKEN", names must not start with the GITHUB_ prefix`,
+				`10:5: unknown field "unknown" in job 1`,
+			},
+		},
+		{
+			name: "Matrix",
+			manifest: `jobs:
+  - target: example/service
+    types: [actions, dependabot]
+    environments: [staging, production]
+    variables:
+      LOG_LEVEL: info
+  - target: example/docs
+    type: actions
+    types: [dependabot, pages]
+    environments: []
+    variables:
+      LOG_LEVEL: info
+`,
+			expected: []string{
+				"9:5: types cannot be combined with type",
+				`9:25: unsupported type "pages", must be one of: actions, dependabot, codespaces`,
+				"10:19: environments must not be empty",
+			},
+		},
+		{
+			name:     "Matrix without actions",
+			manifest: "jobs:\n  - target: example/service\n    types: [dependabot, codespaces]\n    environments: [production]\n    variables:\n      LOG_LEVEL: info\n",
+			expected: []string{
+				"4:5: environments is only supported for type actions, not dependabot, codespaces",
+				"5:5: variables are only supported for type actions, not dependabot, codespaces",
+			},
+		},
+		{
+			name:     "Organization",
+			manifest: "jobs:\n  - organization: example\n    target: example/service\n",
+			expected: []string{
+				"2:5: job 1 must set exactly one of target, query, repos, or organization",
+				"2:5: organization is only supported for type codespaces, not actions",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var result []string
+			for _, err := range validateConfig([]byte(tc.manifest)) {
+				result = append(result, err.Error())
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected errors:\n%v\ngot:\n%v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestConfigRuns(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+    environments: [production]
+  - name: example/docs
+  - name: example/api
+    environments: [staging, production]
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mock, err := newMockServer(fixture)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server := httptest.NewServer(mock.handler())
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+	client := NewGitHubAPI(context.Background(), ClientOptions{Token: "mock", BaseURL: baseURL})
+
+	t.Setenv("SERVICE_TOKEN", "secret")
+	path := filepath.Join(t.TempDir(), "sync.yaml")
+	manifest := `jobs:
+  - name: service
+    target: example/service
+    environment: production
+    secrets:
+      token: ${SERVICE_TOKEN}
+  - target: example/docs
+    variables:
+      HOST: example.com
+  - target: example/api
+    types: [actions, dependabot]
+    environments: [staging, production]
+    secrets:
+      TOKEN: ${SERVICE_TOKEN}
+`
+	if err := os.WriteFile(path, []byte(manifest), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	report := &Report{}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	runs, err := configRuns(cfg, EnvArgs{Type: string(Dependabot), Order: "alpha", Concurrency: 1}, client, report)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, run := range runs {
+		if err := run.run(context.Background()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	var targets []string
+	for _, result := range report.Repositories {
+		targets = append(targets, result.Target())
+	}
+	expected := []string{
+		"example/service (environment production)",
+		"example/docs",
+		"example/api (environment staging)",
+		"example/api (environment production)",
+		"example/api (dependabot)",
+	}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Errorf("Expected targets %v, got: %v", expected, targets)
+	}
+	if keys := report.Repositories[0].Keys; len(keys) != 1 || keys[0].Name != "TOKEN" {
+		t.Errorf("Expected the secret TOKEN to be synced, got: %+v", keys)
+	}
+
+	if err := os.WriteFile(path, []byte("jobs:\n  - target: example/docs\n    type: pages\n"), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = loadConfig(path)
+	if expected := "invalid config:\n" + path + `:3:11: unsupported type "pages", must be one of: actions, dependabot, codespaces`; err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got: %v", expected, err)
+	}
+
+	if err := os.WriteFile(path, []byte("jobs:\n  - target: example/docs\n    secrets:\n      TOKEN: ${MISSING_TOKEN}\n"), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg, err = loadConfig(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = configRuns(cfg, EnvArgs{Type: string(Actions), Order: "alpha"}, client, &Report{})
+	if expected := "job 1: secret TOKEN: environment variable MISSING_TOKEN isn't set"; err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got: %v", expected, err)
+	}
+}
+
+func TestConfigJobPrune(t *testing.T) {
+	testCases := []struct {
+		name     string
+		job      string
+		prune    bool
+		expected bool
+	}{
+		{name: "Unset keeps the argument", job: "target: example/docs", prune: true, expected: true},
+		{name: "Unset without argument", job: "target: example/docs", expected: false},
+		{name: "Disabled by the job", job: "target: example/docs\nprune: false", prune: true, expected: false},
+		{name: "Enabled by the job", job: "target: example/docs\nprune: true", expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var job ConfigJob
+			if err := yaml.Unmarshal([]byte(tc.job), &job); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			args, _, _, err := job.apply(EnvArgs{Prune: tc.prune}, os.LookupEnv)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if args.Prune != tc.expected {
+				t.Errorf("Expected prune %v, got %v", tc.expected, args.Prune)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// defaultCooldown is how long to wait after a secondary rate limit if the API didn't say, as GitHub recommends.
+const defaultCooldown = time.Minute
+
+// cooldown persists the end of a secondary rate limit penalty in the cache directory, so that subsequent runs,
+// e.g. retried workflows, don't extend the penalty by sending requests before it's over.
+// Penalties apply per token, so the file is named after the hashed token. A nil cooldown records nothing.
+type cooldown struct {
+	path string
+}
+
+// newCooldown returns the cooldown of token in dir.
+func newCooldown(dir, token string) *cooldown {
+	sum := sha256.Sum256([]byte(token))
+	return &cooldown{path: filepath.Join(dir, "cooldown-"+hex.EncodeToString(sum[:8]))}
+}
+
+// load returns the end of the recorded penalty, false if there is none.
+func (c *cooldown) load() (time.Time, bool, error) {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	until, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid cooldown %s: %w", c.path, err)
+	}
+	return until, true, nil
+}
+
+// record saves the end of the penalty if err was caused by a secondary rate limit.
+// Later penalties replace earlier ones, which are over by then.
+func (c *cooldown) record(ctx context.Context, err error) {
+	var abuseErr *github.AbuseRateLimitError
+	if c == nil || !errors.As(err, &abuseErr) {
+		return
+	}
+	until := time.Now().Add(abuseErr.GetRetryAfter())
+	if abuseErr.RetryAfter == nil {
+		until = time.Now().Add(defaultCooldown)
+	}
+	if err := c.save(until); err != nil {
+		loggerFrom(ctx).Printf("Failed to record the secondary rate limit cooldown: %v", err)
+		return
+	}
+	loggerFrom(ctx).Printf("Recorded the secondary rate limit cooldown until %s, later runs wait for it to pass", until.Format(time.RFC3339))
+}
+
+// save writes until to the file of the cooldown, replacing it atomically.
+func (c *cooldown) save(until time.Time) error {
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(until.UTC().Format(time.RFC3339) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
+
+// await blocks until a penalty recorded by an earlier run is over. With RateLimitFail, or if the penalty lasts longer
+// than maxWait, it returns a RateLimitExceededError instead.
+func (c *cooldown) await(ctx context.Context, policy RateLimitPolicy, maxWait time.Duration) error {
+	until, ok, err := c.load()
+	if err != nil {
+		loggerFrom(ctx).Printf("Ignoring the secondary rate limit cooldown: %v", err)
+		return nil
+	}
+	remaining := time.Until(until)
+	if !ok || remaining <= 0 {
+		return nil
+	}
+	if policy == RateLimitFail || (maxWait > 0 && remaining > maxWait) {
+		return &RateLimitExceededError{Resource: "secondary", Reset: until}
+	}
+	logger := loggerFrom(ctx)
+	logger.Printf("An earlier run hit a secondary rate limit. Waiting for %v before sending any request", remaining.Round(time.Second))
+	return waitWithProgress(ctx, logger, remaining, rateLimitWaitProgressInterval)
+}
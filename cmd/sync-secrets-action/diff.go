@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// DiffCmd compares Actions variables between two repositories or environments.
+type DiffCmd struct {
+	Left  string
+	Right string
+}
+
+// variableSource identifies a set of Actions variables, either of a repository or one of its environments.
+type variableSource struct {
+	Owner       string
+	Repo        string
+	Environment string
+}
+
+// String returns a human-readable representation of the source.
+func (s variableSource) String() string {
+	if s.Environment == "" {
+		return s.Owner + "/" + s.Repo
+	}
+	return fmt.Sprintf("%s/%s (environment %s)", s.Owner, s.Repo, s.Environment)
+}
+
+// parseVariableSource parses a source in the form owner/repo or owner/repo:environment.
+func parseVariableSource(spec string) (variableSource, error) {
+	fullName, environment, _ := strings.Cut(spec, ":")
+	owner, repo, ok := strings.Cut(fullName, "/")
+	if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return variableSource{}, fmt.Errorf("invalid source %q, expected owner/repo or owner/repo:environment", spec)
+	}
+	return variableSource{Owner: owner, Repo: repo, Environment: environment}, nil
+}
+
+// fetchVariables reads all Actions variables of the given source.
+func fetchVariables(ctx context.Context, client GitHubActionClient, source variableSource, perPage int) (map[string]string, error) {
+	values := make(map[string]string)
+
+	opts := &github.ListOptions{PerPage: perPage}
+	for {
+		var variables *github.ActionsVariables
+		var resp *github.Response
+		var err error
+		if source.Environment == "" {
+			variables, resp, err = client.ListRepoVariables(ctx, source.Owner, source.Repo, opts)
+		} else {
+			variables, resp, err = client.ListEnvVariables(ctx, source.Owner, source.Repo, source.Environment, opts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list variables of %s: %w", source, err)
+		}
+
+		for _, variable := range variables.Variables {
+			values[variable.Name] = variable.Value
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return values, nil
+}
+
+// variableDiff describes a single difference between two sets of variables.
+type variableDiff struct {
+	Key   string
+	Left  *string
+	Right *string
+}
+
+// diffVariables returns the differences between left and right, sorted by key.
+func diffVariables(left, right map[string]string) []variableDiff {
+	var diffs []variableDiff
+	for key, leftValue := range left {
+		rightValue, ok := right[key]
+		switch {
+		case !ok:
+			diffs = append(diffs, variableDiff{Key: key, Left: github.Ptr(leftValue)})
+		case leftValue != rightValue:
+			diffs = append(diffs, variableDiff{Key: key, Left: github.Ptr(leftValue), Right: github.Ptr(rightValue)})
+		}
+	}
+	for key, rightValue := range right {
+		if _, ok := left[key]; !ok {
+			diffs = append(diffs, variableDiff{Key: key, Right: github.Ptr(rightValue)})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Key < diffs[j].Key
+	})
+	return diffs
+}
+
+// printVariableDiffs writes the differences in a unified-diff like format.
+func printVariableDiffs(w io.Writer, leftName, rightName string, diffs []variableDiff) {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", leftName, rightName)
+	if len(diffs) == 0 {
+		fmt.Fprintln(w, "No differences.")
+		return
+	}
+	for _, d := range diffs {
+		switch {
+		case d.Right == nil:
+			fmt.Fprintf(w, "- %s=%q\n", d.Key, *d.Left)
+		case d.Left == nil:
+			fmt.Fprintf(w, "+ %s=%q\n", d.Key, *d.Right)
+		default:
+			fmt.Fprintf(w, "~ %s: %q -> %q\n", d.Key, *d.Left, *d.Right)
+		}
+	}
+}
+
+// runDiff compares the variables of the sources configured in cmd and prints the differences.
+func runDiff(ctx context.Context, cmd *DiffCmd, args EnvArgs, client GitHubActionClient, w io.Writer) error {
+	leftSource, err := parseVariableSource(cmd.Left)
+	if err != nil {
+		return err
+	}
+	left, err := fetchVariables(ctx, client, leftSource, args.PerPage)
+	if err != nil {
+		return err
+	}
+
+	rightName := "input"
+	var right map[string]string
+	if cmd.Right != "" {
+		rightSource, err := parseVariableSource(cmd.Right)
+		if err != nil {
+			return err
+		}
+		right, err = fetchVariables(ctx, client, rightSource, args.PerPage)
+		if err != nil {
+			return err
+		}
+		rightName = rightSource.String()
+	} else {
+		right, err = parseKeyValuePairs(args.Variables, newParseOptions(args))
+		if err != nil {
+			return fmt.Errorf("error parsing variables: %w", err)
+		}
+	}
+
+	printVariableDiffs(w, leftSource.String(), rightName, diffVariables(left, right))
+	return nil
+}
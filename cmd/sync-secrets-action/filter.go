@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/go-github/v68/github"
+)
+
+// repoFilter is a compiled CEL expression selecting repositories by their attributes.
+type repoFilter struct {
+	program cel.Program
+	// details is set if the expression refers to custom properties, which search results lack.
+	details bool
+}
+
+// compileRepoFilter compiles a filter expression. The expression refers to the attributes of a repository
+// through the repo variable, e.g. !repo.archived && "docker" in repo.topics, and must evaluate to a bool.
+func compileRepoFilter(expr string) (*repoFilter, error) {
+	env, err := cel.NewEnv(cel.Variable("repo", cel.MapType(cel.StringType, cel.DynType)))
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expr)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expression must evaluate to a bool, not %s", ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+	return &repoFilter{program: program, details: strings.Contains(expr, "custom_properties")}, nil
+}
+
+// match reports whether the filter selects the repository.
+func (f *repoFilter) match(repo *github.Repository) (bool, error) {
+	out, _, err := f.program.Eval(map[string]any{"repo": repoAttributes(repo)})
+	if err != nil {
+		return false, err
+	}
+	matched, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression evaluated to %v instead of a bool", out.Value())
+	}
+	return matched, nil
+}
+
+// repoAttributes returns the attributes of a repository available to filters, named as in the API.
+// Attributes missing from the API response take their zero value.
+func repoAttributes(repo *github.Repository) map[string]any {
+	visibility := repo.GetVisibility()
+	if visibility == "" {
+		visibility = "public"
+		if repo.GetPrivate() {
+			visibility = "private"
+		}
+	}
+	topics := repo.Topics
+	if topics == nil {
+		topics = []string{}
+	}
+	properties := repo.CustomProperties
+	if properties == nil {
+		properties = map[string]any{}
+	}
+	return map[string]any{
+		"name":              repo.GetName(),
+		"full_name":         repo.GetOwner().GetLogin() + "/" + repo.GetName(),
+		"owner":             repo.GetOwner().GetLogin(),
+		"topics":            topics,
+		"visibility":        visibility,
+		"archived":          repo.GetArchived(),
+		"fork":              repo.GetFork(),
+		"language":          repo.GetLanguage(),
+		"default_branch":    repo.GetDefaultBranch(),
+		"pushed_at":         repo.GetPushedAt().Time,
+		"created_at":        repo.GetCreatedAt().Time,
+		"custom_properties": properties,
+	}
+}
+
+// filterRepositories filters all repositories the filter doesn't select from repos.
+// Repositories the expression fails to evaluate for, e.g. because of a missing custom property, aren't selected.
+// Custom properties are only part of single repositories, so they're looked up if the expression needs them.
+func filterRepositories(ctx context.Context, client GitHubActionClient, repos iter.Seq2[*github.Repository, error], filter *repoFilter) iter.Seq2[*github.Repository, error] {
+	return func(yield func(*github.Repository, error) bool) {
+		for repo, err := range repos {
+			if err == nil {
+				fullName := repo.GetOwner().GetLogin() + "/" + repo.GetName()
+				attributes := repo
+				if filter.details && repo.CustomProperties == nil {
+					details, _, getErr := client.GetRepository(ctx, repo.GetOwner().GetLogin(), repo.GetName())
+					if getErr != nil {
+						yield(nil, fmt.Errorf("failed to get repository %s: %w", fullName, getErr))
+						return
+					}
+					attributes = details
+				}
+				matched, evalErr := filter.match(attributes)
+				if evalErr != nil {
+					loggerFrom(ctx).Printf("Skipping %s: failed to evaluate filter: %v\n", fullName, evalErr)
+					continue
+				}
+				if !matched {
+					loggerFrom(ctx).Printf("Skipping %s: doesn't match filter\n", fullName)
+					continue
+				}
+			}
+			if !yield(repo, err) {
+				return
+			}
+		}
+	}
+}
@@ -2,7 +2,17 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v5"
@@ -19,96 +29,360 @@ type GitHubActionClient interface {
 	GitHubEnvSecrets
 	GitHubDependabotSecrets
 	GitHubCodespacesSecrets
+	GitHubOrgCodespacesSecrets
+}
+
+// ClientOptions configures the GitHub API client created by NewGitHubAPI.
+type ClientOptions struct {
+	Token                 string
+	MaxRetries            int
+	RateLimitCheckEnabled bool
+	RateLimitPolicy       RateLimitPolicy
+	RateLimitMaxWait      time.Duration
+	DryRunEnabled         bool
+
+	// HTTPTimeout limits the time of a single request including reading the response body, zero means no limit.
+	HTTPTimeout time.Duration
+	// MaxIdleConns limits the number of idle connections kept open for reuse.
+	MaxIdleConns int
+	// KeepAlive is the interval of TCP keep-alive probes, zero means the default of net.Dialer.
+	KeepAlive time.Duration
+	// DisableKeepAlives opens a new connection for every request instead of reusing idle ones.
+	DisableKeepAlives bool
+	// CacheDir is the directory responses of GET requests are cached in between runs, empty disables caching.
+	CacheDir string
+	// PerPage is the number of items requested per page of list and search operations, zero means defaultPerPage.
+	PerPage int
+	// Usage records the requests sent to the API and the reported rate limits, if set.
+	Usage *apiUsage
+	// BaseURL of the API, e.g. of the mock server or a GHES instance, nil means github.com.
+	BaseURL *url.URL
+	// UploadURL of the API, nil means github.com.
+	UploadURL *url.URL
+	// RootCAs are the certificate authorities trusted for the API, nil means those of the system.
+	RootCAs *x509.CertPool
+	// InsecureSkipTLSVerify disables the verification of the API's certificate, leaving the connection open to
+	// interception. It only exists for GHES instances with certificates that can't be verified otherwise.
+	InsecureSkipTLSVerify bool
 }
 
 // NewGitHubAPI initializes a new GitHub API client with optional features like rate limit checking and dry run capabilities.
 // It returns an instance of GitHubActionClient, which aggregates various GitHub API functionalities.
-func NewGitHubAPI(ctx context.Context, token string, maxRetries int, rateLimitCheckEnabled, dryRunEnabled bool) GitHubActionClient {
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+func NewGitHubAPI(ctx context.Context, opts ClientOptions) GitHubActionClient {
+	usage := opts.Usage
+	if usage == nil {
+		usage = newAPIUsage()
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, newHTTPClient(opts, usage))
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: opts.Token})
 	tc := oauth2.NewClient(ctx, ts)
+	tc.Timeout = opts.HTTPTimeout
 	client := github.NewClient(tc)
+	if opts.BaseURL != nil {
+		client.BaseURL = opts.BaseURL
+	}
+	if opts.UploadURL != nil {
+		client.UploadURL = opts.UploadURL
+	}
 
-	apiClient := newGitHubAPI(client, dryRunEnabled)
-	apiClient = newRetryableGitHubAPI(apiClient, uint64(maxRetries))
+	perPage := opts.PerPage
+	if perPage == 0 {
+		perPage = defaultPerPage
+	}
+	apiClient := newGitHubAPI(client, opts.DryRunEnabled, perPage)
+	var cool *cooldown
+	if opts.CacheDir != "" {
+		cool = newCooldown(opts.CacheDir, opts.Token)
+	}
+	apiClient = newRetryableGitHubAPI(apiClient, uint64(opts.MaxRetries), cool)
 
-	if rateLimitCheckEnabled {
-		apiClient = newRateLimitedGitHubAPI(apiClient)
+	if opts.RateLimitCheckEnabled {
+		apiClient = newRateLimitedGitHubAPI(apiClient, usage.tracker, opts.RateLimitPolicy, opts.RateLimitMaxWait)
 	}
 
 	return apiClient
 }
 
-// gitHubAPI is an internal implementation of GitHubActionClient that holds a GitHub client and a flag indicating if dry run is enabled.
+// runnerAPIURL returns the API URL the Actions runner exposes in GITHUB_API_URL, or else GITHUB_SERVER_URL, along
+// with the name of the variable, when the workflow runs on a GitHub Enterprise Server instance. This makes the action
+// work on enterprise runners without base-url. Outside of Actions and on github.com, it returns an empty string.
+func runnerAPIURL() (string, string) {
+	for _, env := range []string{"GITHUB_API_URL", "GITHUB_SERVER_URL"} {
+		value := strings.TrimSuffix(os.Getenv(env), "/")
+		switch value {
+		case "":
+			continue
+		case strings.TrimSuffix(defaultAPIURL, "/"), "https://github.com":
+			return "", ""
+		}
+		return value, env
+	}
+	return "", ""
+}
+
+// enterpriseURLs returns the API and upload URLs of a GitHub Enterprise Server instance given by baseURL, e.g.
+// https://github.example.com, completed with /api/v3/ and /api/uploads/ like github.NewEnterpriseClient does.
+// Without uploadURL, uploads go to the host of baseURL. GitHub Enterprise Cloud with data residency serves the API of
+// a host like octocorp.ghe.com from api.octocorp.ghe.com without path prefix, so such hosts are mapped to it.
+func enterpriseURLs(baseURL, uploadURL string) (*url.URL, *url.URL, error) {
+	if uploadURL == "" {
+		uploadURL = strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/api/v3")
+	}
+	urls := []string{baseURL, uploadURL}
+	for i, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return nil, nil, fmt.Errorf("invalid GitHub Enterprise Server URL %q, must be an http or https URL like https://github.example.com", u)
+		}
+		if host := parsed.Hostname(); strings.HasSuffix(host, ".ghe.com") && !strings.HasPrefix(host, "api.") {
+			parsed.Host = "api." + parsed.Host
+			urls[i] = parsed.String()
+		}
+	}
+	client, err := github.NewClient(nil).WithEnterpriseURLs(urls[0], urls[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.BaseURL, client.UploadURL, nil
+}
+
+// newHTTPClient returns the HTTP client underlying the authenticated GitHub client, with the connection settings of opts.
+// All requests and the rate limit state of their responses are recorded in usage.
+func newHTTPClient(opts ClientOptions, usage *apiUsage) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: opts.KeepAlive,
+	}).DialContext
+	transport.MaxIdleConns = opts.MaxIdleConns
+	// All requests go to the same host, so the pool doesn't need to be shared between hosts.
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConns
+	transport.DisableKeepAlives = opts.DisableKeepAlives
+	if opts.RootCAs != nil || opts.InsecureSkipTLSVerify {
+		transport.TLSClientConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			RootCAs:            opts.RootCAs,
+			InsecureSkipVerify: opts.InsecureSkipTLSVerify,
+		}
+	}
+	var rt http.RoundTripper = &usageTransport{
+		base:  &rateLimitTransport{base: transport, tracker: usage.tracker},
+		usage: usage,
+	}
+	if opts.CacheDir != "" {
+		// The cache wraps the tracking transports, so revalidations are still counted and observed.
+		rt = &cacheTransport{base: rt, dir: opts.CacheDir}
+	}
+	return &http.Client{Transport: rt}
+}
+
+// loadRootCAs returns the certificate authorities of the system extended by those of the PEM bundle at path,
+// e.g. the private PKI of a GHES instance behind a TLS-intercepting proxy.
+func loadRootCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM encoded certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// gitHubAPI is an internal implementation of GitHubActionClient that holds a GitHub client, a flag indicating if dry run
+// is enabled, and the page size of list operations. Dry run output goes to the logger of the context of each call.
 type gitHubAPI struct {
 	client        *github.Client
 	dryRunEnabled bool
+	perPage       int
 }
 
-// newGitHubAPI creates a new instance of gitHubAPI with the specified GitHub client and dry run flag.
-func newGitHubAPI(client *github.Client, dryRunEnabled bool) GitHubActionClient {
+// newGitHubAPI creates a new instance of gitHubAPI with the specified GitHub client, dry run flag, and page size.
+func newGitHubAPI(client *github.Client, dryRunEnabled bool, perPage int) GitHubActionClient {
 	return &gitHubAPI{
 		client:        client,
 		dryRunEnabled: dryRunEnabled,
+		perPage:       perPage,
 	}
 }
 
+// RateLimitPolicy defines how the rate limiting decorator reacts when the rate limit is close to being exceeded.
+type RateLimitPolicy string
+
+const (
+	// RateLimitWait blocks until the rate limit resets.
+	RateLimitWait RateLimitPolicy = "wait"
+	// RateLimitFail aborts the operation with a RateLimitExceededError.
+	RateLimitFail RateLimitPolicy = "fail"
+)
+
+// RateLimitExceededError is returned instead of waiting for the rate limit to reset with RateLimitFail,
+// or if the reset is further away than the maximum wait.
+type RateLimitExceededError struct {
+	Resource string
+	Reset    time.Time
+}
+
+func (e *RateLimitExceededError) Error() string {
+	return fmt.Sprintf("GitHub API %s rate limit close to being exceeded, resets at %s", e.Resource, e.Reset.Format(time.RFC3339))
+}
+
 // rateLimitedGitHubAPI is a decorator for GitHubActionClient that adds rate limiting functionality.
 type rateLimitedGitHubAPI struct {
-	client GitHubActionClient
+	client  GitHubActionClient
+	tracker *rateLimitTracker
+	policy  RateLimitPolicy
+	maxWait time.Duration
+	// disabled is set once the API turned out to have no rate limits, e.g. GHES with rate limiting turned off.
+	disabled atomic.Bool
 }
 
 // newRateLimitedGitHubAPI wraps a given GitHubActionClient with rate limiting functionality.
-func newRateLimitedGitHubAPI(client GitHubActionClient) GitHubActionClient {
-	return &rateLimitedGitHubAPI{client: client}
+// The rate limit state is taken from tracker, which must observe the responses of the client.
+// A maxWait of zero waits as long as it takes for the rate limit to reset.
+func newRateLimitedGitHubAPI(client GitHubActionClient, tracker *rateLimitTracker, policy RateLimitPolicy, maxWait time.Duration) GitHubActionClient {
+	return &rateLimitedGitHubAPI{client: client, tracker: tracker, policy: policy, maxWait: maxWait}
 }
 
-// waitForRateLimitReset blocks until the GitHub API rate limit resets or an error occurs.
-// It logs the waiting time and periodically checks the rate limit status.
-func (g *rateLimitedGitHubAPI) waitForRateLimitReset(ctx context.Context) {
-	const rateLimitedMessage = "GitHub API rate limit close to being exceeded. Waiting for reset..."
+// rateLimitWaitProgressInterval is the interval in which the remaining waiting time is logged.
+const rateLimitWaitProgressInterval = time.Minute
+
+// waitForRateLimitReset blocks until the GitHub API rate limit of the resource resets, the context is cancelled,
+// or the remaining time exceeds the maximum wait. It logs the waiting time and periodically checks the rate limit status.
+func (g *rateLimitedGitHubAPI) waitForRateLimitReset(ctx context.Context, resource string) error {
+	rateLimitedMessage := fmt.Sprintf("GitHub API %s rate limit close to being exceeded. Waiting for reset...", resource)
 	for {
 		rateLimits, _, err := g.client.Ratelimits(ctx)
 		if err != nil {
-			log.Printf("Error fetching rate limits: %v", err)
-			return
+			g.handleRatelimitsError(ctx, err)
+			return nil
+		}
+		rate := resourceRate(rateLimits, resource)
+		if rate == nil {
+			return nil
 		}
+		g.tracker.update(resource, *rate)
 
-		coreRate := rateLimits.GetCore()
-		resetTime := coreRate.Reset.Time
+		resetTime := rate.Reset.Time
 		timeToWait := time.Until(resetTime)
+		if timeToWait <= 0 {
+			return nil
+		}
+		if g.maxWait > 0 && timeToWait > g.maxWait {
+			loggerFrom(ctx).Printf("The %s rate limit resets in %v, which exceeds the maximum wait of %v", resource, timeToWait.Round(time.Second), g.maxWait)
+			return &RateLimitExceededError{Resource: resource, Reset: resetTime}
+		}
 
-		if timeToWait > 0 {
-			log.Printf("%s Waiting for %v", rateLimitedMessage, timeToWait)
-			time.Sleep(timeToWait + time.Second)
-		} else {
-			return
+		loggerFrom(ctx).Printf("%s Waiting for %v", rateLimitedMessage, timeToWait.Round(time.Second))
+		if err := waitWithProgress(ctx, loggerFrom(ctx), timeToWait+time.Second, rateLimitWaitProgressInterval); err != nil {
+			return err
 		}
 	}
 }
 
-// ensureRatelimits checks the current rate limit status and waits for a reset if limits are close to being exceeded.
-func (g *rateLimitedGitHubAPI) ensureRatelimits(ctx context.Context) {
-	rateLimitStatus, _, err := g.client.Ratelimits(ctx)
-	if err != nil {
-		log.Printf("Error fetching rate limit status: %v", err)
-		return
+// waitWithProgress blocks for the given duration or until the context is cancelled, logging the remaining time
+// every interval.
+func waitWithProgress(ctx context.Context, logger Logger, d, interval time.Duration) error {
+	deadline := time.Now().Add(d)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for rate limit reset: %w", ctx.Err())
+		case <-timer.C:
+			return nil
+		case <-ticker.C:
+			logger.Printf("Waiting for rate limit reset, %v remaining", time.Until(deadline).Round(time.Second))
+		}
+	}
+}
+
+// ensureRatelimits checks the core rate limit, which applies to all but the search endpoints.
+func (g *rateLimitedGitHubAPI) ensureRatelimits(ctx context.Context) error {
+	return g.ensureResourceRatelimits(ctx, coreResource)
+}
+
+// ensureSearchRatelimits checks the search rate limit, which has a much smaller quota and a shorter reset window.
+func (g *rateLimitedGitHubAPI) ensureSearchRatelimits(ctx context.Context) error {
+	return g.ensureResourceRatelimits(ctx, searchResource)
+}
+
+// ensureResourceRatelimits checks the current rate limit status of a resource and, if limits are close to
+// being exceeded, waits for a reset or fails depending on the policy.
+// The status is taken from the headers of previous responses and only queried if it's unknown.
+// Requests on behalf of an owner, see withOwner, first wait for a secondary rate limit hit by another request to it.
+func (g *rateLimitedGitHubAPI) ensureResourceRatelimits(ctx context.Context, resource string) error {
+	if g.disabled.Load() {
+		return nil
+	}
+	if owner := ownerFrom(ctx); owner != "" {
+		if until, ok := g.tracker.owner(owner).blockedUntil(); ok {
+			if g.policy == RateLimitFail {
+				return &RateLimitExceededError{Resource: "secondary", Reset: until}
+			}
+			loggerFrom(ctx).Printf("The secondary rate limit of %s is exceeded. Waiting for %v", owner, time.Until(until).Round(time.Second))
+			if err := waitWithProgress(ctx, loggerFrom(ctx), time.Until(until), rateLimitWaitProgressInterval); err != nil {
+				return err
+			}
+		}
+	}
+	rate, ok := g.tracker.rate(resource)
+	if !ok {
+		rateLimitStatus, _, err := g.client.Ratelimits(ctx)
+		if err != nil {
+			g.handleRatelimitsError(ctx, err)
+			return nil
+		}
+		r := resourceRate(rateLimitStatus, resource)
+		if r == nil {
+			return nil
+		}
+		rate = *r
+		g.tracker.update(resource, rate)
+	}
+
+	if float64(rate.Remaining)/float64(rate.Limit) <= 0.05 {
+		if g.policy == RateLimitFail {
+			return &RateLimitExceededError{Resource: resource, Reset: rate.Reset.Time}
+		}
+		return g.waitForRateLimitReset(ctx, resource)
 	}
+	return nil
+}
 
-	coreRate := rateLimitStatus.Core
-	if float64(coreRate.Remaining)/float64(coreRate.Limit) <= 0.05 {
-		g.waitForRateLimitReset(ctx)
+// handleRatelimitsError logs the failure to fetch the rate limit status. GHES instances with rate limiting disabled
+// answer with 404, in which case the checks are disabled for the rest of the run.
+func (g *rateLimitedGitHubAPI) handleRatelimitsError(ctx context.Context, err error) {
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+		if !g.disabled.Swap(true) {
+			loggerFrom(ctx).Printf("Rate limiting is disabled on this GitHub instance, skipping rate limit checks")
+		}
+		return
 	}
+	loggerFrom(ctx).Printf("Error fetching rate limit status: %v", err)
 }
 
 // retryableGitHubAPI is a decorator for GitHubActionClient that adds retry functionality using exponential backoff.
 type retryableGitHubAPI struct {
 	client         GitHubActionClient
 	backoffOptions []backoff.RetryOption
+	// cooldown records secondary rate limits that persisted through all retries, if set.
+	cooldown *cooldown
 }
 
-func newRetryableGitHubAPI(client GitHubActionClient, maxRetries uint64) GitHubActionClient {
+func newRetryableGitHubAPI(client GitHubActionClient, maxRetries uint64, cooldown *cooldown) GitHubActionClient {
 	var api GitHubActionClient = &retryableGitHubAPI{
-		client: client,
+		client:   client,
+		cooldown: cooldown,
 		backoffOptions: []backoff.RetryOption{
 			backoff.WithMaxElapsedTime(backoff.DefaultMaxElapsedTime),
 			backoff.WithMaxTries(uint(maxRetries)),
@@ -117,3 +391,22 @@ func newRetryableGitHubAPI(client GitHubActionClient, maxRetries uint64) GitHubA
 	}
 	return api
 }
+
+// retry runs operation with backoff. Every failed attempt that is retried is logged at debug level with the reason,
+// and errors of operations that were attempted more than once name the number of attempts.
+func (r *retryableGitHubAPI) retry(ctx context.Context, name string, operation backoff.Operation[bool]) error {
+	attempts := 0
+	attempt := func() (bool, error) {
+		attempts++
+		return operation()
+	}
+	notify := func(err error, wait time.Duration) {
+		debugf(ctx, "%s failed on attempt %d (%s), retrying in %s: %v", name, attempts, retryReason(err), wait.Round(time.Millisecond), err)
+	}
+	_, err := backoff.Retry(ctx, attempt, append(slices.Clip(r.backoffOptions), backoff.WithNotify(notify))...)
+	r.cooldown.record(ctx, err)
+	if err != nil && attempts > 1 {
+		return fmt.Errorf("%w (after %d attempts)", err, attempts)
+	}
+	return err
+}
@@ -2,10 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 
-	"github.com/cenkalti/backoff/v5"
 	"github.com/google/go-github/v68/github"
 )
 
@@ -41,9 +40,16 @@ func (api *gitHubAPI) ListCodespacesSecrets(ctx context.Context, owner, repo str
 
 func (api *gitHubAPI) PutCodespacesSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	if api.dryRunEnabled {
-		log.Printf("Dry run: Putting codespaces secrets for repo %s/%s\n", owner, repo)
-		for secretName := range mappings {
-			log.Printf("Dry run: Would put codespaces secret '%s' in repo %s/%s\n", secretName, owner, repo)
+		loggerFrom(ctx).Printf("Dry run: Putting codespaces secrets for repo %s/%s\n", owner, repo)
+		existing, err := secretNames(api.perPage, func(opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
+			return api.ListCodespacesSecrets(ctx, owner, repo, opts)
+		})
+		if err != nil {
+			return fmt.Errorf("dry run: failed to list existing Codespaces secrets: %w", err)
+		}
+		for _, secretName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: Would put codespaces secret '%s' in repo %s/%s\n", secretName, owner, repo)
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: keyOutcome(existing, secretName)})
 		}
 		return nil
 	}
@@ -53,34 +59,42 @@ func (api *gitHubAPI) PutCodespacesSecrets(ctx context.Context, owner, repo stri
 		return err
 	}
 
-	for secretName, secretValue := range mappings {
+	var errs []error
+	for _, secretName := range sortedKeys(mappings) {
+		secretValue := mappings[secretName]
 		encryptedSecret, err := encryptSecretWithPublicKey(publicKey, secretName, secretValue)
 		if err != nil {
-			return err
+			errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to encrypt secret %s: %w", secretName, err)))
+			continue
 		}
 
-		_, err = api.CreateOrUpdateCodespacesSecret(ctx, owner, repo, encryptedSecret)
+		resp, err := api.CreateOrUpdateCodespacesSecret(ctx, owner, repo, encryptedSecret)
 		if err != nil {
-			return err
+			errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to update Codespaces secret %s in repo %s/%s: %w", secretName, owner, repo, err)))
+			continue
 		}
+		recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: writeOutcome(resp)})
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 // PutCodespacesSecrets creates or updates multiple Codespaces secrets for a repository.
 func (api *gitHubAPI) SyncCodespacesSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	if api.dryRunEnabled {
-		log.Printf("Dry run: Syncing Codespaces secrets for repo %s/%s", owner, repo)
-		opts := &github.ListOptions{PerPage: 100}
+		loggerFrom(ctx).Printf("Dry run: Syncing Codespaces secrets for repo %s/%s", owner, repo)
+		existing := make(map[string]bool)
+		opts := &github.ListOptions{PerPage: api.perPage}
 		for {
 			secrets, resp, err := api.ListCodespacesSecrets(ctx, owner, repo, opts)
 			if err != nil {
-				return fmt.Errorf("dry run: failed to list existing Codespaces secrets: %v", err)
+				return fmt.Errorf("dry run: failed to list existing Codespaces secrets: %w", err)
 			}
 
 			for _, secret := range secrets.Secrets {
-				if _, exists := mappings[secret.Name]; !exists {
-					log.Printf("Dry run: Would delete Codespaces secret '%s' from repo %s/%s", secret.Name, owner, repo)
+				existing[secret.Name] = true
+				if shouldPrune(ctx, mappings, secret.Name) {
+					loggerFrom(ctx).Printf("Dry run: Would delete Codespaces secret '%s' from repo %s/%s", secret.Name, owner, repo)
+					recordKey(ctx, KeyResult{Kind: "secret", Name: secret.Name, Outcome: KeyDeleted})
 				}
 			}
 
@@ -90,8 +104,9 @@ func (api *gitHubAPI) SyncCodespacesSecrets(ctx context.Context, owner, repo str
 			opts.Page = resp.NextPage
 		}
 
-		for secretName := range mappings {
-			log.Printf("Dry run: Would add/update Codespaces secret '%s' in repo %s/%s", secretName, owner, repo)
+		for _, secretName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: Would add/update Codespaces secret '%s' in repo %s/%s", secretName, owner, repo)
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: keyOutcome(existing, secretName)})
 		}
 
 		return nil
@@ -99,7 +114,7 @@ func (api *gitHubAPI) SyncCodespacesSecrets(ctx context.Context, owner, repo str
 
 	existingMap := make(map[string]bool)
 
-	opts := &github.ListOptions{PerPage: 100}
+	opts := &github.ListOptions{PerPage: api.perPage}
 	for {
 		secrets, resp, err := api.ListCodespacesSecrets(ctx, owner, repo, opts)
 		if err != nil {
@@ -116,16 +131,19 @@ func (api *gitHubAPI) SyncCodespacesSecrets(ctx context.Context, owner, repo str
 		opts.Page = resp.NextPage
 	}
 
-	for secretName := range existingMap {
-		if _, exists := mappings[secretName]; !exists {
+	var errs []error
+	for _, secretName := range sortedKeys(existingMap) {
+		if shouldPrune(ctx, mappings, secretName) {
 			_, err := api.DeleteCodespacesSecret(ctx, owner, repo, secretName)
-			if err != nil {
-				return err
+			if err != nil && !alreadyDeleted(err) {
+				errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to delete Codespaces secret %s from repo %s/%s: %w", secretName, owner, repo, err)))
+				continue
 			}
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: KeyDeleted})
 		}
 	}
 
-	return api.PutCodespacesSecrets(ctx, owner, repo, mappings)
+	return errors.Join(append(errs, api.PutCodespacesSecrets(ctx, owner, repo, mappings))...)
 }
 
 // Below are rate limited and retryable implementations of the GitHubCodespacesSecrets interface methods.
@@ -134,32 +152,44 @@ func (api *gitHubAPI) SyncCodespacesSecrets(ctx context.Context, owner, repo str
 // Ratelimiting
 
 func (r *rateLimitedGitHubAPI) PutCodespacesSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
 	return r.client.PutCodespacesSecrets(ctx, owner, repo, mappings)
 }
 
 func (r *rateLimitedGitHubAPI) GetCodespacesPublicKey(ctx context.Context, owner, repo string) (*github.PublicKey, *github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
 	return r.client.GetCodespacesPublicKey(ctx, owner, repo)
 }
 
 func (r *rateLimitedGitHubAPI) CreateOrUpdateCodespacesSecret(ctx context.Context, owner, repo string, eSecret *github.EncryptedSecret) (*github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.CreateOrUpdateCodespacesSecret(ctx, owner, repo, eSecret)
 }
 
 func (r *rateLimitedGitHubAPI) DeleteCodespacesSecret(ctx context.Context, owner, repo, name string) (*github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.DeleteCodespacesSecret(ctx, owner, repo, name)
 }
 
 func (r *rateLimitedGitHubAPI) ListCodespacesSecrets(ctx context.Context, owner, repo string, opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
 	return r.client.ListCodespacesSecrets(ctx, owner, repo, opts)
 }
 
 func (r *rateLimitedGitHubAPI) SyncCodespacesSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
 	return r.client.SyncCodespacesSecrets(ctx, owner, repo, mappings)
 }
 
@@ -171,10 +201,10 @@ func (r *retryableGitHubAPI) CreateOrUpdateCodespacesSecret(ctx context.Context,
 
 	retryFunc := func() (bool, error) {
 		resp, err = r.client.CreateOrUpdateCodespacesSecret(ctx, owner, repo, eSecret)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "CreateOrUpdateCodespacesSecret", retryFunc)
 	return resp, err
 }
 
@@ -184,10 +214,10 @@ func (r *retryableGitHubAPI) DeleteCodespacesSecret(ctx context.Context, owner,
 
 	retryFunc := func() (bool, error) {
 		resp, err = r.client.DeleteCodespacesSecret(ctx, owner, repo, name)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "DeleteCodespacesSecret", retryFunc)
 	return resp, err
 }
 
@@ -198,10 +228,10 @@ func (r *retryableGitHubAPI) GetCodespacesPublicKey(ctx context.Context, owner,
 
 	retryFunc := func() (bool, error) {
 		publicKey, resp, err = r.client.GetCodespacesPublicKey(ctx, owner, repo)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "GetCodespacesPublicKey", retryFunc)
 	return publicKey, resp, err
 }
 
@@ -212,27 +242,27 @@ func (r *retryableGitHubAPI) ListCodespacesSecrets(ctx context.Context, owner, r
 
 	retryFunc := func() (bool, error) {
 		secrets, resp, err = r.client.ListCodespacesSecrets(ctx, owner, repo, opts)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "ListCodespacesSecrets", retryFunc)
 	return secrets, resp, err
 }
 
 func (r *retryableGitHubAPI) SyncCodespacesSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	retryFunc := func() (bool, error) {
-		return true, r.client.SyncCodespacesSecrets(ctx, owner, repo, mappings)
+		return true, classifyRetry(r.client.SyncCodespacesSecrets(ctx, owner, repo, mappings))
 	}
 
-	_, err := backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err := r.retry(ctx, "SyncCodespacesSecrets", retryFunc)
 	return err
 }
 
 func (r *retryableGitHubAPI) PutCodespacesSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	retryFunc := func() (bool, error) {
-		return true, r.client.PutCodespacesSecrets(ctx, owner, repo, mappings)
+		return true, classifyRetry(r.client.PutCodespacesSecrets(ctx, owner, repo, mappings))
 	}
 
-	_, err := backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err := r.retry(ctx, "PutCodespacesSecrets", retryFunc)
 	return err
 }
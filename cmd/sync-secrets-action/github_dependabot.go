@@ -2,10 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 
-	"github.com/cenkalti/backoff/v5"
 	"github.com/google/go-github/v68/github"
 )
 
@@ -17,6 +16,7 @@ type GitHubDependabotSecrets interface {
 	DeleteDependabotSecret(ctx context.Context, owner, repo, name string) (*github.Response, error)
 	ListDependabotSecrets(ctx context.Context, owner, repo string, opts *github.ListOptions) (*github.Secrets, *github.Response, error)
 	SyncDependabotSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error
+	DependabotAlertsEnabled(ctx context.Context, owner, repo string) (bool, *github.Response, error)
 }
 
 func (api *gitHubAPI) GetDependabotPublicKey(ctx context.Context, owner, repo string) (*github.PublicKey, *github.Response, error) {
@@ -35,11 +35,23 @@ func (api *gitHubAPI) ListDependabotSecrets(ctx context.Context, owner, repo str
 	return api.client.Dependabot.ListRepoSecrets(ctx, owner, repo, opts)
 }
 
+// DependabotAlertsEnabled reports whether Dependabot vulnerability alerts are enabled for a repository.
+func (api *gitHubAPI) DependabotAlertsEnabled(ctx context.Context, owner, repo string) (bool, *github.Response, error) {
+	return api.client.Repositories.GetVulnerabilityAlerts(ctx, owner, repo)
+}
+
 func (api *gitHubAPI) PutDependabotSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	if api.dryRunEnabled {
-		log.Printf("Dry run: Putting Dependabot secrets for repo %s/%s", owner, repo)
-		for secretName := range mappings {
-			log.Printf("Dry run: Would put Dependabot secret '%s' in repo %s/%s", secretName, owner, repo)
+		loggerFrom(ctx).Printf("Dry run: Putting Dependabot secrets for repo %s/%s", owner, repo)
+		existing, err := secretNames(api.perPage, func(opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
+			return api.ListDependabotSecrets(ctx, owner, repo, opts)
+		})
+		if err != nil {
+			return fmt.Errorf("dry run: failed to list existing Dependabot secrets: %w", err)
+		}
+		for _, secretName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: Would put Dependabot secret '%s' in repo %s/%s", secretName, owner, repo)
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: keyOutcome(existing, secretName)})
 		}
 		return nil
 	}
@@ -49,33 +61,41 @@ func (api *gitHubAPI) PutDependabotSecrets(ctx context.Context, owner, repo stri
 		return err
 	}
 
-	for secretName, secretValue := range mappings {
+	var errs []error
+	for _, secretName := range sortedKeys(mappings) {
+		secretValue := mappings[secretName]
 		encryptedSecret, err := encryptDependabotWithPublicKey(publicKey, secretName, secretValue)
 		if err != nil {
-			return err
+			errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to encrypt secret %s: %w", secretName, err)))
+			continue
 		}
 
-		_, err = api.CreateOrUpdateDependabotSecret(ctx, owner, repo, encryptedSecret)
+		resp, err := api.CreateOrUpdateDependabotSecret(ctx, owner, repo, encryptedSecret)
 		if err != nil {
-			return err
+			errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to update Dependabot secret %s in repo %s/%s: %w", secretName, owner, repo, err)))
+			continue
 		}
+		recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: writeOutcome(resp)})
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func (api *gitHubAPI) SyncDependabotSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	if api.dryRunEnabled {
-		log.Printf("Dry run: Syncing Dependabot secrets for repo %s/%s", owner, repo)
-		opts := &github.ListOptions{PerPage: 100}
+		loggerFrom(ctx).Printf("Dry run: Syncing Dependabot secrets for repo %s/%s", owner, repo)
+		existing := make(map[string]bool)
+		opts := &github.ListOptions{PerPage: api.perPage}
 		for {
 			secrets, resp, err := api.ListDependabotSecrets(ctx, owner, repo, opts)
 			if err != nil {
-				return fmt.Errorf("dry run: failed to list existing Dependabot secrets: %v", err)
+				return fmt.Errorf("dry run: failed to list existing Dependabot secrets: %w", err)
 			}
 
 			for _, secret := range secrets.Secrets {
-				if _, exists := mappings[secret.Name]; !exists {
-					log.Printf("Dry run: Would delete Dependabot secret '%s' from repo %s/%s", secret.Name, owner, repo)
+				existing[secret.Name] = true
+				if shouldPrune(ctx, mappings, secret.Name) {
+					loggerFrom(ctx).Printf("Dry run: Would delete Dependabot secret '%s' from repo %s/%s", secret.Name, owner, repo)
+					recordKey(ctx, KeyResult{Kind: "secret", Name: secret.Name, Outcome: KeyDeleted})
 				}
 			}
 
@@ -85,8 +105,9 @@ func (api *gitHubAPI) SyncDependabotSecrets(ctx context.Context, owner, repo str
 			opts.Page = resp.NextPage
 		}
 
-		for secretName := range mappings {
-			log.Printf("Dry run: Would add/update Dependabot secret '%s' in repo %s/%s", secretName, owner, repo)
+		for _, secretName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: Would add/update Dependabot secret '%s' in repo %s/%s", secretName, owner, repo)
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: keyOutcome(existing, secretName)})
 		}
 
 		return nil
@@ -94,7 +115,7 @@ func (api *gitHubAPI) SyncDependabotSecrets(ctx context.Context, owner, repo str
 
 	existingMap := make(map[string]bool)
 
-	opts := &github.ListOptions{PerPage: 100}
+	opts := &github.ListOptions{PerPage: api.perPage}
 	for {
 		secrets, resp, err := api.ListDependabotSecrets(ctx, owner, repo, opts)
 		if err != nil {
@@ -111,50 +132,72 @@ func (api *gitHubAPI) SyncDependabotSecrets(ctx context.Context, owner, repo str
 		opts.Page = resp.NextPage
 	}
 
-	for secretName := range existingMap {
-		if _, exists := mappings[secretName]; !exists {
+	var errs []error
+	for _, secretName := range sortedKeys(existingMap) {
+		if shouldPrune(ctx, mappings, secretName) {
 			_, err := api.DeleteDependabotSecret(ctx, owner, repo, secretName)
-			if err != nil {
-				return err
+			if err != nil && !alreadyDeleted(err) {
+				errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to delete Dependabot secret %s from repo %s/%s: %w", secretName, owner, repo, err)))
+				continue
 			}
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: KeyDeleted})
 		}
 	}
 
-	return api.PutDependabotSecrets(ctx, owner, repo, mappings)
+	return errors.Join(append(errs, api.PutDependabotSecrets(ctx, owner, repo, mappings))...)
 }
 
 // Ratelimiting
 
 func (r *rateLimitedGitHubAPI) PutDependabotSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
 	return r.client.PutDependabotSecrets(ctx, owner, repo, mappings)
 }
 
 func (r *rateLimitedGitHubAPI) GetDependabotPublicKey(ctx context.Context, owner, repo string) (*github.PublicKey, *github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
 	return r.client.GetDependabotPublicKey(ctx, owner, repo)
 }
 
 func (r *rateLimitedGitHubAPI) CreateOrUpdateDependabotSecret(ctx context.Context, owner, repo string, eSecret *github.DependabotEncryptedSecret) (*github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.CreateOrUpdateDependabotSecret(ctx, owner, repo, eSecret)
 }
 
 func (r *rateLimitedGitHubAPI) DeleteDependabotSecret(ctx context.Context, owner, repo, name string) (*github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.DeleteDependabotSecret(ctx, owner, repo, name)
 }
 
 func (r *rateLimitedGitHubAPI) ListDependabotSecrets(ctx context.Context, owner, repo string, opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
 	return r.client.ListDependabotSecrets(ctx, owner, repo, opts)
 }
 
 func (r *rateLimitedGitHubAPI) SyncDependabotSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
 	return r.client.SyncDependabotSecrets(ctx, owner, repo, mappings)
 }
 
+func (r *rateLimitedGitHubAPI) DependabotAlertsEnabled(ctx context.Context, owner, repo string) (bool, *github.Response, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return false, nil, err
+	}
+	return r.client.DependabotAlertsEnabled(ctx, owner, repo)
+}
+
 // Retry
 
 func (r *retryableGitHubAPI) GetDependabotPublicKey(ctx context.Context, owner, repo string) (*github.PublicKey, *github.Response, error) {
@@ -164,10 +207,10 @@ func (r *retryableGitHubAPI) GetDependabotPublicKey(ctx context.Context, owner,
 
 	retryFunc := func() (bool, error) {
 		publicKey, resp, err = r.client.GetDependabotPublicKey(ctx, owner, repo)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "GetDependabotPublicKey", retryFunc)
 	return publicKey, resp, err
 }
 
@@ -177,10 +220,10 @@ func (r *retryableGitHubAPI) CreateOrUpdateDependabotSecret(ctx context.Context,
 
 	retryFunc := func() (bool, error) {
 		resp, err = r.client.CreateOrUpdateDependabotSecret(ctx, owner, repo, eSecret)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "CreateOrUpdateDependabotSecret", retryFunc)
 	return resp, err
 }
 
@@ -190,10 +233,10 @@ func (r *retryableGitHubAPI) DeleteDependabotSecret(ctx context.Context, owner,
 
 	retryFunc := func() (bool, error) {
 		resp, err = r.client.DeleteDependabotSecret(ctx, owner, repo, name)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "DeleteDependabotSecret", retryFunc)
 	return resp, err
 }
 
@@ -204,26 +247,40 @@ func (r *retryableGitHubAPI) ListDependabotSecrets(ctx context.Context, owner, r
 
 	retryFunc := func() (bool, error) {
 		secrets, resp, err = r.client.ListDependabotSecrets(ctx, owner, repo, opts)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "ListDependabotSecrets", retryFunc)
 	return secrets, resp, err
 }
 
 func (r *retryableGitHubAPI) SyncDependabotSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	retryFunc := func() (bool, error) {
-		return true, r.client.SyncDependabotSecrets(ctx, owner, repo, mappings)
+		return true, classifyRetry(r.client.SyncDependabotSecrets(ctx, owner, repo, mappings))
 	}
-	_, err := backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err := r.retry(ctx, "SyncDependabotSecrets", retryFunc)
 	return err
 }
 
 func (r *retryableGitHubAPI) PutDependabotSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	retryFunc := func() (bool, error) {
-		return true, r.client.PutDependabotSecrets(ctx, owner, repo, mappings)
+		return true, classifyRetry(r.client.PutDependabotSecrets(ctx, owner, repo, mappings))
 	}
 
-	_, err := backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err := r.retry(ctx, "PutDependabotSecrets", retryFunc)
 	return err
 }
+
+func (r *retryableGitHubAPI) DependabotAlertsEnabled(ctx context.Context, owner, repo string) (bool, *github.Response, error) {
+	var enabled bool
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		enabled, resp, err = r.client.DependabotAlertsEnabled(ctx, owner, repo)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "DependabotAlertsEnabled", retryFunc)
+	return enabled, resp, err
+}
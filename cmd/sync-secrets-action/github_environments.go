@@ -2,27 +2,44 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
+	"time"
 
-	"github.com/cenkalti/backoff/v5"
 	"github.com/google/go-github/v68/github"
 )
 
+// envTarget is a repository resolved for environment operations. The API addresses secrets of environments by the ID
+// of the repository and variables by its owner and name, so both are resolved once per repository and passed down.
+type envTarget struct {
+	RepoID int
+	Owner  string
+	Repo   string
+}
+
+func (t envTarget) String() string {
+	return t.Owner + "/" + t.Repo
+}
+
 // GitHubEnvSecrets for GitHub environment secrets management.
 type GitHubEnvSecrets interface {
 	CreateOrUpdateEnvSecret(ctx context.Context, repoID int, envName string, eSecret *github.EncryptedSecret) (*github.Response, error)
 	DeleteEnvSecret(ctx context.Context, repoID int, envName, name string) (*github.Response, error)
 	GetEnvPublicKey(ctx context.Context, repoID int, envName string) (*github.PublicKey, *github.Response, error)
 	ListEnvSecrets(ctx context.Context, repoID int, envName string, opts *github.ListOptions) (*github.Secrets, *github.Response, error)
-	PutEnvSecrets(ctx context.Context, owner, repo, envName string, mappings map[string]string) error
-	SyncEnvSecrets(ctx context.Context, owner, repo, envName string, mappings map[string]string) error
+	PutEnvSecrets(ctx context.Context, target envTarget, envName string, mappings map[string]string) error
+	SyncEnvSecrets(ctx context.Context, target envTarget, envName string, mappings map[string]string) error
 
 	CreateOrUpdateEnvVariable(ctx context.Context, owner, repo, envName string, eSecret *github.ActionsVariable) (*github.Response, error)
 	DeleteEnvVariable(ctx context.Context, owner, repo, envName, name string) (*github.Response, error)
 	ListEnvVariables(ctx context.Context, owner, repo, envName string, opts *github.ListOptions) (*github.ActionsVariables, *github.Response, error)
-	PutEnvVariables(ctx context.Context, owner, repo, envName string, mappings map[string]string) error
-	SyncEnvVariables(ctx context.Context, owner, repo, envName string, mappings map[string]string) error
+	PutEnvVariables(ctx context.Context, target envTarget, envName string, mappings map[string]string) error
+	SyncEnvVariables(ctx context.Context, target envTarget, envName string, mappings map[string]string) error
+
+	ListEnvironments(ctx context.Context, owner, repo string, opts *github.EnvironmentListOptions) (*github.EnvResponse, *github.Response, error)
+	GetEnvironment(ctx context.Context, owner, repo, envName string) (*github.Environment, *github.Response, error)
+	CreateEnvironment(ctx context.Context, owner, repo, envName string) (bool, *github.Response, error)
 }
 
 func (api *gitHubAPI) DeleteEnvSecret(ctx context.Context, repoID int, envName, name string) (*github.Response, error) {
@@ -49,30 +66,60 @@ func (api *gitHubAPI) ListEnvVariables(ctx context.Context, owner, repo, envName
 	return api.client.Actions.ListEnvVariables(ctx, owner, repo, envName, opts)
 }
 
+// CreateOrUpdateEnvVariable updates an environment variable, or creates it if it doesn't exist yet, as the API has no
+// single call for both.
 func (api *gitHubAPI) CreateOrUpdateEnvVariable(ctx context.Context, owner, repo, envName string, eVariable *github.ActionsVariable) (*github.Response, error) {
-	// todo(cbrgm): This is necessary because there's no CreateOrUpdate, is it missing?
-	_, _ = api.client.Actions.DeleteEnvVariable(ctx, owner, repo, envName, eVariable.Name)
-	return api.client.Actions.CreateEnvVariable(ctx, owner, repo, envName, eVariable)
+	resp, err := api.client.Actions.UpdateEnvVariable(ctx, owner, repo, envName, eVariable)
+	if !isStatus(err, http.StatusNotFound) {
+		return resp, err
+	}
+	resp, err = api.client.Actions.CreateEnvVariable(ctx, owner, repo, envName, eVariable)
+	// A concurrent sync may have created the variable in between, updating it converges to the same state.
+	if isStatus(err, http.StatusConflict) {
+		return api.client.Actions.UpdateEnvVariable(ctx, owner, repo, envName, eVariable)
+	}
+	return resp, err
 }
 
-func (api *gitHubAPI) SyncEnvSecrets(ctx context.Context, owner, repo, envName string, mappings map[string]string) error {
-	r, _, err := api.client.Repositories.Get(ctx, owner, repo)
-	if err != nil {
-		return fmt.Errorf("failed to list repo %s/%s: %v", owner, repo, err)
+func (api *gitHubAPI) ListEnvironments(ctx context.Context, owner, repo string, opts *github.EnvironmentListOptions) (*github.EnvResponse, *github.Response, error) {
+	return api.client.Repositories.ListEnvironments(ctx, owner, repo, opts)
+}
+
+func (api *gitHubAPI) GetEnvironment(ctx context.Context, owner, repo, envName string) (*github.Environment, *github.Response, error) {
+	return api.client.Repositories.GetEnvironment(ctx, owner, repo, envName)
+}
+
+// CreateEnvironment creates an environment without protection rules and reports whether it was missing. Existing
+// environments are left as they are, as updating them would remove their protection rules.
+func (api *gitHubAPI) CreateEnvironment(ctx context.Context, owner, repo, envName string) (bool, *github.Response, error) {
+	_, resp, err := api.client.Repositories.GetEnvironment(ctx, owner, repo, envName)
+	if err == nil || !isStatus(err, http.StatusNotFound) {
+		return false, resp, err
 	}
+	if api.dryRunEnabled {
+		loggerFrom(ctx).Printf("Dry run: Creating environment '%s' in repo %s/%s\n", envName, owner, repo)
+		return true, nil, nil
+	}
+	_, resp, err = api.client.Repositories.CreateUpdateEnvironment(ctx, owner, repo, envName, &github.CreateUpdateEnvironment{})
+	return err == nil, resp, err
+}
 
+func (api *gitHubAPI) SyncEnvSecrets(ctx context.Context, target envTarget, envName string, mappings map[string]string) error {
 	if api.dryRunEnabled {
-		log.Printf("Dry run: Syncing environment secrets for '%s' in repo %s/%s", envName, owner, repo)
-		opts := &github.ListOptions{PerPage: 100}
+		loggerFrom(ctx).Printf("Dry run: Syncing environment secrets for '%s' in repo %s", envName, target)
+		existing := make(map[string]bool)
+		opts := &github.ListOptions{PerPage: api.perPage}
 		for {
-			secrets, resp, err := api.ListEnvSecrets(ctx, int(r.GetID()), envName, opts)
+			secrets, resp, err := api.ListEnvSecrets(ctx, target.RepoID, envName, opts)
 			if err != nil {
-				return fmt.Errorf("dry run: failed to fetch existing environment secrets for %s in repo %s/%s: %v", envName, owner, repo, err)
+				return fmt.Errorf("dry run: failed to fetch existing environment secrets for %s in repo %s: %w", envName, target, err)
 			}
 
 			for _, secret := range secrets.Secrets {
-				if _, ok := mappings[secret.Name]; !ok {
-					log.Printf("Dry run: Would delete environment secret '%s' in '%s' for repo %s/%s\n", secret.Name, envName, owner, repo)
+				existing[secret.Name] = true
+				if shouldPrune(ctx, mappings, secret.Name) {
+					loggerFrom(ctx).Printf("Dry run: Would delete environment secret '%s' in '%s' for repo %s\n", secret.Name, envName, target)
+					recordKey(ctx, KeyResult{Kind: "secret", Name: secret.Name, Outcome: KeyDeleted})
 				}
 			}
 
@@ -82,8 +129,9 @@ func (api *gitHubAPI) SyncEnvSecrets(ctx context.Context, owner, repo, envName s
 			opts.Page = resp.NextPage
 		}
 
-		for secretName := range mappings {
-			log.Printf("Dry run: Would add/update environment secret '%s' in '%s' for repo %s/%s\n", secretName, envName, owner, repo)
+		for _, secretName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: Would add/update environment secret '%s' in '%s' for repo %s\n", secretName, envName, target)
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: keyOutcome(existing, secretName)})
 		}
 
 		return nil
@@ -92,11 +140,11 @@ func (api *gitHubAPI) SyncEnvSecrets(ctx context.Context, owner, repo, envName s
 	existingMap := make(map[string]bool)
 
 	// Pagination setup
-	opts := &github.ListOptions{PerPage: 100}
+	opts := &github.ListOptions{PerPage: api.perPage}
 	for {
-		secrets, resp, err := api.ListEnvSecrets(ctx, int(r.GetID()), envName, opts)
+		secrets, resp, err := api.ListEnvSecrets(ctx, target.RepoID, envName, opts)
 		if err != nil {
-			return fmt.Errorf("failed to list existing environment secrets for %s: %v", envName, err)
+			return fmt.Errorf("failed to list existing environment secrets for %s: %w", envName, err)
 		}
 
 		for _, secret := range secrets.Secrets {
@@ -110,69 +158,77 @@ func (api *gitHubAPI) SyncEnvSecrets(ctx context.Context, owner, repo, envName s
 	}
 
 	// Delete secrets not in mappings
-	for secretName := range existingMap {
-		if _, exists := mappings[secretName]; !exists {
-			_, err := api.DeleteEnvSecret(ctx, int(r.GetID()), envName, secretName)
-			if err != nil {
-				return fmt.Errorf("failed to delete environment secret %s in %s for repo %s/%s: %v", secretName, envName, owner, repo, err)
+	var errs []error
+	for _, secretName := range sortedKeys(existingMap) {
+		if shouldPrune(ctx, mappings, secretName) {
+			_, err := api.DeleteEnvSecret(ctx, target.RepoID, envName, secretName)
+			if err != nil && !alreadyDeleted(err) {
+				errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to delete environment secret %s in %s for repo %s: %w", secretName, envName, target, err)))
+				continue
 			}
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: KeyDeleted})
 		}
 	}
 
 	// Add or update secrets from mappings
-	return api.PutEnvSecrets(ctx, owner, repo, envName, mappings)
+	return errors.Join(append(errs, api.PutEnvSecrets(ctx, target, envName, mappings))...)
 }
 
-func (api *gitHubAPI) PutEnvSecrets(ctx context.Context, owner, repo, envName string, mappings map[string]string) error {
+func (api *gitHubAPI) PutEnvSecrets(ctx context.Context, target envTarget, envName string, mappings map[string]string) error {
 	if api.dryRunEnabled {
-		log.Printf("Dry run: Putting environment secrets for '%s' in repo %s/%s\n", envName, owner, repo)
-		for secretName := range mappings {
-			log.Printf("Dry run: Would put environment secret '%s' in '%s' for repo %s/%s\n", secretName, envName, owner, repo)
+		loggerFrom(ctx).Printf("Dry run: Putting environment secrets for '%s' in repo %s\n", envName, target)
+		existing, err := secretNames(api.perPage, func(opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
+			return api.ListEnvSecrets(ctx, target.RepoID, envName, opts)
+		})
+		if err != nil {
+			return fmt.Errorf("dry run: failed to fetch existing environment secrets for %s in repo %s: %w", envName, target, err)
+		}
+		for _, secretName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: Would put environment secret '%s' in '%s' for repo %s\n", secretName, envName, target)
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: keyOutcome(existing, secretName)})
 		}
 		return nil
 	}
 
-	r, _, err := api.client.Repositories.Get(ctx, owner, repo)
+	publicKey, _, err := api.GetEnvPublicKey(ctx, target.RepoID, envName)
 	if err != nil {
-		return fmt.Errorf("failed to list repo %s/%s: %v", owner, repo, err)
+		return fmt.Errorf("failed to get public key for environment %s in repo %s: %w", envName, target, err)
 	}
 
-	publicKey, _, err := api.GetEnvPublicKey(ctx, int(r.GetID()), envName)
-	if err != nil {
-		return fmt.Errorf("failed to get public key for environment %s in repo %s/%s: %v", envName, owner, repo, err)
-	}
-
-	for secretName, secretValue := range mappings {
+	var errs []error
+	for _, secretName := range sortedKeys(mappings) {
+		secretValue := mappings[secretName]
 		secret, err := encryptSecretWithPublicKey(publicKey, secretName, secretValue)
 		if err != nil {
-			return fmt.Errorf("failed to encrypt secret %s: %v", secretName, err)
+			errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to encrypt secret %s: %w", secretName, err)))
+			continue
 		}
-		_, err = api.CreateOrUpdateEnvSecret(ctx, int(r.GetID()), envName, secret)
+		resp, err := api.CreateOrUpdateEnvSecret(ctx, target.RepoID, envName, secret)
 		if err != nil {
-			return fmt.Errorf("failed to update secret %s in environment %s for repo %s/%s: %v", secretName, envName, owner, repo, err)
+			errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to update secret %s in environment %s for repo %s: %w", secretName, envName, target, err)))
+			continue
 		}
+		recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: writeOutcome(resp)})
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
-func (api *gitHubAPI) SyncEnvVariables(ctx context.Context, owner, repo, envName string, mappings map[string]string) error {
-	r, _, err := api.client.Repositories.Get(ctx, owner, repo)
-	if err != nil {
-		return fmt.Errorf("failed to list repo %s/%s: %v", owner, repo, err)
-	}
-
+func (api *gitHubAPI) SyncEnvVariables(ctx context.Context, target envTarget, envName string, mappings map[string]string) error {
 	if api.dryRunEnabled {
-		log.Printf("Dry run: Syncing environment variables for '%s' in repo %s/%s", envName, owner, repo)
-		opts := &github.ListOptions{PerPage: 100}
+		loggerFrom(ctx).Printf("Dry run: Syncing environment variables for '%s' in repo %s", envName, target)
+		existing := make(map[string]string)
+		opts := &github.ListOptions{PerPage: api.perPage}
 		for {
-			variables, resp, err := api.ListEnvVariables(ctx, r.GetOwner().GetName(), r.GetName(), envName, opts)
+			variables, resp, err := api.ListEnvVariables(ctx, target.Owner, target.Repo, envName, opts)
 			if err != nil {
-				return fmt.Errorf("dry run: failed to fetch existing environment variables for %s in repo %s/%s: %v", envName, owner, repo, err)
+				return fmt.Errorf("dry run: failed to fetch existing environment variables for %s in repo %s: %w", envName, target, err)
 			}
 
 			for _, variable := range variables.Variables {
-				if _, ok := mappings[variable.Name]; !ok {
-					log.Printf("Dry run: Would delete environment variable '%s' in '%s' for repo %s/%s\n", variable.Name, envName, owner, repo)
+				existing[variable.Name] = variable.Value
+				if shouldPrune(ctx, mappings, variable.Name) {
+					loggerFrom(ctx).Printf("Dry run: Would delete variable '%s' with value %q from environment '%s' of repo %s\n", variable.Name, variable.Value, envName, target)
+					recordKey(ctx, KeyResult{Kind: "variable", Name: variable.Name, Outcome: KeyDeleted})
 				}
 			}
 
@@ -182,8 +238,9 @@ func (api *gitHubAPI) SyncEnvVariables(ctx context.Context, owner, repo, envName
 			opts.Page = resp.NextPage
 		}
 
-		for variableName := range mappings {
-			log.Printf("Dry run: Would add/update environment variable '%s' in '%s' for repo %s/%s\n", variableName, envName, owner, repo)
+		for _, variableName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: %s in environment '%s' of repo %s\n", describeVariableChange(existing, variableName, mappings[variableName]), envName, target)
+			recordKey(ctx, KeyResult{Kind: "variable", Name: variableName, Outcome: variableOutcome(existing, variableName, mappings[variableName])})
 		}
 
 		return nil
@@ -192,11 +249,11 @@ func (api *gitHubAPI) SyncEnvVariables(ctx context.Context, owner, repo, envName
 	existingMap := make(map[string]bool)
 
 	// Pagination setup
-	opts := &github.ListOptions{PerPage: 100}
+	opts := &github.ListOptions{PerPage: api.perPage}
 	for {
-		variables, resp, err := api.ListEnvVariables(ctx, r.GetOwner().GetName(), r.GetName(), envName, opts)
+		variables, resp, err := api.ListEnvVariables(ctx, target.Owner, target.Repo, envName, opts)
 		if err != nil {
-			return fmt.Errorf("failed to list existing environment variables for %s: %v", envName, err)
+			return fmt.Errorf("failed to list existing environment variables for %s: %w", envName, err)
 		}
 
 		for _, variable := range variables.Variables {
@@ -210,112 +267,197 @@ func (api *gitHubAPI) SyncEnvVariables(ctx context.Context, owner, repo, envName
 	}
 
 	// Delete variables not in mappings
-	for variableName := range existingMap {
-		if _, exists := mappings[variableName]; !exists {
-			_, err := api.DeleteEnvVariable(ctx, r.GetOwner().GetName(), r.GetName(), envName, variableName)
-			if err != nil {
-				return fmt.Errorf("failed to delete environment variable %s in %s for repo %s/%s: %v", variableName, envName, owner, repo, err)
+	var errs []error
+	for _, variableName := range sortedKeys(existingMap) {
+		if shouldPrune(ctx, mappings, variableName) {
+			_, err := api.DeleteEnvVariable(ctx, target.Owner, target.Repo, envName, variableName)
+			if err != nil && !alreadyDeleted(err) {
+				errs = append(errs, failKey(ctx, "variable", variableName, fmt.Errorf("failed to delete environment variable %s in %s for repo %s: %w", variableName, envName, target, err)))
+				continue
 			}
+			recordKey(ctx, KeyResult{Kind: "variable", Name: variableName, Outcome: KeyDeleted})
 		}
 	}
 
 	// Add or update variables from mappings
-	return api.PutEnvVariables(ctx, owner, repo, envName, mappings)
+	return errors.Join(append(errs, api.PutEnvVariables(ctx, target, envName, mappings))...)
 }
 
-func (api *gitHubAPI) PutEnvVariables(ctx context.Context, owner, repo, envName string, mappings map[string]string) error {
+func (api *gitHubAPI) PutEnvVariables(ctx context.Context, target envTarget, envName string, mappings map[string]string) error {
 	if api.dryRunEnabled {
-		log.Printf("Dry run: Putting environment variables for '%s' in repo %s/%s\n", envName, owner, repo)
-		for variableName := range mappings {
-			log.Printf("Dry run: Would put environment variable '%s' in '%s' for repo %s/%s\n", variableName, envName, owner, repo)
+		loggerFrom(ctx).Printf("Dry run: Putting environment variables for '%s' in repo %s\n", envName, target)
+		existing, err := variableValues(api.perPage, func(opts *github.ListOptions) (*github.ActionsVariables, *github.Response, error) {
+			return api.ListEnvVariables(ctx, target.Owner, target.Repo, envName, opts)
+		})
+		if err != nil {
+			return fmt.Errorf("dry run: failed to fetch existing environment variables for %s in repo %s: %w", envName, target, err)
+		}
+		for _, variableName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: %s in environment '%s' of repo %s\n", describeVariableChange(existing, variableName, mappings[variableName]), envName, target)
+			recordKey(ctx, KeyResult{Kind: "variable", Name: variableName, Outcome: variableOutcome(existing, variableName, mappings[variableName])})
 		}
 		return nil
 	}
 
-	r, _, err := api.client.Repositories.Get(ctx, owner, repo)
+	// The existing variables tell created, updated, and unchanged apart.
+	existing, err := variableValues(api.perPage, func(opts *github.ListOptions) (*github.ActionsVariables, *github.Response, error) {
+		return api.ListEnvVariables(ctx, target.Owner, target.Repo, envName, opts)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to list repo %s/%s: %v", owner, repo, err)
+		return fmt.Errorf("failed to list existing environment variables for %s: %w", envName, err)
 	}
 
-	for variableName, variableValue := range mappings {
-		_, err = api.CreateOrUpdateEnvVariable(ctx, r.GetOwner().GetName(), r.GetName(), envName, &github.ActionsVariable{
+	var errs []error
+	for _, variableName := range sortedKeys(mappings) {
+		variableValue := mappings[variableName]
+		// Variables can be read back, so writing the current value again is only audit log noise.
+		if variableOutcome(existing, variableName, variableValue) == KeyUnchanged {
+			recordKey(ctx, KeyResult{Kind: "variable", Name: variableName, Outcome: KeyUnchanged})
+			continue
+		}
+		_, err = api.CreateOrUpdateEnvVariable(ctx, target.Owner, target.Repo, envName, &github.ActionsVariable{
 			Name:  variableName,
 			Value: variableValue,
 		})
 		if err != nil {
-			return fmt.Errorf("failed to update variable %s in environment %s for repo %s/%s: %v", variableName, envName, owner, repo, err)
+			errs = append(errs, failKey(ctx, "variable", variableName, fmt.Errorf("failed to update variable %s in environment %s for repo %s: %w", variableName, envName, target, err)))
+			continue
 		}
+		recordKey(ctx, KeyResult{Kind: "variable", Name: variableName, Outcome: keyOutcome(existing, variableName)})
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
-func (r *rateLimitedGitHubAPI) PutEnvSecrets(ctx context.Context, owner, repo, envName string, mappings map[string]string) error {
-	r.ensureRatelimits(ctx)
-	return r.client.PutEnvSecrets(ctx, owner, repo, envName, mappings)
+func (r *rateLimitedGitHubAPI) PutEnvSecrets(ctx context.Context, target envTarget, envName string, mappings map[string]string) error {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
+	return r.client.PutEnvSecrets(ctx, target, envName, mappings)
 }
 
 func (r *rateLimitedGitHubAPI) GetEnvPublicKey(ctx context.Context, repoID int, envName string) (*github.PublicKey, *github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
 	return r.client.GetEnvPublicKey(ctx, repoID, envName)
 }
 
 func (r *rateLimitedGitHubAPI) CreateOrUpdateEnvSecret(ctx context.Context, repoID int, envName string, eSecret *github.EncryptedSecret) (*github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.CreateOrUpdateEnvSecret(ctx, repoID, envName, eSecret)
 }
 
 func (r *rateLimitedGitHubAPI) DeleteEnvSecret(ctx context.Context, repoID int, envName, name string) (*github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.DeleteEnvSecret(ctx, repoID, envName, name)
 }
 
 func (r *rateLimitedGitHubAPI) ListEnvSecrets(ctx context.Context, repoID int, envName string, opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
 	return r.client.ListEnvSecrets(ctx, repoID, envName, opts)
 }
 
-func (r *rateLimitedGitHubAPI) SyncEnvSecrets(ctx context.Context, owner, repo, envName string, mappings map[string]string) error {
-	r.ensureRatelimits(ctx)
-	return r.client.SyncEnvSecrets(ctx, owner, repo, envName, mappings)
+func (r *rateLimitedGitHubAPI) SyncEnvSecrets(ctx context.Context, target envTarget, envName string, mappings map[string]string) error {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
+	return r.client.SyncEnvSecrets(ctx, target, envName, mappings)
 }
 
-func (r *rateLimitedGitHubAPI) PutEnvVariables(ctx context.Context, owner, repo, envName string, mappings map[string]string) error {
-	r.ensureRatelimits(ctx)
-	return r.client.PutEnvVariables(ctx, owner, repo, envName, mappings)
+func (r *rateLimitedGitHubAPI) PutEnvVariables(ctx context.Context, target envTarget, envName string, mappings map[string]string) error {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
+	return r.client.PutEnvVariables(ctx, target, envName, mappings)
 }
 
 func (r *rateLimitedGitHubAPI) CreateOrUpdateEnvVariable(ctx context.Context, owner, repo, envName string, eVariable *github.ActionsVariable) (*github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.CreateOrUpdateEnvVariable(ctx, owner, repo, envName, eVariable)
 }
 
 func (r *rateLimitedGitHubAPI) DeleteEnvVariable(ctx context.Context, owner, repo, envName, name string) (*github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.DeleteEnvVariable(ctx, owner, repo, envName, name)
 }
 
 func (r *rateLimitedGitHubAPI) ListEnvVariables(ctx context.Context, owner, repo, envName string, opts *github.ListOptions) (*github.ActionsVariables, *github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
 	return r.client.ListEnvVariables(ctx, owner, repo, envName, opts)
 }
 
-func (r *rateLimitedGitHubAPI) SyncEnvVariables(ctx context.Context, owner, repo, envName string, mappings map[string]string) error {
-	r.ensureRatelimits(ctx)
-	return r.client.SyncEnvVariables(ctx, owner, repo, envName, mappings)
+func (r *rateLimitedGitHubAPI) SyncEnvVariables(ctx context.Context, target envTarget, envName string, mappings map[string]string) error {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
+	return r.client.SyncEnvVariables(ctx, target, envName, mappings)
+}
+
+func (r *rateLimitedGitHubAPI) CreateEnvironment(ctx context.Context, owner, repo, envName string) (bool, *github.Response, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return false, nil, err
+	}
+	return r.client.CreateEnvironment(ctx, owner, repo, envName)
+}
+
+func (r *rateLimitedGitHubAPI) ListEnvironments(ctx context.Context, owner, repo string, opts *github.EnvironmentListOptions) (*github.EnvResponse, *github.Response, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
+	return r.client.ListEnvironments(ctx, owner, repo, opts)
+}
+
+func (r *rateLimitedGitHubAPI) GetEnvironment(ctx context.Context, owner, repo, envName string) (*github.Environment, *github.Response, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
+	return r.client.GetEnvironment(ctx, owner, repo, envName)
 }
 
 // Retry
 
+// createdEnvironmentWindow is how long 404s are retried after an environment was created. The secrets and variables
+// API of a new environment may answer with 404 for a few seconds.
+const createdEnvironmentWindow = 15 * time.Second
+
+type createdEnvironmentContextKey struct{}
+
+// withCreatedEnvironment marks the environment of the operations run with the returned context as just created.
+func withCreatedEnvironment(ctx context.Context) context.Context {
+	return context.WithValue(ctx, createdEnvironmentContextKey{}, time.Now().Add(createdEnvironmentWindow))
+}
+
+// classifyEnvRetry is classifyRetry for operations on an environment. If the environment was just created,
+// 404s are retried as well until createdEnvironmentWindow has passed.
+func classifyEnvRetry(ctx context.Context, err error) error {
+	if deadline, ok := ctx.Value(createdEnvironmentContextKey{}).(time.Time); ok && time.Now().Before(deadline) && isStatus(err, http.StatusNotFound) {
+		return err
+	}
+	return classifyRetry(err)
+}
+
 func (r *retryableGitHubAPI) CreateOrUpdateEnvSecret(ctx context.Context, repoID int, envName string, eSecret *github.EncryptedSecret) (*github.Response, error) {
 	var resp *github.Response
 	var err error
 
 	retryFunc := func() (bool, error) {
 		resp, err = r.client.CreateOrUpdateEnvSecret(ctx, repoID, envName, eSecret)
-		return true, err
+		return true, classifyEnvRetry(ctx, err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "CreateOrUpdateEnvSecret", retryFunc)
 	return resp, err
 }
 
@@ -325,10 +467,10 @@ func (r *retryableGitHubAPI) DeleteEnvSecret(ctx context.Context, repoID int, en
 
 	retryFunc := func() (bool, error) {
 		resp, err = r.client.DeleteEnvSecret(ctx, repoID, envName, name)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "DeleteEnvSecret", retryFunc)
 	return resp, err
 }
 
@@ -339,10 +481,10 @@ func (r *retryableGitHubAPI) GetEnvPublicKey(ctx context.Context, repoID int, en
 
 	retryFunc := func() (bool, error) {
 		publicKey, resp, err = r.client.GetEnvPublicKey(ctx, repoID, envName)
-		return true, err
+		return true, classifyEnvRetry(ctx, err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "GetEnvPublicKey", retryFunc)
 	return publicKey, resp, err
 }
 
@@ -353,26 +495,26 @@ func (r *retryableGitHubAPI) ListEnvSecrets(ctx context.Context, repoID int, env
 
 	retryFunc := func() (bool, error) {
 		secrets, resp, err = r.client.ListEnvSecrets(ctx, repoID, envName, opts)
-		return true, err
+		return true, classifyEnvRetry(ctx, err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "ListEnvSecrets", retryFunc)
 	return secrets, resp, err
 }
 
-func (r *retryableGitHubAPI) PutEnvSecrets(ctx context.Context, owner, repo, envName string, mappings map[string]string) error {
+func (r *retryableGitHubAPI) PutEnvSecrets(ctx context.Context, target envTarget, envName string, mappings map[string]string) error {
 	retryFunc := func() (bool, error) {
-		return true, r.client.PutEnvSecrets(ctx, owner, repo, envName, mappings)
+		return true, classifyEnvRetry(ctx, r.client.PutEnvSecrets(ctx, target, envName, mappings))
 	}
-	_, err := backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err := r.retry(ctx, "PutEnvSecrets", retryFunc)
 	return err
 }
 
-func (r *retryableGitHubAPI) SyncEnvSecrets(ctx context.Context, owner, repo, envName string, mappings map[string]string) error {
+func (r *retryableGitHubAPI) SyncEnvSecrets(ctx context.Context, target envTarget, envName string, mappings map[string]string) error {
 	retryFunc := func() (bool, error) {
-		return true, r.client.SyncEnvSecrets(ctx, owner, repo, envName, mappings)
+		return true, classifyEnvRetry(ctx, r.client.SyncEnvSecrets(ctx, target, envName, mappings))
 	}
-	_, err := backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err := r.retry(ctx, "SyncEnvSecrets", retryFunc)
 	return err
 }
 
@@ -382,10 +524,10 @@ func (r *retryableGitHubAPI) CreateOrUpdateEnvVariable(ctx context.Context, owne
 
 	retryFunc := func() (bool, error) {
 		resp, err = r.client.CreateOrUpdateEnvVariable(ctx, owner, repo, envName, eVariable)
-		return true, err
+		return true, classifyEnvRetry(ctx, err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "CreateOrUpdateEnvVariable", retryFunc)
 	return resp, err
 }
 
@@ -395,10 +537,10 @@ func (r *retryableGitHubAPI) DeleteEnvVariable(ctx context.Context, owner, repo,
 
 	retryFunc := func() (bool, error) {
 		resp, err = r.client.DeleteEnvVariable(ctx, owner, repo, envName, name)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "DeleteEnvVariable", retryFunc)
 	return resp, err
 }
 
@@ -409,25 +551,67 @@ func (r *retryableGitHubAPI) ListEnvVariables(ctx context.Context, owner, repo,
 
 	retryFunc := func() (bool, error) {
 		secrets, resp, err = r.client.ListEnvVariables(ctx, owner, repo, envName, opts)
-		return true, err
+		return true, classifyEnvRetry(ctx, err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "ListEnvVariables", retryFunc)
 	return secrets, resp, err
 }
 
-func (r *retryableGitHubAPI) PutEnvVariables(ctx context.Context, owner, repo, envName string, mappings map[string]string) error {
+func (r *retryableGitHubAPI) PutEnvVariables(ctx context.Context, target envTarget, envName string, mappings map[string]string) error {
 	retryFunc := func() (bool, error) {
-		return true, r.client.PutEnvVariables(ctx, owner, repo, envName, mappings)
+		return true, classifyEnvRetry(ctx, r.client.PutEnvVariables(ctx, target, envName, mappings))
 	}
-	_, err := backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err := r.retry(ctx, "PutEnvVariables", retryFunc)
 	return err
 }
 
-func (r *retryableGitHubAPI) SyncEnvVariables(ctx context.Context, owner, repo, envName string, mappings map[string]string) error {
+func (r *retryableGitHubAPI) SyncEnvVariables(ctx context.Context, target envTarget, envName string, mappings map[string]string) error {
 	retryFunc := func() (bool, error) {
-		return true, r.client.SyncEnvVariables(ctx, owner, repo, envName, mappings)
+		return true, classifyEnvRetry(ctx, r.client.SyncEnvVariables(ctx, target, envName, mappings))
 	}
-	_, err := backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err := r.retry(ctx, "SyncEnvVariables", retryFunc)
 	return err
 }
+
+func (r *retryableGitHubAPI) CreateEnvironment(ctx context.Context, owner, repo, envName string) (bool, *github.Response, error) {
+	var created bool
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		created, resp, err = r.client.CreateEnvironment(ctx, owner, repo, envName)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "CreateEnvironment", retryFunc)
+	return created, resp, err
+}
+
+func (r *retryableGitHubAPI) ListEnvironments(ctx context.Context, owner, repo string, opts *github.EnvironmentListOptions) (*github.EnvResponse, *github.Response, error) {
+	var environments *github.EnvResponse
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		environments, resp, err = r.client.ListEnvironments(ctx, owner, repo, opts)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "ListEnvironments", retryFunc)
+	return environments, resp, err
+}
+
+func (r *retryableGitHubAPI) GetEnvironment(ctx context.Context, owner, repo, envName string) (*github.Environment, *github.Response, error) {
+	var environment *github.Environment
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		environment, resp, err = r.client.GetEnvironment(ctx, owner, repo, envName)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "GetEnvironment", retryFunc)
+	return environment, resp, err
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// defaultOrgSecretVisibility is the visibility of created organization secrets, so they aren't exposed to public
+// repositories unless asked for.
+const defaultOrgSecretVisibility = visibilityPrivate
+
+// Visibilities of organization secrets.
+const (
+	visibilityAll      = "all"
+	visibilityPrivate  = "private"
+	visibilitySelected = "selected"
+)
+
+// OrgSecretAccess declares which repositories of an organization can use a secret.
+type OrgSecretAccess struct {
+	Visibility string
+	// Repositories are the names of the repositories a secret of visibility selected is visible to.
+	Repositories []string
+	// RepositoryIDs are the IDs of Repositories, as required by the API.
+	RepositoryIDs []int64
+}
+
+// GitHubOrgCodespacesSecrets defines the interface for managing the Codespaces secrets of an organization.
+type GitHubOrgCodespacesSecrets interface {
+	CreateOrUpdateOrgCodespacesSecret(ctx context.Context, org string, eSecret *github.EncryptedSecret) (*github.Response, error)
+	DeleteOrgCodespacesSecret(ctx context.Context, org, name string) (*github.Response, error)
+	GetOrgCodespacesPublicKey(ctx context.Context, org string) (*github.PublicKey, *github.Response, error)
+	ListOrgCodespacesSecrets(ctx context.Context, org string, opts *github.ListOptions) (*github.Secrets, *github.Response, error)
+	ListOrgCodespacesSecretRepositories(ctx context.Context, org, name string, opts *github.ListOptions) (*github.SelectedReposList, *github.Response, error)
+	PutOrgCodespacesSecrets(ctx context.Context, org string, mappings map[string]string, access map[string]OrgSecretAccess) error
+	SyncOrgCodespacesSecrets(ctx context.Context, org string, mappings map[string]string, access map[string]OrgSecretAccess) error
+	GetOrgMembership(ctx context.Context, org string) (*github.Membership, *github.Response, error)
+}
+
+// GetOrgCodespacesPublicKey retrieves the public key of an organization, used for encrypting its Codespaces secrets.
+func (api *gitHubAPI) GetOrgCodespacesPublicKey(ctx context.Context, org string) (*github.PublicKey, *github.Response, error) {
+	return api.client.Codespaces.GetOrgPublicKey(ctx, org)
+}
+
+// CreateOrUpdateOrgCodespacesSecret adds or updates a Codespaces secret of an organization.
+func (api *gitHubAPI) CreateOrUpdateOrgCodespacesSecret(ctx context.Context, org string, eSecret *github.EncryptedSecret) (*github.Response, error) {
+	return api.client.Codespaces.CreateOrUpdateOrgSecret(ctx, org, eSecret)
+}
+
+// DeleteOrgCodespacesSecret removes a Codespaces secret from an organization.
+func (api *gitHubAPI) DeleteOrgCodespacesSecret(ctx context.Context, org, name string) (*github.Response, error) {
+	return api.client.Codespaces.DeleteOrgSecret(ctx, org, name)
+}
+
+// ListOrgCodespacesSecrets lists the Codespaces secrets of an organization.
+func (api *gitHubAPI) ListOrgCodespacesSecrets(ctx context.Context, org string, opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
+	return api.client.Codespaces.ListOrgSecrets(ctx, org, opts)
+}
+
+// ListOrgCodespacesSecretRepositories lists the repositories a Codespaces secret of visibility selected is visible to.
+func (api *gitHubAPI) ListOrgCodespacesSecretRepositories(ctx context.Context, org, name string, opts *github.ListOptions) (*github.SelectedReposList, *github.Response, error) {
+	return api.client.Codespaces.ListSelectedReposForOrgSecret(ctx, org, name, opts)
+}
+
+// GetOrgMembership retrieves the membership of the authenticated user in an organization.
+func (api *gitHubAPI) GetOrgMembership(ctx context.Context, org string) (*github.Membership, *github.Response, error) {
+	return api.client.Organizations.GetOrgMembership(ctx, "", org)
+}
+
+// orgCodespacesSecrets returns the existing Codespaces secrets of an organization by name.
+func (api *gitHubAPI) orgCodespacesSecrets(ctx context.Context, org string) (map[string]*github.Secret, error) {
+	secrets, err := listAll(api.perPage, func(opts *github.ListOptions) ([]*github.Secret, *github.Response, error) {
+		s, resp, err := api.ListOrgCodespacesSecrets(ctx, org, opts)
+		if err != nil {
+			return nil, resp, err
+		}
+		return s.Secrets, resp, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list existing Codespaces secrets of organization %s: %w", org, err)
+	}
+	existing := make(map[string]*github.Secret, len(secrets))
+	for _, secret := range secrets {
+		existing[secret.Name] = secret
+	}
+	return existing, nil
+}
+
+// PutOrgCodespacesSecrets creates or updates multiple Codespaces secrets of an organization.
+// Secrets declared in access get the declared visibility and selected repositories. Of the others, created secrets
+// get defaultOrgSecretVisibility and updated ones keep their visibility and selected repositories.
+func (api *gitHubAPI) PutOrgCodespacesSecrets(ctx context.Context, org string, mappings map[string]string, access map[string]OrgSecretAccess) error {
+	existing, err := api.orgCodespacesSecrets(ctx, org)
+	if err != nil {
+		return err
+	}
+	return api.putOrgCodespacesSecrets(ctx, org, mappings, access, existing)
+}
+
+func (api *gitHubAPI) putOrgCodespacesSecrets(ctx context.Context, org string, mappings map[string]string, access map[string]OrgSecretAccess, existing map[string]*github.Secret) error {
+	if api.dryRunEnabled {
+		for _, secretName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: Would put Codespaces secret '%s' in organization %s\n", secretName, org)
+			if declared, ok := access[secretName]; ok {
+				if err := api.logAccessChanges(ctx, org, secretName, declared, existing[secretName]); err != nil {
+					return err
+				}
+			}
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: keyOutcome(existing, secretName)})
+		}
+		return nil
+	}
+
+	publicKey, _, err := api.GetOrgCodespacesPublicKey(ctx, org)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, secretName := range sortedKeys(mappings) {
+		encryptedSecret, err := encryptSecretWithPublicKey(publicKey, secretName, mappings[secretName])
+		if err != nil {
+			errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to encrypt secret %s: %w", secretName, err)))
+			continue
+		}
+		encryptedSecret.Visibility = defaultOrgSecretVisibility
+		if declared, ok := access[secretName]; ok {
+			if err := api.logAccessChanges(ctx, org, secretName, declared, existing[secretName]); err != nil {
+				errs = append(errs, failKey(ctx, "secret", secretName, err))
+				continue
+			}
+			encryptedSecret.Visibility = declared.Visibility
+			if declared.Visibility == visibilitySelected {
+				// The selected repository IDs replace the selection of the secret.
+				encryptedSecret.SelectedRepositoryIDs = declared.RepositoryIDs
+			}
+		} else if secret, ok := existing[secretName]; ok {
+			// Without selected repository IDs, the selection of secrets visible to selected repositories is kept.
+			encryptedSecret.Visibility = secret.Visibility
+		}
+
+		resp, err := api.CreateOrUpdateOrgCodespacesSecret(ctx, org, encryptedSecret)
+		if err != nil {
+			errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to update Codespaces secret %s in organization %s: %w", secretName, org, err)))
+			continue
+		}
+		recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: writeOutcome(resp)})
+	}
+	return errors.Join(errs...)
+}
+
+// logAccessChanges logs how the visibility and selected repositories of an existing secret change to the declared ones.
+func (api *gitHubAPI) logAccessChanges(ctx context.Context, org, secretName string, declared OrgSecretAccess, secret *github.Secret) error {
+	if secret == nil {
+		return nil
+	}
+	if secret.Visibility != declared.Visibility {
+		loggerFrom(ctx).Printf("Changing visibility of Codespaces secret '%s' in organization %s from %s to %s\n", secretName, org, secret.Visibility, declared.Visibility)
+	}
+	if declared.Visibility != visibilitySelected {
+		return nil
+	}
+	var current []string
+	if secret.Visibility == visibilitySelected {
+		repos, err := listAll(api.perPage, func(opts *github.ListOptions) ([]*github.Repository, *github.Response, error) {
+			list, resp, err := api.ListOrgCodespacesSecretRepositories(ctx, org, secretName, opts)
+			if err != nil {
+				return nil, resp, err
+			}
+			return list.Repositories, resp, nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list selected repositories of Codespaces secret %s in organization %s: %w", secretName, org, err)
+		}
+		for _, repo := range repos {
+			current = append(current, repo.GetName())
+		}
+	}
+	var added, removed []string
+	for _, name := range declared.Repositories {
+		if !slices.ContainsFunc(current, func(c string) bool { return strings.EqualFold(c, name) }) {
+			added = append(added, name)
+		}
+	}
+	for _, name := range current {
+		if !slices.ContainsFunc(declared.Repositories, func(d string) bool { return strings.EqualFold(d, name) }) {
+			removed = append(removed, name)
+		}
+	}
+	if len(added) > 0 {
+		loggerFrom(ctx).Printf("Adding repositories %s to Codespaces secret '%s' in organization %s\n", strings.Join(added, ", "), secretName, org)
+	}
+	if len(removed) > 0 {
+		loggerFrom(ctx).Printf("Removing repositories %s from Codespaces secret '%s' in organization %s\n", strings.Join(removed, ", "), secretName, org)
+	}
+	return nil
+}
+
+// SyncOrgCodespacesSecrets makes the Codespaces secrets of an organization match mappings, deleting all others.
+// The visibility and selected repositories of secrets declared in access are reconciled along with their values.
+func (api *gitHubAPI) SyncOrgCodespacesSecrets(ctx context.Context, org string, mappings map[string]string, access map[string]OrgSecretAccess) error {
+	existing, err := api.orgCodespacesSecrets(ctx, org)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, secretName := range sortedKeys(existing) {
+		if !shouldPrune(ctx, mappings, secretName) {
+			continue
+		}
+		if api.dryRunEnabled {
+			loggerFrom(ctx).Printf("Dry run: Would delete Codespaces secret '%s' from organization %s\n", secretName, org)
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: KeyDeleted})
+			continue
+		}
+		_, err := api.DeleteOrgCodespacesSecret(ctx, org, secretName)
+		if err != nil && !alreadyDeleted(err) {
+			errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to delete Codespaces secret %s from organization %s: %w", secretName, org, err)))
+			continue
+		}
+		recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: KeyDeleted})
+	}
+
+	return errors.Join(append(errs, api.putOrgCodespacesSecrets(ctx, org, mappings, access, existing))...)
+}
+
+// Ratelimiting
+
+func (r *rateLimitedGitHubAPI) GetOrgCodespacesPublicKey(ctx context.Context, org string) (*github.PublicKey, *github.Response, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
+	return r.client.GetOrgCodespacesPublicKey(ctx, org)
+}
+
+func (r *rateLimitedGitHubAPI) CreateOrUpdateOrgCodespacesSecret(ctx context.Context, org string, eSecret *github.EncryptedSecret) (*github.Response, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
+	return r.client.CreateOrUpdateOrgCodespacesSecret(ctx, org, eSecret)
+}
+
+func (r *rateLimitedGitHubAPI) DeleteOrgCodespacesSecret(ctx context.Context, org, name string) (*github.Response, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
+	return r.client.DeleteOrgCodespacesSecret(ctx, org, name)
+}
+
+func (r *rateLimitedGitHubAPI) ListOrgCodespacesSecrets(ctx context.Context, org string, opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
+	return r.client.ListOrgCodespacesSecrets(ctx, org, opts)
+}
+
+func (r *rateLimitedGitHubAPI) ListOrgCodespacesSecretRepositories(ctx context.Context, org, name string, opts *github.ListOptions) (*github.SelectedReposList, *github.Response, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
+	return r.client.ListOrgCodespacesSecretRepositories(ctx, org, name, opts)
+}
+
+func (r *rateLimitedGitHubAPI) PutOrgCodespacesSecrets(ctx context.Context, org string, mappings map[string]string, access map[string]OrgSecretAccess) error {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
+	return r.client.PutOrgCodespacesSecrets(ctx, org, mappings, access)
+}
+
+func (r *rateLimitedGitHubAPI) SyncOrgCodespacesSecrets(ctx context.Context, org string, mappings map[string]string, access map[string]OrgSecretAccess) error {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
+	return r.client.SyncOrgCodespacesSecrets(ctx, org, mappings, access)
+}
+
+func (r *rateLimitedGitHubAPI) GetOrgMembership(ctx context.Context, org string) (*github.Membership, *github.Response, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
+	return r.client.GetOrgMembership(ctx, org)
+}
+
+// Retryable
+
+func (r *retryableGitHubAPI) GetOrgCodespacesPublicKey(ctx context.Context, org string) (*github.PublicKey, *github.Response, error) {
+	var publicKey *github.PublicKey
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		publicKey, resp, err = r.client.GetOrgCodespacesPublicKey(ctx, org)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "GetOrgCodespacesPublicKey", retryFunc)
+	return publicKey, resp, err
+}
+
+func (r *retryableGitHubAPI) CreateOrUpdateOrgCodespacesSecret(ctx context.Context, org string, eSecret *github.EncryptedSecret) (*github.Response, error) {
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		resp, err = r.client.CreateOrUpdateOrgCodespacesSecret(ctx, org, eSecret)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "CreateOrUpdateOrgCodespacesSecret", retryFunc)
+	return resp, err
+}
+
+func (r *retryableGitHubAPI) DeleteOrgCodespacesSecret(ctx context.Context, org, name string) (*github.Response, error) {
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		resp, err = r.client.DeleteOrgCodespacesSecret(ctx, org, name)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "DeleteOrgCodespacesSecret", retryFunc)
+	return resp, err
+}
+
+func (r *retryableGitHubAPI) ListOrgCodespacesSecrets(ctx context.Context, org string, opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
+	var secrets *github.Secrets
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		secrets, resp, err = r.client.ListOrgCodespacesSecrets(ctx, org, opts)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "ListOrgCodespacesSecrets", retryFunc)
+	return secrets, resp, err
+}
+
+func (r *retryableGitHubAPI) ListOrgCodespacesSecretRepositories(ctx context.Context, org, name string, opts *github.ListOptions) (*github.SelectedReposList, *github.Response, error) {
+	var repos *github.SelectedReposList
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		repos, resp, err = r.client.ListOrgCodespacesSecretRepositories(ctx, org, name, opts)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "ListOrgCodespacesSecretRepositories", retryFunc)
+	return repos, resp, err
+}
+
+func (r *retryableGitHubAPI) PutOrgCodespacesSecrets(ctx context.Context, org string, mappings map[string]string, access map[string]OrgSecretAccess) error {
+	retryFunc := func() (bool, error) {
+		return true, classifyRetry(r.client.PutOrgCodespacesSecrets(ctx, org, mappings, access))
+	}
+
+	err := r.retry(ctx, "PutOrgCodespacesSecrets", retryFunc)
+	return err
+}
+
+func (r *retryableGitHubAPI) SyncOrgCodespacesSecrets(ctx context.Context, org string, mappings map[string]string, access map[string]OrgSecretAccess) error {
+	retryFunc := func() (bool, error) {
+		return true, classifyRetry(r.client.SyncOrgCodespacesSecrets(ctx, org, mappings, access))
+	}
+
+	err := r.retry(ctx, "SyncOrgCodespacesSecrets", retryFunc)
+	return err
+}
+
+func (r *retryableGitHubAPI) GetOrgMembership(ctx context.Context, org string) (*github.Membership, *github.Response, error) {
+	var membership *github.Membership
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		membership, resp, err = r.client.GetOrgMembership(ctx, org)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "GetOrgMembership", retryFunc)
+	return membership, resp, err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// Rate limit resources with separate quotas and reset windows.
+const (
+	coreResource   = "core"
+	searchResource = "search"
+)
+
+// resourceRate returns the rate of a resource from the response of the rate limit endpoint.
+func resourceRate(limits *github.RateLimits, resource string) *github.Rate {
+	if resource == searchResource {
+		return limits.GetSearch()
+	}
+	return limits.GetCore()
+}
+
+// rateLimitTracker records the rate limit state reported in the headers of every API response,
+// so the rate limit doesn't have to be queried before each request. The primary rate limits apply to the token,
+// secondary rate limits are tracked by the tracker of the owner whose resources were requested, see owner.
+type rateLimitTracker struct {
+	mu     sync.Mutex
+	rates  map[string]github.Rate
+	owners map[string]*ownerRateLimit
+}
+
+func newRateLimitTracker() *rateLimitTracker {
+	return &rateLimitTracker{rates: make(map[string]github.Rate), owners: make(map[string]*ownerRateLimit)}
+}
+
+// ownerRateLimit tracks the secondary rate limit of the requests to the resources of one owner, which GitHub
+// enforces per owner. Once one request hits it, the others to the same owner wait for it to pass, while those to
+// other owners carry on.
+type ownerRateLimit struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// owner returns the tracker of the secondary rate limit of owner.
+func (t *rateLimitTracker) owner(owner string) *ownerRateLimit {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	owner = strings.ToLower(owner)
+	limit, ok := t.owners[owner]
+	if !ok {
+		limit = &ownerRateLimit{}
+		t.owners[owner] = limit
+	}
+	return limit
+}
+
+// block records that the requests to the owner are limited until the given time.
+func (o *ownerRateLimit) block(until time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if until.After(o.until) {
+		o.until = until
+	}
+}
+
+// blockedUntil returns the end of the secondary rate limit, if it hasn't passed yet.
+func (o *ownerRateLimit) blockedUntil() (time.Time, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.until, time.Now().Before(o.until)
+}
+
+// requestOwner returns the owner of the resource requested by path, like /repos/{owner}/{repo}/... or
+// /orgs/{org}/..., including the /api/v3 prefix of GitHub Enterprise Server.
+func requestOwner(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i] == "repos" || segments[i] == "orgs" {
+			return segments[i+1]
+		}
+	}
+	return ""
+}
+
+// rate returns the last known rate of a resource, e.g. core or search.
+// The state is unknown if no response has reported it yet or its window has been reset since.
+func (t *rateLimitTracker) rate(resource string) (github.Rate, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rate, ok := t.rates[resource]
+	if !ok || !time.Now().Before(rate.Reset.Time) {
+		return github.Rate{}, false
+	}
+	return rate, true
+}
+
+// update records the rate of a resource.
+func (t *rateLimitTracker) update(resource string, rate github.Rate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rates[resource] = rate
+}
+
+// observe records the rate limit headers of a response, if present.
+func (t *rateLimitTracker) observe(header http.Header) {
+	limit, errLimit := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	remaining, errRemaining := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	reset, errReset := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if errLimit != nil || errRemaining != nil || errReset != nil {
+		return
+	}
+	resource := header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = coreResource
+	}
+	t.update(resource, github.Rate{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     github.Timestamp{Time: time.Unix(reset, 0)},
+	})
+}
+
+// rateLimitTransport feeds the rate limit headers of all responses into a tracker. Secondary rate limits, answered
+// with 403 or 429 and a Retry-After header, are recorded for the owner of the requested resource.
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	tracker *rateLimitTracker
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if resp != nil {
+		t.tracker.observe(resp.Header)
+		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests {
+			if owner := requestOwner(req.URL.Path); owner != "" {
+				if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+					t.tracker.owner(owner).block(time.Now().Add(d))
+				}
+			}
+		}
+	}
+	return resp, err
+}
@@ -2,10 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
 
-	"github.com/cenkalti/backoff/v5"
 	"github.com/google/go-github/v68/github"
 )
 
@@ -44,9 +44,19 @@ func (api *gitHubAPI) ListRepoSecrets(ctx context.Context, owner, repo string, o
 	return api.client.Actions.ListRepoSecrets(ctx, owner, repo, opts)
 }
 
+// CreateOrUpdateRepoVariable updates a variable, or creates it if it doesn't exist yet. Unlike deleting and creating
+// it, this never leaves the repository without the variable, so re-running a sync is idempotent.
 func (api *gitHubAPI) CreateOrUpdateRepoVariable(ctx context.Context, owner, repo string, variable *github.ActionsVariable) (*github.Response, error) {
-	_, _ = api.client.Actions.DeleteRepoVariable(ctx, owner, repo, variable.Name)
-	return api.client.Actions.CreateRepoVariable(ctx, owner, repo, variable)
+	resp, err := api.client.Actions.UpdateRepoVariable(ctx, owner, repo, variable)
+	if !isStatus(err, http.StatusNotFound) {
+		return resp, err
+	}
+	resp, err = api.client.Actions.CreateRepoVariable(ctx, owner, repo, variable)
+	// A concurrent sync may have created the variable in between, updating it converges to the same state.
+	if isStatus(err, http.StatusConflict) {
+		return api.client.Actions.UpdateRepoVariable(ctx, owner, repo, variable)
+	}
+	return resp, err
 }
 
 func (api *gitHubAPI) DeleteRepoVariable(ctx context.Context, owner, repo, variableName string) (*github.Response, error) {
@@ -59,17 +69,20 @@ func (api *gitHubAPI) ListRepoVariables(ctx context.Context, owner, repo string,
 
 func (api *gitHubAPI) SyncRepoSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	if api.dryRunEnabled {
-		log.Printf("Dry run: Syncing repository secrets for repo %s/%s\n", owner, repo)
-		opts := &github.ListOptions{PerPage: 100}
+		loggerFrom(ctx).Printf("Dry run: Syncing repository secrets for repo %s/%s\n", owner, repo)
+		existing := make(map[string]bool)
+		opts := &github.ListOptions{PerPage: api.perPage}
 		for {
 			secrets, resp, err := api.ListRepoSecrets(ctx, owner, repo, opts)
 			if err != nil {
-				return fmt.Errorf("dry run: failed to list existing secrets: %v", err)
+				return fmt.Errorf("dry run: failed to list existing secrets: %w", err)
 			}
 
 			for _, secret := range secrets.Secrets {
-				if _, exists := mappings[secret.Name]; !exists {
-					log.Printf("Dry run: Would delete secret '%s' from repo %s/%s\n", secret.Name, owner, repo)
+				existing[secret.Name] = true
+				if shouldPrune(ctx, mappings, secret.Name) {
+					loggerFrom(ctx).Printf("Dry run: Would delete secret '%s' from repo %s/%s\n", secret.Name, owner, repo)
+					recordKey(ctx, KeyResult{Kind: "secret", Name: secret.Name, Outcome: KeyDeleted})
 				}
 			}
 			if resp.NextPage == 0 {
@@ -78,8 +91,9 @@ func (api *gitHubAPI) SyncRepoSecrets(ctx context.Context, owner, repo string, m
 			opts.Page = resp.NextPage
 		}
 
-		for secretName := range mappings {
-			log.Printf("Dry run: Would add/update secret '%s' in repo %s/%s\n", secretName, owner, repo)
+		for _, secretName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: Would add/update secret '%s' in repo %s/%s\n", secretName, owner, repo)
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: keyOutcome(existing, secretName)})
 		}
 
 		return nil
@@ -87,11 +101,11 @@ func (api *gitHubAPI) SyncRepoSecrets(ctx context.Context, owner, repo string, m
 
 	existingMap := make(map[string]bool)
 
-	opts := &github.ListOptions{PerPage: 100}
+	opts := &github.ListOptions{PerPage: api.perPage}
 	for {
 		secrets, resp, err := api.ListRepoSecrets(ctx, owner, repo, opts)
 		if err != nil {
-			return fmt.Errorf("failed to list existing secrets: %v", err)
+			return fmt.Errorf("failed to list existing secrets: %w", err)
 		}
 
 		for _, secret := range secrets.Secrets {
@@ -104,58 +118,76 @@ func (api *gitHubAPI) SyncRepoSecrets(ctx context.Context, owner, repo string, m
 		opts.Page = resp.NextPage
 	}
 
-	for secretName := range existingMap {
-		if _, exists := mappings[secretName]; !exists {
+	var errs []error
+	for _, secretName := range sortedKeys(existingMap) {
+		if shouldPrune(ctx, mappings, secretName) {
 			_, err := api.DeleteRepoSecret(ctx, owner, repo, secretName)
-			if err != nil {
-				return fmt.Errorf("failed to delete secret %s: %v", secretName, err)
+			if err != nil && !alreadyDeleted(err) {
+				errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to delete secret %s: %w", secretName, err)))
+				continue
 			}
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: KeyDeleted})
 		}
 	}
 
-	return api.PutRepoSecrets(ctx, owner, repo, mappings)
+	return errors.Join(append(errs, api.PutRepoSecrets(ctx, owner, repo, mappings))...)
 }
 
 func (api *gitHubAPI) PutRepoSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	if api.dryRunEnabled {
-		log.Printf("Dry run: Putting repository secrets for repo %s/%s\n", owner, repo)
-		for secretName := range mappings {
-			log.Printf("Dry run: Would put secret '%s' in repo %s/%s\n", secretName, owner, repo)
+		loggerFrom(ctx).Printf("Dry run: Putting repository secrets for repo %s/%s\n", owner, repo)
+		existing, err := secretNames(api.perPage, func(opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
+			return api.ListRepoSecrets(ctx, owner, repo, opts)
+		})
+		if err != nil {
+			return fmt.Errorf("dry run: failed to list existing secrets: %w", err)
+		}
+		for _, secretName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: Would put secret '%s' in repo %s/%s\n", secretName, owner, repo)
+			recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: keyOutcome(existing, secretName)})
 		}
 		return nil
 	}
 
 	publicKey, _, err := api.GetRepoPublicKey(ctx, owner, repo)
 	if err != nil {
-		return fmt.Errorf("failed to get public key for repo %s/%s: %v", owner, repo, err)
+		return fmt.Errorf("failed to get public key for repo %s/%s: %w", owner, repo, err)
 	}
 
-	for secretName, secretValue := range mappings {
+	var errs []error
+	for _, secretName := range sortedKeys(mappings) {
+		secretValue := mappings[secretName]
 		secret, err := encryptSecretWithPublicKey(publicKey, secretName, secretValue)
 		if err != nil {
-			return fmt.Errorf("failed to encrypt secret %s: %v", secretName, err)
+			errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to encrypt secret %s: %w", secretName, err)))
+			continue
 		}
-		_, err = api.CreateOrUpdateRepoSecret(ctx, owner, repo, secret)
+		resp, err := api.CreateOrUpdateRepoSecret(ctx, owner, repo, secret)
 		if err != nil {
-			return fmt.Errorf("failed to update secret %s in repo %s/%s: %v", secretName, owner, repo, err)
+			errs = append(errs, failKey(ctx, "secret", secretName, fmt.Errorf("failed to update secret %s in repo %s/%s: %w", secretName, owner, repo, err)))
+			continue
 		}
+		recordKey(ctx, KeyResult{Kind: "secret", Name: secretName, Outcome: writeOutcome(resp)})
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func (api *gitHubAPI) SyncRepoVariables(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	if api.dryRunEnabled {
-		log.Printf("Dry run: Syncing repository variables for repo %s/%s", owner, repo)
-		opts := &github.ListOptions{PerPage: 100}
+		loggerFrom(ctx).Printf("Dry run: Syncing repository variables for repo %s/%s", owner, repo)
+		existing := make(map[string]string)
+		opts := &github.ListOptions{PerPage: api.perPage}
 		for {
 			variables, resp, err := api.ListRepoVariables(ctx, owner, repo, opts)
 			if err != nil {
-				return fmt.Errorf("dry run: failed to list existing variables: %v", err)
+				return fmt.Errorf("dry run: failed to list existing variables: %w", err)
 			}
 
 			for _, variable := range variables.Variables {
-				if _, exists := mappings[variable.Name]; !exists {
-					log.Printf("Dry run: Would delete variable '%s' from repo %s/%s", variable.Name, owner, repo)
+				existing[variable.Name] = variable.Value
+				if shouldPrune(ctx, mappings, variable.Name) {
+					loggerFrom(ctx).Printf("Dry run: Would delete variable '%s' with value %q from repo %s/%s\n", variable.Name, variable.Value, owner, repo)
+					recordKey(ctx, KeyResult{Kind: "variable", Name: variable.Name, Outcome: KeyDeleted})
 				}
 			}
 
@@ -165,8 +197,9 @@ func (api *gitHubAPI) SyncRepoVariables(ctx context.Context, owner, repo string,
 			opts.Page = resp.NextPage
 		}
 
-		for variableName := range mappings {
-			log.Printf("Dry run: Would add/update variable '%s' in repo %s/%s", variableName, owner, repo)
+		for _, variableName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: %s in repo %s/%s\n", describeVariableChange(existing, variableName, mappings[variableName]), owner, repo)
+			recordKey(ctx, KeyResult{Kind: "variable", Name: variableName, Outcome: variableOutcome(existing, variableName, mappings[variableName])})
 		}
 
 		return nil
@@ -174,11 +207,11 @@ func (api *gitHubAPI) SyncRepoVariables(ctx context.Context, owner, repo string,
 
 	existingMap := make(map[string]bool)
 
-	opts := &github.ListOptions{PerPage: 100}
+	opts := &github.ListOptions{PerPage: api.perPage}
 	for {
 		variables, resp, err := api.ListRepoVariables(ctx, owner, repo, opts)
 		if err != nil {
-			return fmt.Errorf("failed to list existing variables: %v", err)
+			return fmt.Errorf("failed to list existing variables: %w", err)
 		}
 
 		for _, variable := range variables.Variables {
@@ -192,92 +225,141 @@ func (api *gitHubAPI) SyncRepoVariables(ctx context.Context, owner, repo string,
 	}
 
 	// Delete variables not in mappings
-	for variableName := range existingMap {
-		if _, exists := mappings[variableName]; !exists {
+	var errs []error
+	for _, variableName := range sortedKeys(existingMap) {
+		if shouldPrune(ctx, mappings, variableName) {
 			_, err := api.DeleteRepoVariable(ctx, owner, repo, variableName)
-			if err != nil {
-				return fmt.Errorf("failed to delete variable %s: %v", variableName, err)
+			if err != nil && !alreadyDeleted(err) {
+				errs = append(errs, failKey(ctx, "variable", variableName, fmt.Errorf("failed to delete variable %s: %w", variableName, err)))
+				continue
 			}
+			recordKey(ctx, KeyResult{Kind: "variable", Name: variableName, Outcome: KeyDeleted})
 		}
 	}
 
 	// Add or update variables from mappings
-	return api.PutRepoVariables(ctx, owner, repo, mappings)
+	return errors.Join(append(errs, api.PutRepoVariables(ctx, owner, repo, mappings))...)
 }
 
 func (api *gitHubAPI) PutRepoVariables(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	if api.dryRunEnabled {
-		log.Printf("Dry run: Putting repository variables for repo %s/%s", owner, repo)
-		for variableName, variableValue := range mappings {
-			log.Printf("Dry run: Would put variable '%s' with value '%s' in repo %s/%s", variableName, variableValue, owner, repo)
+		loggerFrom(ctx).Printf("Dry run: Putting repository variables for repo %s/%s", owner, repo)
+		existing, err := variableValues(api.perPage, func(opts *github.ListOptions) (*github.ActionsVariables, *github.Response, error) {
+			return api.ListRepoVariables(ctx, owner, repo, opts)
+		})
+		if err != nil {
+			return fmt.Errorf("dry run: failed to list existing variables: %w", err)
+		}
+		for _, variableName := range sortedKeys(mappings) {
+			loggerFrom(ctx).Printf("Dry run: %s in repo %s/%s\n", describeVariableChange(existing, variableName, mappings[variableName]), owner, repo)
+			recordKey(ctx, KeyResult{Kind: "variable", Name: variableName, Outcome: variableOutcome(existing, variableName, mappings[variableName])})
 		}
 		return nil
 	}
 
-	for secretName, secretValue := range mappings {
+	// The existing variables tell created, updated, and unchanged apart.
+	existing, err := variableValues(api.perPage, func(opts *github.ListOptions) (*github.ActionsVariables, *github.Response, error) {
+		return api.ListRepoVariables(ctx, owner, repo, opts)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list existing variables: %w", err)
+	}
+
+	var errs []error
+	for _, variableName := range sortedKeys(mappings) {
+		variableValue := mappings[variableName]
+		// Variables can be read back, so writing the current value again is only audit log noise.
+		if variableOutcome(existing, variableName, variableValue) == KeyUnchanged {
+			recordKey(ctx, KeyResult{Kind: "variable", Name: variableName, Outcome: KeyUnchanged})
+			continue
+		}
 		_, err := api.CreateOrUpdateRepoVariable(ctx, owner, repo, &github.ActionsVariable{
-			Name:  secretName,
-			Value: secretValue,
+			Name:  variableName,
+			Value: variableValue,
 		})
 		if err != nil {
-			return fmt.Errorf("failed to update secret %s in repo %s/%s: %v", secretName, owner, repo, err)
+			errs = append(errs, failKey(ctx, "variable", variableName, fmt.Errorf("failed to update variable %s in repo %s/%s: %w", variableName, owner, repo, err)))
+			continue
 		}
+		recordKey(ctx, KeyResult{Kind: "variable", Name: variableName, Outcome: keyOutcome(existing, variableName)})
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func (r *rateLimitedGitHubAPI) PutRepoSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
 	return r.client.PutRepoSecrets(ctx, owner, repo, mappings)
 }
 
 func (r *rateLimitedGitHubAPI) GetRepoPublicKey(ctx context.Context, owner, repo string) (*github.PublicKey, *github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
 	return r.client.GetRepoPublicKey(ctx, owner, repo)
 }
 
 func (r *rateLimitedGitHubAPI) CreateOrUpdateRepoSecret(ctx context.Context, owner, repo string, eSecret *github.EncryptedSecret) (*github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.CreateOrUpdateRepoSecret(ctx, owner, repo, eSecret)
 }
 
 func (r *rateLimitedGitHubAPI) DeleteRepoSecret(ctx context.Context, owner, repo, name string) (*github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.DeleteRepoSecret(ctx, owner, repo, name)
 }
 
 func (r *rateLimitedGitHubAPI) ListRepoSecrets(ctx context.Context, owner, repo string, opts *github.ListOptions) (*github.Secrets, *github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
 	return r.client.ListRepoSecrets(ctx, owner, repo, opts)
 }
 
 func (r *rateLimitedGitHubAPI) SyncRepoSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
 	return r.client.SyncRepoSecrets(ctx, owner, repo, mappings)
 }
 
 func (r *rateLimitedGitHubAPI) PutRepoVariables(ctx context.Context, owner, repo string, mappings map[string]string) error {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
 	return r.client.PutRepoVariables(ctx, owner, repo, mappings)
 }
 
 func (r *rateLimitedGitHubAPI) CreateOrUpdateRepoVariable(ctx context.Context, owner, repo string, variable *github.ActionsVariable) (*github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.CreateOrUpdateRepoVariable(ctx, owner, repo, variable)
 }
 
 func (r *rateLimitedGitHubAPI) DeleteRepoVariable(ctx context.Context, owner, repo, variableName string) (*github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.DeleteRepoVariable(ctx, owner, repo, variableName)
 }
 
 func (r *rateLimitedGitHubAPI) ListRepoVariables(ctx context.Context, owner, repo string, opts *github.ListOptions) (*github.ActionsVariables, *github.Response, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
 	return r.client.ListRepoVariables(ctx, owner, repo, opts)
 }
 
 func (r *rateLimitedGitHubAPI) SyncRepoVariables(ctx context.Context, owner, repo string, mappings map[string]string) error {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return err
+	}
 	return r.client.SyncRepoVariables(ctx, owner, repo, mappings)
 }
 
@@ -290,10 +372,10 @@ func (r *retryableGitHubAPI) CreateOrUpdateRepoSecret(ctx context.Context, owner
 
 	retryFunc := func() (bool, error) {
 		resp, err = r.client.CreateOrUpdateRepoSecret(ctx, owner, repo, eSecret)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "CreateOrUpdateRepoSecret", retryFunc)
 	return resp, err
 }
 
@@ -303,10 +385,10 @@ func (r *retryableGitHubAPI) DeleteRepoSecret(ctx context.Context, owner, repo,
 
 	retryFunc := func() (bool, error) {
 		resp, err = r.client.DeleteRepoSecret(ctx, owner, repo, name)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "DeleteRepoSecret", retryFunc)
 	return resp, err
 }
 
@@ -317,10 +399,10 @@ func (r *retryableGitHubAPI) GetRepoPublicKey(ctx context.Context, owner, repo s
 
 	retryFunc := func() (bool, error) {
 		publicKey, resp, err = r.client.GetRepoPublicKey(ctx, owner, repo)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "GetRepoPublicKey", retryFunc)
 	return publicKey, resp, err
 }
 
@@ -331,26 +413,26 @@ func (r *retryableGitHubAPI) ListRepoSecrets(ctx context.Context, owner, repo st
 
 	retryFunc := func() (bool, error) {
 		secrets, resp, err = r.client.ListRepoSecrets(ctx, owner, repo, opts)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "ListRepoSecrets", retryFunc)
 	return secrets, resp, err
 }
 
 func (r *retryableGitHubAPI) PutRepoSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	retryFunc := func() (bool, error) {
-		return true, r.client.PutRepoSecrets(ctx, owner, repo, mappings)
+		return true, classifyRetry(r.client.PutRepoSecrets(ctx, owner, repo, mappings))
 	}
-	_, err := backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err := r.retry(ctx, "PutRepoSecrets", retryFunc)
 	return err
 }
 
 func (r *retryableGitHubAPI) SyncRepoSecrets(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	retryFunc := func() (bool, error) {
-		return true, r.client.SyncRepoSecrets(ctx, owner, repo, mappings)
+		return true, classifyRetry(r.client.SyncRepoSecrets(ctx, owner, repo, mappings))
 	}
-	_, err := backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err := r.retry(ctx, "SyncRepoSecrets", retryFunc)
 	return err
 }
 
@@ -360,10 +442,10 @@ func (r *retryableGitHubAPI) CreateOrUpdateRepoVariable(ctx context.Context, own
 
 	retryFunc := func() (bool, error) {
 		resp, err = r.client.CreateOrUpdateRepoVariable(ctx, owner, repo, variable)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "CreateOrUpdateRepoVariable", retryFunc)
 	return resp, err
 }
 
@@ -373,10 +455,10 @@ func (r *retryableGitHubAPI) DeleteRepoVariable(ctx context.Context, owner, repo
 
 	retryFunc := func() (bool, error) {
 		resp, err = r.client.DeleteRepoVariable(ctx, owner, repo, variableName)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "DeleteRepoVariable", retryFunc)
 	return resp, err
 }
 
@@ -387,25 +469,25 @@ func (r *retryableGitHubAPI) ListRepoVariables(ctx context.Context, owner, repo
 
 	retryFunc := func() (bool, error) {
 		variables, resp, err = r.client.ListRepoVariables(ctx, owner, repo, opts)
-		return true, err
+		return true, classifyRetry(err)
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "ListRepoVariables", retryFunc)
 	return variables, resp, err
 }
 
 func (r *retryableGitHubAPI) PutRepoVariables(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	retryFunc := func() (bool, error) {
-		return true, r.client.PutRepoVariables(ctx, owner, repo, mappings)
+		return true, classifyRetry(r.client.PutRepoVariables(ctx, owner, repo, mappings))
 	}
-	_, err := backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err := r.retry(ctx, "PutRepoVariables", retryFunc)
 	return err
 }
 
 func (r *retryableGitHubAPI) SyncRepoVariables(ctx context.Context, owner, repo string, mappings map[string]string) error {
 	retryFunc := func() (bool, error) {
-		return true, r.client.SyncRepoVariables(ctx, owner, repo, mappings)
+		return true, classifyRetry(r.client.SyncRepoVariables(ctx, owner, repo, mappings))
 	}
-	_, err := backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err := r.retry(ctx, "SyncRepoVariables", retryFunc)
 	return err
 }
@@ -2,70 +2,228 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 
-	"github.com/cenkalti/backoff/v5"
 	"github.com/google/go-github/v68/github"
 )
 
 // GitHubRepositorySearch for searching GitHub repositories.
 type GitHubRepositorySearch interface {
 	SearchRepositories(ctx context.Context, query string) ([]*github.Repository, error)
+	SearchRepositoriesPage(ctx context.Context, query string, opts *github.SearchOptions) (*github.RepositoriesSearchResult, *github.Response, error)
+	GetRepository(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error)
+	HasFile(ctx context.Context, owner, repo, path string) (bool, error)
+	GetFile(ctx context.Context, owner, repo, path string) ([]byte, bool, error)
 	Ratelimits(ctx context.Context) (*github.RateLimits, *github.Response, error)
+	AuthenticatedUser(ctx context.Context) (*github.User, *github.Response, error)
 }
 
+// SearchRepositories returns all repositories matching the query. Its pages are fetched concurrently, but at most
+// maxParallelSearchPages at a time, as the search API answers bursts of requests with secondary rate limits.
 func (api *gitHubAPI) SearchRepositories(ctx context.Context, query string) ([]*github.Repository, error) {
-	var allRepos []*github.Repository
-	opts := &github.SearchOptions{
-		ListOptions: github.ListOptions{
-			PerPage: 100,
-		},
-	}
-
-	for {
+	return fetchPages(maxParallelSearchPages, func(page int) ([]*github.Repository, *github.Response, error) {
+		opts := &github.SearchOptions{ListOptions: github.ListOptions{PerPage: api.perPage, Page: page}}
 		result, resp, err := api.client.Search.Repositories(ctx, query, opts)
 		if err != nil {
-			return nil, err
+			return nil, resp, err
 		}
+		return result.Repositories, resp, nil
+	})
+}
 
-		allRepos = append(allRepos, result.Repositories...)
-		if resp.NextPage == 0 {
-			break
-		}
-		opts.Page = resp.NextPage
+// SearchRepositoriesPage returns a single page of repositories matching the query.
+func (api *gitHubAPI) SearchRepositoriesPage(ctx context.Context, query string, opts *github.SearchOptions) (*github.RepositoriesSearchResult, *github.Response, error) {
+	return api.client.Search.Repositories(ctx, query, opts)
+}
+
+func (api *gitHubAPI) GetRepository(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error) {
+	return api.client.Repositories.Get(ctx, owner, repo)
+}
+
+// HasFile reports whether the default branch of the repository contains a file or directory at path.
+// Empty repositories have no default branch and contain nothing.
+func (api *gitHubAPI) HasFile(ctx context.Context, owner, repo, path string) (bool, error) {
+	_, _, resp, err := api.client.Repositories.GetContents(ctx, owner, repo, path, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetFile returns the content of the file at path on the default branch of the repository,
+// and false if there is none.
+func (api *gitHubAPI) GetFile(ctx context.Context, owner, repo, path string) ([]byte, bool, error) {
+	file, _, resp, err := api.client.Repositories.GetContents(ctx, owner, repo, path, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if file == nil {
+		return nil, false, fmt.Errorf("%s is a directory", path)
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, false, err
 	}
-	return allRepos, nil
+	return []byte(content), true, nil
 }
 
 func (api *gitHubAPI) Ratelimits(ctx context.Context) (*github.RateLimits, *github.Response, error) {
 	return api.client.RateLimit.Get(ctx)
 }
 
+// AuthenticatedUser returns the user the token authenticates as. Installation tokens of GitHub Apps have none.
+func (api *gitHubAPI) AuthenticatedUser(ctx context.Context) (*github.User, *github.Response, error) {
+	return api.client.Users.Get(ctx, "")
+}
+
 // Ratelimits
 
 func (r *rateLimitedGitHubAPI) SearchRepositories(ctx context.Context, query string) ([]*github.Repository, error) {
-	r.ensureRatelimits(ctx)
+	if err := r.ensureSearchRatelimits(ctx); err != nil {
+		return nil, err
+	}
 	return r.client.SearchRepositories(ctx, query)
 }
 
+func (r *rateLimitedGitHubAPI) SearchRepositoriesPage(ctx context.Context, query string, opts *github.SearchOptions) (*github.RepositoriesSearchResult, *github.Response, error) {
+	if err := r.ensureSearchRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
+	return r.client.SearchRepositoriesPage(ctx, query, opts)
+}
+
+func (r *rateLimitedGitHubAPI) GetRepository(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, nil, err
+	}
+	return r.client.GetRepository(ctx, owner, repo)
+}
+
+func (r *rateLimitedGitHubAPI) HasFile(ctx context.Context, owner, repo, path string) (bool, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return false, err
+	}
+	return r.client.HasFile(ctx, owner, repo, path)
+}
+
+func (r *rateLimitedGitHubAPI) GetFile(ctx context.Context, owner, repo, path string) ([]byte, bool, error) {
+	if err := r.ensureRatelimits(ctx); err != nil {
+		return nil, false, err
+	}
+	return r.client.GetFile(ctx, owner, repo, path)
+}
+
 func (r *rateLimitedGitHubAPI) Ratelimits(ctx context.Context) (*github.RateLimits, *github.Response, error) {
 	return r.client.Ratelimits(ctx)
 }
 
+func (r *rateLimitedGitHubAPI) AuthenticatedUser(ctx context.Context) (*github.User, *github.Response, error) {
+	return r.client.AuthenticatedUser(ctx)
+}
+
 // Retryable
 
+// SearchRepositories retries failed searches. The search API answers with 429 and a Retry-After header under load,
+// or with a secondary rate limit, which is honored instead of the exponential backoff that would retry too early.
+// A failing page fails the whole search, so it's retried as a whole.
 func (r *retryableGitHubAPI) SearchRepositories(ctx context.Context, query string) ([]*github.Repository, error) {
 	var repos []*github.Repository
 	var err error
 
 	retryFunc := func() (bool, error) {
 		repos, err = r.client.SearchRepositories(ctx, query)
-		return true, err
+		return true, classifyRetry(withRetryAfter(err))
 	}
 
-	_, err = backoff.Retry(ctx, retryFunc, r.backoffOptions...)
+	err = r.retry(ctx, "SearchRepositories", retryFunc)
 	return repos, err
 }
 
+func (r *retryableGitHubAPI) SearchRepositoriesPage(ctx context.Context, query string, opts *github.SearchOptions) (*github.RepositoriesSearchResult, *github.Response, error) {
+	var result *github.RepositoriesSearchResult
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		result, resp, err = r.client.SearchRepositoriesPage(ctx, query, opts)
+		return true, classifyRetry(withRetryAfter(err))
+	}
+
+	err = r.retry(ctx, "SearchRepositoriesPage", retryFunc)
+	return result, resp, err
+}
+
+func (r *retryableGitHubAPI) GetRepository(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error) {
+	var repository *github.Repository
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		repository, resp, err = r.client.GetRepository(ctx, owner, repo)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "GetRepository", retryFunc)
+	return repository, resp, err
+}
+
+func (r *retryableGitHubAPI) HasFile(ctx context.Context, owner, repo, path string) (bool, error) {
+	var exists bool
+	var err error
+
+	retryFunc := func() (bool, error) {
+		exists, err = r.client.HasFile(ctx, owner, repo, path)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "HasFile", retryFunc)
+	return exists, err
+}
+
+func (r *retryableGitHubAPI) GetFile(ctx context.Context, owner, repo, path string) ([]byte, bool, error) {
+	var content []byte
+	var exists bool
+	var err error
+
+	retryFunc := func() (bool, error) {
+		content, exists, err = r.client.GetFile(ctx, owner, repo, path)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "GetFile", retryFunc)
+	return content, exists, err
+}
+
+// Ratelimits retries failed queries of the rate limit, which the rate limit checks run before other requests, so a
+// connection reset doesn't fail the request they guard.
 func (r *retryableGitHubAPI) Ratelimits(ctx context.Context) (*github.RateLimits, *github.Response, error) {
-	return r.client.Ratelimits(ctx)
+	var limits *github.RateLimits
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		limits, resp, err = r.client.Ratelimits(ctx)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "Ratelimits", retryFunc)
+	return limits, resp, err
+}
+
+func (r *retryableGitHubAPI) AuthenticatedUser(ctx context.Context) (*github.User, *github.Response, error) {
+	var user *github.User
+	var resp *github.Response
+	var err error
+
+	retryFunc := func() (bool, error) {
+		user, resp, err = r.client.AuthenticatedUser(ctx)
+		return true, classifyRetry(err)
+	}
+
+	err = r.retry(ctx, "AuthenticatedUser", retryFunc)
+	return user, resp, err
 }
@@ -1,9 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/cenkalti/backoff/v5"
 	"github.com/google/go-github/v68/github"
 	"golang.org/x/crypto/nacl/box"
 
@@ -13,7 +26,7 @@ import (
 func encryptSecretWithPublicKey(publicKey *github.PublicKey, secretName, secretValue string) (*github.EncryptedSecret, error) {
 	decodedPublicKey, err := base64.StdEncoding.DecodeString(publicKey.GetKey())
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode public key: %v", err)
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
 	}
 
 	var boxKey [32]byte
@@ -21,7 +34,7 @@ func encryptSecretWithPublicKey(publicKey *github.PublicKey, secretName, secretV
 	secretBytes := []byte(secretValue)
 	encryptedBytes, err := box.SealAnonymous([]byte{}, secretBytes, &boxKey, crypto_rand.Reader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt secret: %v", err)
+		return nil, fmt.Errorf("failed to encrypt secret: %w", err)
 	}
 
 	encryptedString := base64.StdEncoding.EncodeToString(encryptedBytes)
@@ -38,7 +51,7 @@ func encryptSecretWithPublicKey(publicKey *github.PublicKey, secretName, secretV
 func encryptDependabotWithPublicKey(publicKey *github.PublicKey, secretName, secretValue string) (*github.DependabotEncryptedSecret, error) {
 	decodedPublicKey, err := base64.StdEncoding.DecodeString(publicKey.GetKey())
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode public key: %v", err)
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
 	}
 
 	var boxKey [32]byte
@@ -46,7 +59,7 @@ func encryptDependabotWithPublicKey(publicKey *github.PublicKey, secretName, sec
 	secretBytes := []byte(secretValue)
 	encryptedBytes, err := box.SealAnonymous([]byte{}, secretBytes, &boxKey, crypto_rand.Reader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt secret: %v", err)
+		return nil, fmt.Errorf("failed to encrypt secret: %w", err)
 	}
 
 	encryptedString := base64.StdEncoding.EncodeToString(encryptedBytes)
@@ -59,3 +72,407 @@ func encryptDependabotWithPublicKey(publicKey *github.PublicKey, secretName, sec
 	}
 	return encryptedSecret, nil
 }
+
+// isPermissionError reports whether err was caused by the token lacking access to a resource.
+// GitHub answers with 404 instead of 403 for private repositories the token can't see, so a 404 of a request to a
+// repository counts as well. A 404 of a request to an environment means it doesn't exist, see missingEnvironmentError.
+func isPermissionError(err error) bool {
+	var rateLimitErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	var missingErr *missingEnvironmentError
+	if errors.As(err, &rateLimitErr) || errors.As(err, &abuseErr) || errors.As(err, &missingErr) {
+		return false
+	}
+
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		switch errResp.Response.StatusCode {
+		case http.StatusForbidden:
+			return true
+		case http.StatusNotFound:
+			return errResp.Response.Request != nil && hidesPermissionErrors(errResp.Response.Request.URL.Path)
+		}
+	}
+	return false
+}
+
+// hidesPermissionErrors reports whether a 404 of a request to path may hide missing access to a repository, i.e.
+// whether it requests a resource of a repository other than an environment.
+func hidesPermissionErrors(path string) bool {
+	repository := strings.Contains(path, "/repos/") || strings.Contains(path, "/repositories/")
+	return repository && !strings.Contains(path, "/environments/")
+}
+
+// missingEnvironmentError is returned for environments that don't exist in a repository.
+type missingEnvironmentError struct {
+	Environment string
+	Repository  string
+	Err         error
+}
+
+func (e *missingEnvironmentError) Error() string {
+	return fmt.Sprintf("environment %s doesn't exist in %s: %v", e.Environment, e.Repository, e.Err)
+}
+
+func (e *missingEnvironmentError) Unwrap() error {
+	return e.Err
+}
+
+// isStatus reports whether err is an API error with the given status code.
+func isStatus(err error, status int) bool {
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == status
+}
+
+// alreadyDeleted reports whether deleting a key failed because it doesn't exist anymore, e.g. because a concurrent
+// sync pruned it first. The key is gone either way, so that's no failure.
+func alreadyDeleted(err error) bool {
+	return isStatus(err, http.StatusNotFound)
+}
+
+// rateLimitReset returns the time the rate limit resets if err was caused by an exhausted rate limit.
+func rateLimitReset(err error) (time.Time, bool) {
+	var exceededErr *RateLimitExceededError
+	if errors.As(err, &exceededErr) {
+		return exceededErr.Reset, true
+	}
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return rateLimitErr.Rate.Reset.Time, true
+	}
+	return time.Time{}, false
+}
+
+// isRetryable reports whether err is transient and worth retrying: network failures like connection resets,
+// DNS failures, timeouts, and unexpected EOFs, server errors, and rate limits. Other API errors, e.g. validation
+// failures or missing permissions, won't go away by retrying. Joined errors are retryable if any of them is.
+func isRetryable(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, inner := range joined.Unwrap() {
+			if isRetryable(inner) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var rateLimitErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &rateLimitErr) || errors.As(err, &abuseErr) {
+		return true
+	}
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		status := errResp.Response.StatusCode
+		return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryReason returns the class of a retryable error for logging.
+func retryReason(err error) string {
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return "rate limit"
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		return "secondary rate limit"
+	}
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		return fmt.Sprintf("HTTP %d", errResp.Response.StatusCode)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "network error"
+}
+
+// classifyRetry marks err as permanent for backoff unless it's retryable, so the remaining attempts aren't wasted.
+func classifyRetry(err error) error {
+	if err == nil || isRetryable(err) {
+		return err
+	}
+	return backoff.Permanent(err)
+}
+
+// retryAfter returns how long the API asked to wait before retrying, as done for secondary rate limits and
+// 429 responses of the search API under load.
+func retryAfter(err error) (time.Duration, bool) {
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) && abuseErr.RetryAfter != nil {
+		return *abuseErr.RetryAfter, true
+	}
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusTooManyRequests {
+		return parseRetryAfter(errResp.Response.Header.Get("Retry-After"))
+	}
+	return 0, false
+}
+
+// parseRetryAfter parses the value of a Retry-After header, given either in seconds or as HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// retryAfterError makes backoff wait as long as the API asked to, while keeping the message of the original error.
+type retryAfterError struct {
+	err        error
+	retryAfter *backoff.RetryAfterError
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() []error {
+	return []error{e.err, e.retryAfter}
+}
+
+// withRetryAfter returns err so that the next attempt waits for the duration the API asked for, if any.
+func withRetryAfter(err error) error {
+	if d, ok := retryAfter(err); ok {
+		return &retryAfterError{err: err, retryAfter: &backoff.RetryAfterError{Duration: d}}
+	}
+	return err
+}
+
+// defaultPerPage is the default and maximum number of items requested per page.
+const defaultPerPage = 100
+
+// sortedKeys returns the keys of m in ascending order, so operations and their logs are the same for every run.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// listAll collects the items of all pages returned by list, requesting perPage items per page.
+func listAll[T any](perPage int, list func(opts *github.ListOptions) ([]T, *github.Response, error)) ([]T, error) {
+	return fetchPages(maxParallelPages, func(page int) ([]T, *github.Response, error) {
+		return list(&github.ListOptions{PerPage: perPage, Page: page})
+	})
+}
+
+// maxParallelPages bounds the number of pages fetched at the same time.
+const maxParallelPages = 4
+
+// maxParallelSearchPages bounds the number of search pages fetched at the same time. It's lower than
+// maxParallelPages, as the search API answers bursts of requests with secondary rate limits.
+const maxParallelSearchPages = 2
+
+// fetchPages collects the items of all pages returned by list, in page order.
+// Once the first page tells the number of pages, the remaining ones are fetched concurrently, at most limit at a time.
+// No further pages are requested once one failed.
+func fetchPages[T any](limit int, list func(page int) ([]T, *github.Response, error)) ([]T, error) {
+	all, resp, err := list(1)
+	if err != nil {
+		return nil, err
+	}
+	if resp.NextPage == 0 {
+		return all, nil
+	}
+
+	// Without the last page in the links, the pages can only be followed one by one.
+	if resp.LastPage == 0 {
+		for resp.NextPage != 0 {
+			var items []T
+			items, resp, err = list(resp.NextPage)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, items...)
+		}
+		return all, nil
+	}
+
+	pages := make([][]T, resp.LastPage+1)
+	errs := make([]error, resp.LastPage+1)
+	sem := make(chan struct{}, limit)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for page := resp.NextPage; page <= resp.LastPage; page++ {
+		sem <- struct{}{}
+		if failed.Load() {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			pages[page], _, errs[page] = list(page)
+			if errs[page] != nil {
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	for _, items := range pages {
+		all = append(all, items...)
+	}
+	return all, nil
+}
+
+// writeOutcome tells from the response of a create-or-update call whether a secret was created or updated.
+func writeOutcome(resp *github.Response) KeyOutcome {
+	if resp != nil && resp.StatusCode == http.StatusCreated {
+		return KeyCreated
+	}
+	return KeyUpdated
+}
+
+// variableValues returns the values of all variables returned by list, keyed by name.
+func variableValues(perPage int, list func(opts *github.ListOptions) (*github.ActionsVariables, *github.Response, error)) (map[string]string, error) {
+	variables, err := listAll(perPage, func(opts *github.ListOptions) ([]*github.ActionsVariable, *github.Response, error) {
+		v, resp, err := list(opts)
+		if err != nil {
+			return nil, resp, err
+		}
+		return v.Variables, resp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(variables))
+	for _, variable := range variables {
+		values[variable.Name] = variable.Value
+	}
+	return values, nil
+}
+
+// secretNames returns the names of all secrets returned by list.
+// Dry runs use it to tell creations from updates, which costs a request per page and target that writing doesn't.
+func secretNames(perPage int, list func(opts *github.ListOptions) (*github.Secrets, *github.Response, error)) (map[string]bool, error) {
+	secrets, err := listAll(perPage, func(opts *github.ListOptions) ([]*github.Secret, *github.Response, error) {
+		s, resp, err := list(opts)
+		if err != nil {
+			return nil, resp, err
+		}
+		return s.Secrets, resp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(secrets))
+	for _, secret := range secrets {
+		names[secret.Name] = true
+	}
+	return names, nil
+}
+
+// keyOutcome tells whether writing a key creates or updates it, given the existing keys.
+func keyOutcome[V any](existing map[string]V, name string) KeyOutcome {
+	if _, ok := existing[name]; ok {
+		return KeyUpdated
+	}
+	return KeyCreated
+}
+
+// variableOutcome tells what writing a variable with the given value does, given the values of the existing variables.
+// Unlike secrets, variables can be read back, so writing the current value is no change.
+func variableOutcome(existing map[string]string, name, value string) KeyOutcome {
+	current, ok := existing[name]
+	switch {
+	case !ok:
+		return KeyCreated
+	case current == value:
+		return KeyUnchanged
+	}
+	return KeyUpdated
+}
+
+// describeVariableChange describes writing value to a variable for dry runs, given the values of the existing variables.
+// Unlike secrets, variables can be read back, so a dry run shows the actual change of their values.
+func describeVariableChange(existing map[string]string, name, value string) string {
+	switch variableOutcome(existing, name, value) {
+	case KeyCreated:
+		return fmt.Sprintf("Would add variable '%s' with value %q", name, value)
+	case KeyUnchanged:
+		return fmt.Sprintf("Variable '%s' is unchanged", name)
+	}
+	return fmt.Sprintf("Would change variable '%s' from %q to %q", name, existing[name], value)
+}
+
+type keptKeysContextKey struct{}
+
+// withKeptKeys returns a context under which prunes keep the given keys, although they aren't part of the input.
+func withKeptKeys(ctx context.Context, keys []string) context.Context {
+	kept := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		kept[strings.ToUpper(key)] = true
+	}
+	return context.WithValue(ctx, keptKeysContextKey{}, kept)
+}
+
+// shouldPrune reports whether an existing key is deleted by a prune, which is the case if it's neither part of
+// the input nor kept by ctx, and the prune policy of ctx allows it. Kept keys are matched case-insensitively,
+// as GitHub stores names uppercased.
+func shouldPrune(ctx context.Context, mappings map[string]string, name string) bool {
+	if _, ok := mappings[name]; ok {
+		return false
+	}
+	kept, _ := ctx.Value(keptKeysContextKey{}).(map[string]bool)
+	policy, _ := ctx.Value(prunePolicyContextKey{}).(*prunePolicy)
+	return !kept[strings.ToUpper(name)] && policy.allows(name)
+}
+
+// KeyError describes the failure to write or delete a single secret or variable.
+// Syncs continue with the remaining keys and return the failures of all keys joined.
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// keyErrors returns all KeyErrors contained in err, including those of joined errors.
+func keyErrors(err error) []*KeyError {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *KeyError:
+		return []*KeyError{e}
+	case interface{ Unwrap() []error }:
+		var errs []*KeyError
+		for _, inner := range e.Unwrap() {
+			errs = append(errs, keyErrors(inner)...)
+		}
+		return errs
+	default:
+		return keyErrors(errors.Unwrap(err))
+	}
+}
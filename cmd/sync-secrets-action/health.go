@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// defaultAPIURL is the API endpoint used unless another one is configured.
+const defaultAPIURL = "https://api.github.com/"
+
+// VersionCmd prints the build information.
+type VersionCmd struct {
+	JSON bool
+}
+
+// HealthcheckCmd verifies the configured API endpoint accepts the token.
+type HealthcheckCmd struct{}
+
+// BuildInfo describes the build of the binary.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Health is the result of a healthcheck.
+type Health struct {
+	Healthy bool       `json:"healthy"`
+	Build   BuildInfo  `json:"build"`
+	APIURL  string     `json:"api_url"`
+	Auth    HealthAuth `json:"auth"`
+}
+
+// HealthAuth is the authentication status of a healthcheck.
+type HealthAuth struct {
+	OK        bool   `json:"ok"`
+	TokenType string `json:"token_type"`
+	Error     string `json:"error,omitempty"`
+}
+
+func buildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		Revision:  Revision,
+		BuildTime: StartTime.Format("2006-01-02"),
+		GoVersion: GoVersion,
+	}
+}
+
+// runVersion writes the build information to w, as JSON if requested.
+func runVersion(cmd *VersionCmd, w io.Writer) error {
+	if !cmd.JSON {
+		_, err := io.WriteString(w, EnvArgs{}.Version())
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(buildInfo())
+}
+
+// runHealthcheck writes the build information, the API endpoint, and whether it accepts the token as JSON to w.
+// Unlike checkToken, any failure to reach the API counts, so it can serve as Docker HEALTHCHECK or readiness probe.
+// Reading the rate limit doesn't count against it. It reports whether the check passed.
+func runHealthcheck(ctx context.Context, client GitHubActionClient, token, apiURL string, w io.Writer) (bool, error) {
+	health := Health{
+		Build:  buildInfo(),
+		APIURL: apiURL,
+		Auth:   HealthAuth{TokenType: tokenType(token)},
+	}
+	if _, _, err := client.Ratelimits(ctx); err != nil {
+		health.Auth.Error = err.Error()
+	} else {
+		health.Auth.OK = true
+	}
+	health.Healthy = health.Auth.OK
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(health); err != nil {
+		return false, err
+	}
+	return health.Healthy, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cacheTransport caches the responses of GET requests in a directory, so they survive between runs.
+// Cached responses are revalidated by their ETag with every request. Freshness from Cache-Control max-age is ignored
+// on purpose, so a run never acts on data that changed since, e.g. by the writes of the previous run. GitHub answers
+// unchanged resources with 304 Not Modified, which doesn't count against the rate limit.
+// The responses may contain private data, so the directory is created accessible by the owner only.
+type cacheTransport struct {
+	base http.RoundTripper
+	dir  string
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	path := t.path(req)
+	cached, err := t.load(path, req)
+	if err != nil {
+		loggerFrom(req.Context()).Printf("Ignoring cached response of %s: %v", req.URL.Path, err)
+	}
+	if cached != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cached.Header.Get("ETag"))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		// The current headers, e.g. of the rate limit, replace the cached ones.
+		for key, values := range resp.Header {
+			cached.Header[key] = values
+		}
+		return cached, nil
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK && isCacheable(resp):
+		if err := t.store(path, resp); err != nil {
+			loggerFrom(req.Context()).Printf("Failed to cache response of %s: %v", req.URL.Path, err)
+		}
+	case cached != nil:
+		// The resource changed without a new validator or is gone, so the cached response must not be replayed.
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			loggerFrom(req.Context()).Printf("Failed to remove cached response of %s: %v", req.URL.Path, err)
+		}
+	}
+	return resp, nil
+}
+
+// isCacheable reports whether a response carries an ETag to revalidate it with and may be stored.
+func isCacheable(resp *http.Response) bool {
+	if strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+		return false
+	}
+	return resp.Header.Get("ETag") != ""
+}
+
+// path returns the file a request is cached in. Responses vary by token and media type, so both are part of the key,
+// hashed to not store the token.
+func (t *cacheTransport) path(req *http.Request) string {
+	h := sha256.New()
+	for _, part := range []string{req.URL.String(), req.Header.Get("Authorization"), req.Header.Get("Accept")} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return filepath.Join(t.dir, hex.EncodeToString(h.Sum(nil)))
+}
+
+// load reads the cached response of req, returning nil if there is none.
+func (t *cacheTransport) load(path string, req *http.Request) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		return nil, err
+	}
+	if !isCacheable(resp) {
+		// A response without an ETag can't be revalidated.
+		return nil, nil
+	}
+	// The body is read in full, so the response doesn't depend on the file anymore.
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// store writes resp to path, leaving its body readable. The file is replaced atomically,
+// so concurrent requests never read a partially written response.
+func (t *cacheTransport) store(path string, resp *http.Response) error {
+	data, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	f, err := os.CreateTemp(t.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
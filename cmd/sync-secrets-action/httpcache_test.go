@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheTransport(t *testing.T) {
+	testCases := []struct {
+		name              string
+		header            map[string]string
+		status            []int
+		expectBodies      []string
+		expectNotModified int
+	}{
+		{
+			name:              "revalidated by etag",
+			header:            map[string]string{"ETag": `"v1"`, "Cache-Control": "private, max-age=60"},
+			status:            []int{http.StatusOK, http.StatusOK},
+			expectBodies:      []string{`{"total_count":1}`, `{"total_count":1}`},
+			expectNotModified: 1,
+		},
+		{
+			name:         "no store",
+			header:       map[string]string{"ETag": `"v1"`, "Cache-Control": "no-store"},
+			status:       []int{http.StatusOK, http.StatusOK},
+			expectBodies: []string{`{"total_count":1}`, `{"total_count":1}`},
+		},
+		{
+			name:         "without etag",
+			header:       map[string]string{"Last-Modified": "Mon, 02 Jan 2006 15:04:05 GMT"},
+			status:       []int{http.StatusOK, http.StatusOK},
+			expectBodies: []string{`{"total_count":1}`, `{"total_count":1}`},
+		},
+		{
+			name:         "removed when gone",
+			header:       map[string]string{"ETag": `"v1"`},
+			status:       []int{http.StatusOK, http.StatusNotFound, http.StatusOK},
+			expectBodies: []string{`{"total_count":1}`, `{"message":"Not Found"}`, `{"total_count":1}`},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests, notModified int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tc.status[requests]
+				requests++
+				if status != http.StatusOK {
+					w.WriteHeader(status)
+					fmt.Fprint(w, `{"message":"Not Found"}`)
+					return
+				}
+				for key, value := range tc.header {
+					w.Header().Set(key, value)
+				}
+				if etag := r.Header.Get("If-None-Match"); etag != "" && etag == tc.header["ETag"] {
+					notModified++
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				fmt.Fprint(w, `{"total_count":1}`)
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: &cacheTransport{base: http.DefaultTransport, dir: t.TempDir() + "/cache"}}
+			for i, expected := range tc.expectBodies {
+				resp, err := client.Get(server.URL + "/repos/owner/repo/actions/secrets")
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if resp.StatusCode != tc.status[i] || string(body) != expected {
+					t.Errorf("Request %d: unexpected response %d %q", i, resp.StatusCode, body)
+				}
+			}
+			if requests != len(tc.expectBodies) || notModified != tc.expectNotModified {
+				t.Errorf("Expected %d requests, %d not modified, got %d requests, %d not modified", len(tc.expectBodies), tc.expectNotModified, requests, notModified)
+			}
+		})
+	}
+}
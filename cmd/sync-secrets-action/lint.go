@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholderPattern matches values that were obviously never filled in: well-known filler words
+// and angle-bracketed hints like <insert-token>.
+var placeholderPattern = regexp.MustCompile(`(?i)^(changeme|change[-_]me|todo|tbd|fixme|xxx+|placeholder|replace[-_]?me|dummy|<[^<>]+>)$`)
+
+// unrenderedPattern matches template expressions that were left unrendered.
+var unrenderedPattern = regexp.MustCompile(`\{\{.*\}\}`)
+
+// lintValue returns the problems found in a value that either commonly cause it to behave differently
+// in Actions or indicate it was never filled in. Template expressions are expected if values are templated.
+func lintValue(value string, templated bool) []string {
+	var problems []string
+	if strings.HasSuffix(value, "\n") {
+		problems = append(problems, "trailing newline")
+	}
+	if strings.Contains(value, "\r") {
+		problems = append(problems, "CRLF line endings")
+	}
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		problems = append(problems, "surrounding quotes")
+	}
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		problems = append(problems, "blank value")
+	} else if placeholderPattern.MatchString(trimmed) || (!templated && unrenderedPattern.MatchString(trimmed)) {
+		problems = append(problems, "placeholder value")
+	}
+	return problems
+}
+
+// lintValues checks all values of the input and logs a warning per problem, naming only the key.
+// If strict is set, an error is returned when any problem was found.
+func lintValues(kind string, values map[string]string, strict, templated bool) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var offending []string
+	for _, key := range keys {
+		problems := lintValue(values[key], templated)
+		if len(problems) == 0 {
+			continue
+		}
+		log.Printf("Warning: %s %s has %s\n", kind, key, strings.Join(problems, ", "))
+		offending = append(offending, key)
+	}
+	if strict && len(offending) > 0 {
+		return fmt.Errorf("%s with suspicious values: %s", kind, strings.Join(offending, ", "))
+	}
+	return nil
+}
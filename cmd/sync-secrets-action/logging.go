@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// Logger receives log output. *log.Logger implements it, and so do log groups, which buffer the output of a
+// repository synced concurrently with others.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+type loggerContextKey struct{}
+
+// withLogger returns a context that makes the sync functions log to logger.
+func withLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// loggerFrom returns the log group of ctx, the logger of ctx, or the standard logger if it carries neither.
+func loggerFrom(ctx context.Context) Logger {
+	if group, ok := ctx.Value(logGroupContextKey{}).(*logGroup); ok {
+		return group
+	}
+	if logger, ok := ctx.Value(loggerContextKey{}).(Logger); ok {
+		return logger
+	}
+	return log.Default()
+}
+
+type logGroupContextKey struct{}
+
+// logGroup buffers the log output of a repository, which flush writes at once as collapsible group of the log of the
+// workflow run, so the output of repositories synced in parallel doesn't interleave.
+type logGroup struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	logger *log.Logger
+}
+
+// flushMu keeps groups flushed at the same time from interleaving.
+var flushMu sync.Mutex
+
+func newLogGroup() *logGroup {
+	g := &logGroup{}
+	g.logger = log.New(&g.buf, log.Prefix(), log.Flags())
+	return g
+}
+
+// Printf logs to the buffer of the group with the prefix and flags of the standard logger.
+func (g *logGroup) Printf(format string, v ...any) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.logger.Printf(format, v...)
+}
+
+// write adds s to the buffer of the group as is, e.g. a workflow command that must start a line.
+func (g *logGroup) write(s string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.buf.WriteString(s)
+}
+
+// flush writes the output buffered since the last flush to w, enclosed by group and endgroup workflow commands.
+// Unless always is set, nothing is written if nothing was buffered.
+func (g *logGroup) flush(w io.Writer, title string, always bool) {
+	g.mu.Lock()
+	out := bytes.Clone(g.buf.Bytes())
+	g.buf.Reset()
+	g.mu.Unlock()
+	if len(out) == 0 && !always {
+		return
+	}
+	flushMu.Lock()
+	defer flushMu.Unlock()
+	fmt.Fprintf(w, "::group::%s\n", escapeCommandData(title))
+	_, _ = w.Write(out)
+	fmt.Fprintln(w, "::endgroup::")
+}
+
+// withLogGroup returns a context under which the sync functions and the client log to group.
+func withLogGroup(ctx context.Context, group *logGroup) context.Context {
+	return context.WithValue(ctx, logGroupContextKey{}, group)
+}
+
+// logGroupFlushInterval is how often the output of a repository that is still being synced is written, so a
+// repository that takes long, e.g. waiting for a rate limit reset, doesn't stay silent.
+const logGroupFlushInterval = 30 * time.Second
+
+// groupLogs wraps syncRepo to write the log output of every repository as group titled by the repository, which the
+// workflow run shows collapsed, so runs over many repositories stay navigable. The output is written once the
+// repository is synced, and while it's still being synced every logGroupFlushInterval as group marked in progress.
+func groupLogs(w io.Writer, syncRepo func(ctx context.Context, repo *github.Repository) error) func(ctx context.Context, repo *github.Repository) error {
+	return func(ctx context.Context, repo *github.Repository) error {
+		title := repo.GetOwner().GetLogin() + "/" + repo.GetName()
+		group := newLogGroup()
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(logGroupFlushInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					group.flush(w, title+" (in progress)", false)
+				}
+			}
+		}()
+		defer func() {
+			close(done)
+			wg.Wait()
+			group.flush(w, title, true)
+		}()
+		return syncRepo(withLogGroup(ctx, group), repo)
+	}
+}
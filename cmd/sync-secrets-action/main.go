@@ -1,14 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/alexflint/go-arg"
+	"github.com/google/go-github/v68/github"
 )
 
 var (
@@ -23,18 +34,87 @@ var (
 )
 
 // EnvArgs holds command-line arguments and environment variables for configuring the application.
+// The flags are defined by parseArgs.
 type EnvArgs struct {
-	TargetRepo  string `arg:"--target,env:TARGET"`
-	GithubToken string `arg:"--github-token,env:GITHUB_TOKEN,required"`
-	DryRun      bool   `arg:"--dry-run,env:DRY_RUN"`
-	Secrets     string `arg:"--secrets,env:SECRETS"`
-	Variables   string `arg:"--variables,env:VARIABLES"`
-	RateLimit   bool   `arg:"--rate-limit,env:RATE_LIMIT"`
-	MaxRetries  int    `arg:"--max-retries,env:MAX_RETRIES" default:"3"`
-	Prune       bool   `arg:"--prune,env:PRUNE"`
-	Environment string `arg:"--environment,env:ENVIRONMENT"`
-	Type        string `arg:"--type,env:TYPE" default:"actions"`
-	Query       string `arg:"--query,env:QUERY"`
+	Diff  *DiffCmd
+	Audit *AuditCmd
+	Check *CheckCmd
+
+	PrintVersion *VersionCmd
+	Healthcheck  *HealthcheckCmd
+	Whoami       *WhoamiCmd
+	Bootstrap    *BootstrapCmd
+
+	ValidateConfig *ValidateConfigCmd
+
+	Config              string
+	TargetRepo          string
+	Repos               string
+	ReposFile           string
+	Organization        string
+	OrgSecretVisibility string
+	GithubToken         string
+	DryRun              bool
+	Preflight           bool
+	Concurrency         int
+	Secrets             string
+	Variables           string
+	FromEnvironment     string
+	AllowKeys           string
+	RateLimit           bool
+	MaxRetries          int
+	Debug               bool
+	Prune               bool
+	PruneProtect        string
+	ManagedPrefix       string
+	AllowEmptyPrune     bool
+	Delete              string
+	SkipSecrets         bool
+	SkipVariables       bool
+	Environment         string
+	EnvironmentPattern  string
+	CreateEnvironment   bool
+	SkipMissingEnv      bool
+	Type                string
+	Query               string
+	ExcludeQuery        string
+	NameRegex           string
+	Filter              string
+	Order               string
+	ReportFile          string
+	PlanOut             string
+	Output              string
+	OutputFile          string
+	SkipEmpty           bool
+	RawInput            bool
+	ExpectKeys          string
+	StrictValues        bool
+	TemplateValues      bool
+	MetadataVars        bool
+	DetailedExitCode    bool
+	ContinueOnError     bool
+	FailFast            *bool
+	SkipRepos           string
+	SkipReposFile       string
+	RequireFile         string
+	RepoConfig          string
+
+	RateLimitPolicy  string
+	RateLimitMaxWait time.Duration
+	HTTPTimeout      time.Duration
+	HTTPMaxIdleConns int
+	HTTPKeepAlive    time.Duration
+	HTTPNoKeepAlive  bool
+	CacheDir         string
+	BaseURL          string
+	UploadURL        string
+	CACert           string
+	InsecureSkipTLS  bool
+	MockServer       string
+	PerPage          int
+
+	CPUProfile string
+	PprofAddr  string
 }
 
 // Version returns a formatted string with application version details.
@@ -42,6 +122,15 @@ func (EnvArgs) Version() string {
 	return fmt.Sprintf("Version: %s %s\nBuildTime: %s\n%s\n", Revision, Version, StartTime.Format("2006-01-02"), GoVersion)
 }
 
+// failFast reports whether the run stops at the first repository that fails. FailFast is nil unless set, the run then
+// stops unless ContinueOnError is set. Setting both to true is rejected.
+func (args EnvArgs) failFast() bool {
+	if args.FailFast != nil {
+		return *args.FailFast
+	}
+	return !args.ContinueOnError
+}
+
 // TargetType defines the type of target for secret synchronization.
 type TargetType string
 
@@ -53,182 +142,1018 @@ const (
 
 // main is the entry point of the application. It parses input arguments and orchestrates the synchronization process.
 func main() {
-	var args EnvArgs
-	arg.MustParse(&args)
+	args, ok, err := parseArgs(os.Args[1:], os.Stdout)
+	if err != nil {
+		// Exit code 2 is reserved for pending changes with --detailed-exitcode.
+		os.Exit(1)
+	}
+	if !ok {
+		return
+	}
+	debugEnabled = args.Debug
+
+	stopProfiling, err := startProfiling(args.CPUProfile, args.PprofAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopProfiling()
+
+	if args.PrintVersion != nil {
+		if err := runVersion(args.PrintVersion, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if args.ValidateConfig != nil {
+		problems, err := runValidateConfig(args.ValidateConfig, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if problems > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var baseURL, uploadURL *url.URL
+	if args.BaseURL == "" && args.MockServer == "" {
+		if apiURL, env := runnerAPIURL(); apiURL != "" {
+			args.BaseURL = apiURL
+			log.Printf("Using the GitHub API at %s from %s\n", apiURL, env)
+		}
+	}
+	if args.BaseURL != "" || args.UploadURL != "" {
+		if args.MockServer != "" {
+			log.Fatal("base-url and mock-server cannot be combined")
+		}
+		if args.BaseURL == "" {
+			log.Fatal("upload-url requires base-url")
+		}
+		if baseURL, uploadURL, err = enterpriseURLs(args.BaseURL, args.UploadURL); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.MockServer != "" {
+		mockURL, stopMock, err := startMockServer(args.MockServer)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer stopMock()
+		baseURL, _ = url.Parse(mockURL)
+		log.Printf("Running against the mock server at %s with the repositories of %s\n", mockURL, args.MockServer)
+		if args.GithubToken == "" {
+			args.GithubToken = "mock"
+		}
+	}
 
 	// Validate input arguments.
+	if args.GithubToken == "" {
+		log.Fatal("github-token is required")
+	}
 	if args.MaxRetries < 0 {
 		log.Fatal("max-retries cannot be less than 0")
 	}
-	if (args.TargetRepo != "" && args.Query != "") || (args.TargetRepo == "" && args.Query == "") {
-		log.Fatal("Either TargetRepo must be set or Query, not both")
+	if p := RateLimitPolicy(args.RateLimitPolicy); p != RateLimitWait && p != RateLimitFail {
+		log.Fatalf("unsupported rate-limit-policy %q, must be wait or fail", args.RateLimitPolicy)
+	}
+	if args.HTTPTimeout < 0 || args.HTTPMaxIdleConns < 0 || args.HTTPKeepAlive < 0 {
+		log.Fatal("http-timeout, http-max-idle-conns, and http-keep-alive cannot be less than 0")
+	}
+	if args.ContinueOnError && args.FailFast != nil && *args.FailFast {
+		log.Fatal("continue-on-error and fail-fast cannot both be true")
+	}
+	if args.PerPage < 1 || args.PerPage > defaultPerPage {
+		log.Fatalf("per-page must be between 1 and %d", defaultPerPage)
+	}
+	if args.RateLimitMaxWait < 0 {
+		log.Fatal("rate-limit-max-wait cannot be less than 0")
+	}
+	if args.Output != outputText && args.Output != outputJSON {
+		log.Fatalf("unsupported output %q, must be text or json", args.Output)
+	}
+	if args.OutputFile != "" && args.Output != outputJSON {
+		log.Fatal("output-file requires output json")
+	}
+	if args.Concurrency < 1 {
+		log.Fatal("concurrency cannot be less than 1")
+	}
+	if args.SkipSecrets && args.SkipVariables {
+		log.Fatal("skip-secrets and skip-variables cannot be combined")
+	}
+	if args.Config != "" && (args.TargetRepo != "" || args.Query != "" || args.Repos != "" || args.ReposFile != "" || args.Organization != "" || args.Secrets != "" || args.Variables != "" || args.FromEnvironment != "") {
+		log.Fatal("config cannot be combined with target, query, repos, repos-file, organization, secrets, variables, or from-environment")
+	}
+	if args.Config != "" && args.Bootstrap != nil {
+		log.Fatal("config cannot be combined with bootstrap")
+	}
+	var cfg *Config
+	if args.Config != "" {
+		if cfg, err = loadConfig(args.Config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.OrgSecretVisibility != "" && args.Organization == "" {
+		log.Fatal("org-secret-visibility requires organization")
+	}
+	protect, err := parseNamePatterns(args.PruneProtect)
+	if err != nil {
+		log.Fatalf("invalid prune-protect: %v", err)
 	}
 
-	ctx := context.Background()
-	apiClient := NewGitHubAPI(ctx, args.GithubToken, args.MaxRetries, args.RateLimit, args.DryRun)
+	// Cancelling the run, e.g. from the workflow UI, interrupts waiting for rate limit resets.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx = withPrunePolicy(ctx, &prunePolicy{protect: protect, managedPrefix: args.ManagedPrefix})
+	if args.CacheDir != "" {
+		if err := newCooldown(args.CacheDir, args.GithubToken).await(ctx, RateLimitPolicy(args.RateLimitPolicy), args.RateLimitMaxWait); err != nil {
+			exitIfRateLimited(err)
+			log.Fatal(err)
+		}
+	}
+	var rootCAs *x509.CertPool
+	if args.CACert != "" {
+		if rootCAs, err = loadRootCAs(args.CACert); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.InsecureSkipTLS {
+		log.Printf("Warning: the certificate of the GitHub API isn't verified, the connection and the token are open to interception")
+	}
+	usage := newAPIUsage()
+	apiClient := NewGitHubAPI(ctx, ClientOptions{
+		Token:                 args.GithubToken,
+		MaxRetries:            args.MaxRetries,
+		RateLimitCheckEnabled: args.RateLimit,
+		RateLimitPolicy:       RateLimitPolicy(args.RateLimitPolicy),
+		RateLimitMaxWait:      args.RateLimitMaxWait,
+		DryRunEnabled:         args.DryRun,
+		HTTPTimeout:           args.HTTPTimeout,
+		MaxIdleConns:          args.HTTPMaxIdleConns,
+		KeepAlive:             args.HTTPKeepAlive,
+		DisableKeepAlives:     args.HTTPNoKeepAlive,
+		CacheDir:              args.CacheDir,
+		PerPage:               args.PerPage,
+		Usage:                 usage,
+		BaseURL:               baseURL,
+		UploadURL:             uploadURL,
+		RootCAs:               rootCAs,
+		InsecureSkipTLSVerify: args.InsecureSkipTLS,
+	})
+	if args.Healthcheck != nil {
+		apiURL := defaultAPIURL
+		if baseURL != nil {
+			apiURL = baseURL.String()
+		}
+		healthy, err := runHealthcheck(ctx, apiClient, args.GithubToken, apiURL, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !healthy {
+			os.Exit(1)
+		}
+		return
+	}
+	rateLimits := readRateLimits(ctx, apiClient, usage.tracker)
+	if err := checkToken(ctx, rateLimits, args.GithubToken); err != nil {
+		log.Fatal(err)
+	}
+	// Misconfigured credentials show in the first lines of the log, before anything is synced.
+	identity, err := whoami(ctx, apiClient, rateLimits, args.GithubToken)
+	if args.Whoami != nil {
+		if err != nil {
+			log.Fatal(err)
+		}
+		logIdentity(log.New(os.Stdout, "", 0), identity)
+		return
+	}
+	if err != nil {
+		log.Printf("Warning: %v\n", err)
+	} else {
+		logIdentity(log.Default(), identity)
+	}
+	usage.markStart()
+
+	if args.Diff != nil {
+		if err := runDiff(ctx, args.Diff, args, apiClient, os.Stdout); err != nil {
+			exitIfRateLimited(err)
+			log.Fatalf("Error comparing variables: %v", err)
+		}
+		return
+	}
+	if args.Audit != nil {
+		if err := runAudit(ctx, args.Audit, args, apiClient, os.Stdout); err != nil {
+			exitIfRateLimited(err)
+			log.Fatalf("Error auditing repositories: %v", err)
+		}
+		return
+	}
+	if args.Check != nil {
+		violations, err := runCheck(ctx, args.Check, args, apiClient, os.Stdout)
+		if err != nil {
+			exitIfRateLimited(err)
+			log.Fatalf("Error checking repositories: %v", err)
+		}
+		if violations > 0 {
+			if args.DetailedExitCode {
+				os.Exit(exitChanges)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cfg != nil {
+		report := &Report{DryRun: args.DryRun, usage: usage}
+		runs, err := configRuns(cfg, args, apiClient, report)
+		if err != nil {
+			log.Fatal(err)
+		}
+		runSyncs(ctx, args, runs, report)
+		return
+	}
 
 	// Parse secrets and variables from the provided strings.
-	secretsMap, err := parseKeyValuePairs(args.Secrets)
+	secretsMap, err := parseKeyValuePairs(args.Secrets, newParseOptions(args))
 	if err != nil {
 		log.Fatalf("Error parsing secrets: %v", err)
 	}
+	// The runner masks the secrets passed to the action as they are, which misses values unquoted or unescaped by
+	// parsing. Masking every parsed value keeps them out of the log, whatever logs them later.
+	maskValues(secretsMap)
 
-	variablesMap, err := parseKeyValuePairs(args.Variables)
+	variablesMap, err := parseKeyValuePairs(args.Variables, newParseOptions(args))
 	if err != nil {
 		log.Fatalf("Error parsing variables: %v", err)
 	}
 
-	// Process repositories based on the provided target repository or query.
-	if args.Query != "" {
-		repos, err := apiClient.SearchRepositories(ctx, args.Query)
+	// Promoting the variables of one environment to others keeps the values within GitHub.
+	var promotion *Promotion
+	if args.FromEnvironment != "" {
+		if len(variablesMap) > 0 {
+			log.Fatal("variables and from-environment cannot be combined")
+		}
+		source, err := parseVariableSource(args.FromEnvironment)
+		if err != nil {
+			log.Fatalf("Invalid from-environment: %v", err)
+		}
+		if len(splitList(args.AllowKeys)) == 0 {
+			log.Fatal("from-environment requires allow-keys listing the keys to promote")
+		}
+		values, err := fetchVariables(ctx, apiClient, source, args.PerPage)
 		if err != nil {
-			log.Fatalf("Error searching for repositories: %v", err)
+			exitIfRateLimited(err)
+			log.Fatalf("Error reading variables: %v", err)
+		}
+		if variablesMap, promotion, err = promoteValues(values, splitList(args.AllowKeys)); err != nil {
+			log.Fatalf("Error promoting variables of %s: %v", source, err)
+		}
+		promotion.Source = source.String()
+		log.Printf("Promoting %d variables of %s: %s\n", len(promotion.Keys), source, strings.Join(promotion.Keys, ", "))
+		if len(promotion.Excluded) > 0 {
+			log.Printf("Not promoting variables missing from allow-keys: %s\n", strings.Join(promotion.Excluded, ", "))
+		}
+	}
+
+	if secretsMap, variablesMap, err = prepareValues(args, secretsMap, variablesMap); err != nil {
+		log.Fatal(err)
+	}
+
+	if args.Bootstrap != nil {
+		if keys := append(deletedKeys(secretsMap), deletedKeys(variablesMap)...); len(keys) > 0 {
+			log.Fatalf("bootstrap doesn't delete keys, but %s are marked with %s", strings.Join(keys, ", "), deleteSentinel)
+		}
+		report := &Report{DryRun: args.DryRun, usage: usage}
+		err := runBootstrap(ctx, args.Bootstrap, args, apiClient, secretsMap, variablesMap, report)
+		finishReport(args, report)
+		if err != nil {
+			exitIfRateLimited(err)
+			log.Fatalf("Error bootstrapping repositories: %v", err)
+		}
+		return
+	}
+
+	report := &Report{DryRun: args.DryRun, Promotion: promotion, usage: usage}
+	run, err := newSyncRun(args, apiClient, secretsMap, variablesMap, report)
+	if err != nil {
+		log.Fatal(err)
+	}
+	runSyncs(ctx, args, []*syncRun{run}, report)
+}
+
+// prepareValues checks the parsed secrets and variables against the arguments and returns them without the kinds
+// that are skipped.
+func prepareValues(args EnvArgs, secretsMap, variablesMap map[string]string) (map[string]string, map[string]string, error) {
+	// Broken templating could otherwise prune keys that are only missing from the input.
+	if err := checkExpectedKeys(splitList(args.ExpectKeys), secretsMap, variablesMap); err != nil {
+		return nil, nil, err
+	}
+
+	// Skipped kinds are never written nor pruned, even if an input shared between jobs contains them.
+	if args.SkipSecrets {
+		if len(secretsMap) > 0 {
+			log.Printf("Ignoring %d secrets because of skip-secrets", len(secretsMap))
+		}
+		secretsMap = nil
+	}
+	if args.SkipVariables {
+		if len(variablesMap) > 0 {
+			log.Printf("Ignoring %d variables because of skip-variables", len(variablesMap))
+		}
+		variablesMap = nil
+	}
+
+	// An empty input, e.g. because the secrets it's templated from are missing, would prune every existing key.
+	if args.Prune && !hasValues(secretsMap) && !hasValues(variablesMap) && !args.AllowEmptyPrune {
+		return nil, nil, errors.New("prune is enabled but there are neither secrets nor variables to sync, which would delete every existing key; set allow-empty-prune to confirm")
+	}
+
+	// Keys are matched like those of the inputs. Keys that can never be deleted fail the run, as they'd be kept silently.
+	protect, err := parseNamePatterns(args.PruneProtect)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid prune-protect: %w", err)
+	}
+	for _, key := range splitList(args.Delete) {
+		key = strings.ToUpper(key)
+		if err := validateKeyName(key); err != nil {
+			return nil, nil, fmt.Errorf("invalid delete: %w", err)
+		}
+		if value, ok := secretsMap[key]; ok && value != deleteSentinel {
+			return nil, nil, fmt.Errorf("%s is part of the secrets and of delete", key)
 		}
-		for _, repo := range repos {
-			targetOwner := repo.GetOwner().GetLogin()
-			targetRepoName := repo.GetName()
-			processRepository(ctx, args, apiClient, targetOwner, targetRepoName, secretsMap, variablesMap)
+		if value, ok := variablesMap[key]; ok && value != deleteSentinel {
+			return nil, nil, fmt.Errorf("%s is part of the variables and of delete", key)
 		}
+		if matchAny(protect, key) {
+			return nil, nil, fmt.Errorf("%s is part of delete but matches prune-protect, so it's never deleted", key)
+		}
+	}
+
+	if err := lintValues("secret", secretsMap, args.StrictValues, args.TemplateValues); err != nil {
+		return nil, nil, err
+	}
+	if err := lintValues("variable", variablesMap, args.StrictValues, args.TemplateValues); err != nil {
+		return nil, nil, err
+	}
+	return secretsMap, variablesMap, nil
+}
+
+// newSyncRun returns the sync of secretsMap and variablesMap to the targets of args, which adds its results to
+// report. The inputs are checked before anything is synced.
+func newSyncRun(args EnvArgs, client GitHubActionClient, secretsMap, variablesMap map[string]string, report *Report) (*syncRun, error) {
+	run := &syncRun{args: args, client: client, secrets: secretsMap, variables: variablesMap, report: report}
+	var err error
+	if args.TemplateValues {
+		if run.secretTemplates, err = parseValueTemplates(secretsMap); err != nil {
+			return nil, fmt.Errorf("error parsing secrets: %w", err)
+		}
+		if run.variableTemplates, err = parseValueTemplates(variablesMap); err != nil {
+			return nil, fmt.Errorf("error parsing variables: %w", err)
+		}
+	}
+	if run.targets, err = matrixTargets(args.Type, args.Environment); err != nil {
+		return nil, err
+	}
+	if err := checkTargetInputs(run.targets, args.Environment, variablesMap); err != nil {
+		return nil, err
+	}
+	if run.environments, err = parseNamePatterns(args.EnvironmentPattern); err != nil {
+		return nil, fmt.Errorf("invalid environment-pattern: %w", err)
+	}
+	if len(run.environments) > 0 && !slices.ContainsFunc(run.targets, func(t syncTarget) bool { return t.Type == Actions }) {
+		return nil, errors.New("environment-pattern is only supported for type actions")
+	}
+	return run, nil
+}
+
+// run syncs to the organization or the repositories selected by the arguments.
+func (s *syncRun) run(ctx context.Context) error {
+	args := s.args
+	if s.name != "" {
+		log.Printf("Running job %s\n", s.name)
+	}
+	if args.Organization != "" {
+		return syncOrganization(ctx, args, s.client, s.targets, s.secrets, s.report)
+	}
+	repos, err := resolveRepositories(ctx, args, s.client)
+	if err != nil {
+		return err
+	}
+	repos = s.report.orderByOwner(repos)
+	if args.Preflight {
+		// Every repository is checked before any of them is changed, so the selection is collected first.
+		var all []*github.Repository
+		for repo, err := range repos {
+			if err != nil {
+				return err
+			}
+			all = append(all, repo)
+		}
+		if err := preflight(ctx, s.client, all); err != nil {
+			return err
+		}
+		repos = func(yield func(*github.Repository, error) bool) {
+			for _, repo := range all {
+				if !yield(repo, nil) {
+					return
+				}
+			}
+		}
+	}
+	syncRepo := s.syncRepository
+	if !args.failFast() {
+		syncRepo = continueOnError(syncRepo)
+	}
+	if inGitHubActions() {
+		syncRepo = groupLogs(log.Writer(), syncRepo)
+	}
+	return syncByOwner(ctx, repos, args.Concurrency, syncRepo)
+}
+
+// runSyncs runs all syncs, which share the report, and finishes the report. The run ends unsuccessfully if a sync
+// fails or leaves repositories failed.
+func runSyncs(ctx context.Context, args EnvArgs, runs []*syncRun, report *Report) {
+	for _, run := range runs {
+		if err := run.run(ctx); err != nil {
+			finishReport(args, report)
+			exitIfRateLimited(err)
+			log.Fatal(err)
+		}
+	}
+
+	finishReport(args, report)
+	if failed := report.FailedRepositories(); len(failed) > 0 {
+		log.Fatalf("Failed to sync %d repositories: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	// Scheduled drift detection can fail on pending changes without parsing the logs.
+	if args.DetailedExitCode && args.DryRun && len(report.ModifiedRepositories()) > 0 {
+		os.Exit(exitChanges)
+	}
+}
+
+// continueOnError wraps syncRepo to log the error of a repository and continue with the others, which the report
+// lists as failed. Rate limits and cancellation still end the run, as all other repositories would fail alike.
+func continueOnError(syncRepo func(ctx context.Context, repo *github.Repository) error) func(ctx context.Context, repo *github.Repository) error {
+	return func(ctx context.Context, repo *github.Repository) error {
+		err := syncRepo(ctx, repo)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if _, ok := rateLimitReset(err); ok {
+			return err
+		}
+		loggerFrom(ctx).Printf("Continuing with the other repositories after error: %v\n", err)
+		return nil
+	}
+}
+
+// syncRun holds the inputs of a sync shared by all repositories.
+type syncRun struct {
+	// name is the name of the job of the manifest, if any.
+	name                               string
+	args                               EnvArgs
+	client                             GitHubActionClient
+	targets                            []syncTarget
+	environments                       []namePattern
+	secrets, variables                 map[string]string
+	secretTemplates, variableTemplates valueTemplates
+	report                             *Report
+}
+
+// syncRepository syncs all targets of a repository and adds the results to the report.
+// Repositories skipped because of their configuration or missing permissions aren't failures,
+// the returned error ends the run.
+func (s *syncRun) syncRepository(ctx context.Context, repo *github.Repository) error {
+	args, report := s.args, s.report
+	fullName := repo.GetOwner().GetLogin() + "/" + repo.GetName()
+	repoTargets := s.targets
+	var repoConfig *RepoConfig
+	if args.RepoConfig != "" {
+		data, found, err := s.client.GetFile(ctx, repo.GetOwner().GetLogin(), repo.GetName(), args.RepoConfig)
+		if err != nil {
+			err = fmt.Errorf("failed to read %s of %s: %w", args.RepoConfig, fullName, err)
+			report.Add(RepoResult{Repository: fullName, Status: StatusFailed, Error: err.Error()})
+			return err
+		}
+		if found {
+			// A broken configuration must neither stop the sync of other repositories nor apply partially.
+			if repoConfig, err = parseRepoConfig(data); err != nil {
+				loggerFrom(ctx).Printf("Skipping %s: invalid %s: %v\n", fullName, args.RepoConfig, err)
+				report.Add(RepoResult{Repository: fullName, Status: StatusSkipped, Reason: "invalid repo config"})
+				return nil
+			}
+			if repoConfig.OptOut {
+				loggerFrom(ctx).Printf("Skipping %s: opted out in %s\n", fullName, args.RepoConfig)
+				report.Add(RepoResult{Repository: fullName, Status: StatusSkipped, Reason: "opted out"})
+				return nil
+			}
+			repoTargets = repoConfig.applyTargets(s.targets)
+			ctx = withKeptKeys(ctx, repoConfig.ExcludeKeys)
+		}
+	}
+	// The environments of the repo config take precedence over those matching a pattern.
+	listed := make(map[string]bool)
+	if len(s.environments) > 0 && (repoConfig == nil || len(repoConfig.Environments) == 0) {
+		names, err := listEnvironmentNames(ctx, s.client, repo, args.PerPage)
+		if err != nil && isPermissionError(err) {
+			// Like repositories the token can't administer, those it can't list the environments of are skipped.
+			loggerFrom(ctx).Printf("Skipping %s: insufficient permissions to list environments: %v\n", fullName, err)
+			report.Add(RepoResult{Repository: fullName, Type: Actions, Status: StatusSkipped, Reason: "insufficient permissions"})
+			return nil
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to list environments of %s: %w", fullName, err)
+			report.Add(RepoResult{Repository: fullName, Type: Actions, Status: StatusFailed, Error: err.Error()})
+			return err
+		}
+		for _, name := range names {
+			listed[strings.ToLower(name)] = true
+		}
+		repoTargets = environmentTargets(repoTargets, names, s.environments)
+		if len(repoTargets) == 0 {
+			loggerFrom(ctx).Printf("Skipping %s: no environment matches environment-pattern\n", fullName)
+			report.Add(RepoResult{Repository: fullName, Type: Actions, Status: StatusSkipped, Reason: "no matching environment"})
+			return nil
+		}
+	}
+
+	// Metadata variables describe the repository, so they're written once, to its repository-level Actions variables,
+	// or if only environments are synced, to the first of them. Variables only exist for Actions.
+	metadataTarget := -1
+	if args.MetadataVars && !args.SkipVariables {
+		metadataTarget = slices.Index(repoTargets, syncTarget{Type: Actions})
+		if metadataTarget < 0 {
+			metadataTarget = slices.IndexFunc(repoTargets, func(t syncTarget) bool { return t.Type == Actions })
+		}
+	}
+	for i, target := range repoTargets {
+		secrets, variables := s.secrets, s.variables
+		if args.TemplateValues {
+			data := templateData{
+				Owner:       repo.GetOwner().GetLogin(),
+				Repository:  repo.GetName(),
+				FullName:    fullName,
+				Environment: target.Environment,
+				Type:        string(target.Type),
+			}
+			// Derived secrets weren't masked by the runner, unlike secrets passed to the action.
+			var err error
+			if secrets, err = s.secretTemplates.render(data, maskFunc()); err == nil {
+				variables, err = s.variableTemplates.render(data, nil)
+			}
+			if err != nil {
+				err = fmt.Errorf("error rendering values for %s: %w", target.describe(fullName), err)
+				report.Add(RepoResult{Repository: fullName, Type: target.Type, Environment: target.Environment, Status: StatusFailed, Error: err.Error()})
+				return err
+			}
+		}
+		if i == metadataTarget {
+			variables = withMetadataVariables(repo, variables)
+		}
+		if repoConfig != nil {
+			secrets, variables = repoConfig.applyValues(secrets), repoConfig.applyValues(variables)
+		}
+		targetCtx := ctx
+		if target.Type == Actions && listed[strings.ToLower(target.Environment)] {
+			targetCtx = withListedEnvironment(ctx)
+		}
+		result, err := processRepository(targetCtx, args.withTarget(target), s.client, repo, secrets, variables)
+		if err != nil {
+			// Broad queries inevitably match repositories the token can't administer.
+			if args.Query == "" || !isPermissionError(err) {
+				report.Add(result)
+				return fmt.Errorf("failed to process %s: %w", result.Target(), err)
+			}
+			loggerFrom(ctx).Printf("Skipping %s: insufficient permissions: %v\n", result.Target(), err)
+			result = RepoResult{Repository: result.Repository, Type: result.Type, Environment: result.Environment, Status: StatusSkipped, Reason: "insufficient permissions"}
+		}
+		report.Add(result)
+	}
+	return nil
+}
+
+// exitChanges is the exit code of dry runs and checks that found pending changes with --detailed-exitcode.
+const exitChanges = 2
+
+// exitRateLimited is the exit code of runs aborted because the rate limit is exhausted.
+const exitRateLimited = 3
+
+// exitIfRateLimited ends the run with exitRateLimited if err was caused by an exhausted rate limit.
+// The reset time is set as output, so workflows can reschedule the sync.
+func exitIfRateLimited(err error) {
+	reset, ok := rateLimitReset(err)
+	if !ok {
+		return
+	}
+	log.Printf("Aborting, the GitHub API rate limit resets at %s: %v", reset.Format(time.RFC3339), err)
+	if err := setOutput("rate_limit_reset", reset.Format(time.RFC3339)); err != nil {
+		log.Printf("Error setting output: %v", err)
+	}
+	os.Exit(exitRateLimited)
+}
+
+// finishReport logs the run summary and writes the report file if requested.
+// Terminals get the changes rendered as colorized table, CI logs stay plain.
+func finishReport(args EnvArgs, report *Report) {
+	report.sortResults()
+	if colorEnabled(os.Stderr) {
+		report.Render(os.Stderr, true)
 	} else {
-		targetOwner, targetRepoName := parseRepoFullName(args.TargetRepo)
-		processRepository(ctx, args, apiClient, targetOwner, targetRepoName, secretsMap, variablesMap)
+		report.Log()
+	}
+	if args.ReportFile != "" {
+		if err := report.WriteJSON(args.ReportFile); err != nil {
+			log.Printf("Error writing report: %v", err)
+		}
+	}
+	if args.PlanOut != "" {
+		if err := report.WritePlan(args.PlanOut); err != nil {
+			log.Printf("Error writing plan: %v", err)
+		}
+	}
+	if args.Output == outputJSON {
+		if err := writeChanges(args, report); err != nil {
+			log.Printf("Error writing changes: %v", err)
+		}
+	}
+	if inGitHubActions() {
+		report.Annotate(os.Stdout)
+	}
+	if err := appendStepSummary(report.Markdown()); err != nil {
+		log.Printf("Error writing step summary: %v", err)
+	}
+	// Dashboards can track how much of the quota scheduled syncs consume.
+	if report.usage != nil {
+		if used, remaining, ok := report.usage.consumed(); ok {
+			if err := setOutput("rate_limit_used", strconv.Itoa(used)); err != nil {
+				log.Printf("Error setting output: %v", err)
+			}
+			if err := setOutput("rate_limit_remaining", strconv.Itoa(remaining)); err != nil {
+				log.Printf("Error setting output: %v", err)
+			}
+		}
+	}
+	outputs, err := report.Outputs()
+	if err != nil {
+		log.Printf("Error setting outputs: %v", err)
+	}
+	for _, name := range sortedKeys(outputs) {
+		if err := setOutput(name, outputs[name]); err != nil {
+			log.Printf("Error setting output: %v", err)
+		}
+	}
+	// Follow-up jobs, e.g. triggering redeploys, can target exactly the modified repositories.
+	modified, err := json.Marshal(report.ModifiedRepositories())
+	if err == nil {
+		err = setOutput("modified_repositories", string(modified))
+	}
+	if err != nil {
+		log.Printf("Error setting output: %v", err)
+	}
+}
+
+// writeChanges writes the changes of the report as JSON document to the output file. Without one it's set as the
+// changes output in GitHub Actions, where stdout carries workflow commands as well, and written to stdout elsewhere.
+func writeChanges(args EnvArgs, report *Report) error {
+	switch {
+	case args.OutputFile != "":
+		f, err := os.OpenFile(args.OutputFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to write changes to %s: %w", args.OutputFile, err)
+		}
+		defer f.Close()
+		return report.WriteChanges(f)
+	case inGitHubActions():
+		var buf bytes.Buffer
+		if err := report.WriteChanges(&buf); err != nil {
+			return err
+		}
+		return setOutput("changes", strings.TrimSuffix(buf.String(), "\n"))
+	default:
+		return report.WriteChanges(os.Stdout)
 	}
 }
 
 // processRepository handles the synchronization of secrets and variables for a single repository.
-func processRepository(ctx context.Context, args EnvArgs, apiClient GitHubActionClient, owner, repoName string, secretsMap, variablesMap map[string]string) {
-	log.Printf("Processing %s/%s\n", owner, repoName)
+func processRepository(ctx context.Context, args EnvArgs, apiClient GitHubActionClient, repo *github.Repository, secretsMap, variablesMap map[string]string) (result RepoResult, err error) {
+	owner, repoName := repo.GetOwner().GetLogin(), repo.GetName()
+	result = RepoResult{Repository: owner + "/" + repoName, Type: TargetType(args.Type), Environment: args.Environment}
+	logger := loggerFrom(ctx)
+	logger.Printf("Processing %s\n", result.Target())
+	keys := &keyRecorder{}
+	ctx = withKeyRecorder(ctx, keys)
+	ctx = withEmptyPrune(ctx, args, secretsMap, variablesMap)
+
+	// Each step syncs one kind of values, completed tracks whether any step already applied changes.
+	type step struct {
+		values    map[string]string
+		handle    func() error
+		variables bool
+	}
+	var steps []step
+	environmentCreated := false
 	switch TargetType(args.Type) {
 	case Actions:
 		if args.Environment == "" {
-			handleRepoSecrets(ctx, args, apiClient, owner, repoName, secretsMap)
-			handleRepoVariables(ctx, args, apiClient, owner, repoName, variablesMap)
+			steps = append(steps,
+				step{secretsMap, func() error { return handleRepoSecrets(ctx, args, apiClient, owner, repoName, secretsMap) }, false},
+				step{variablesMap, func() error { return handleRepoVariables(ctx, args, apiClient, owner, repoName, variablesMap) }, true},
+			)
 		} else {
-			handleEnvironmentSecrets(ctx, args, apiClient, owner, repoName, args.Environment, secretsMap)
-			handleEnvironmentVariables(ctx, args, apiClient, owner, repoName, args.Environment, variablesMap)
+			// Environments found by listing exist, checking each of them again would cost a request per environment.
+			listed := environmentListed(ctx)
+			if args.CreateEnvironment && !listed {
+				created, _, err := apiClient.CreateEnvironment(ctx, owner, repoName, args.Environment)
+				if err != nil {
+					result.Status = StatusFailed
+					result.Error = err.Error()
+					return result, fmt.Errorf("failed to create environment %s: %w", args.Environment, err)
+				}
+				if created && args.DryRun {
+					// Listing the values of an environment that doesn't exist yet fails, all of them would be created.
+					recordCreated(ctx, "secret", secretsMap)
+					recordCreated(ctx, "variable", variablesMap)
+					result.Status = StatusSynced
+					result.Keys = keys.Results()
+					return result, nil
+				}
+				if created {
+					logger.Printf("Created environment %s in %s/%s\n", args.Environment, owner, repoName)
+					ctx = withCreatedEnvironment(ctx)
+					environmentCreated = true
+				}
+			} else if !listed {
+				// The API answers requests to missing environments with 404s that don't tell what's missing.
+				_, _, err := apiClient.GetEnvironment(ctx, owner, repoName, args.Environment)
+				if isStatus(err, http.StatusNotFound) {
+					err = &missingEnvironmentError{Environment: args.Environment, Repository: owner + "/" + repoName, Err: err}
+					if args.SkipMissingEnv {
+						logger.Printf("Skipping %s: %v\n", result.Target(), err)
+						result.Status = StatusSkipped
+						result.Reason = "environment missing"
+						return result, nil
+					}
+				}
+				if err != nil {
+					result.Status = StatusFailed
+					result.Error = err.Error()
+					return result, err
+				}
+			}
+			// Secrets and variables of environments share the resolved repository, including on retries.
+			target, err := resolveEnvTarget(ctx, apiClient, repo)
+			if err != nil {
+				result.Status = StatusFailed
+				result.Error = err.Error()
+				return result, err
+			}
+			steps = append(steps,
+				step{secretsMap, func() error {
+					return handleEnvironmentSecrets(ctx, args, apiClient, target, args.Environment, secretsMap)
+				}, false},
+				step{variablesMap, func() error {
+					return handleEnvironmentVariables(ctx, args, apiClient, target, args.Environment, variablesMap)
+				}, true},
+			)
 		}
 	case Dependabot:
-		handleDependabotSecrets(ctx, args, apiClient, owner, repoName, secretsMap)
+		enabled, _, err := apiClient.DependabotAlertsEnabled(ctx, owner, repoName)
+		if err != nil {
+			result.Status = StatusFailed
+			result.Error = err.Error()
+			return result, fmt.Errorf("failed to check Dependabot status: %w", err)
+		}
+		if !enabled {
+			logger.Printf("Skipping %s/%s: Dependabot alerts are not enabled\n", owner, repoName)
+			result.Status = StatusSkipped
+			result.Reason = "dependabot not enabled"
+			return result, nil
+		}
+		steps = append(steps, step{secretsMap, func() error { return handleDependabotSecrets(ctx, args, apiClient, owner, repoName, secretsMap) }, false})
 	case Codespaces:
-		handleCodespacesSecrets(ctx, args, apiClient, owner, repoName, secretsMap)
+		steps = append(steps, step{secretsMap, func() error { return handleCodespacesSecrets(ctx, args, apiClient, owner, repoName, secretsMap) }, false})
 	default:
-		log.Fatalf("Unsupported target: %s", args.Type)
+		result.Status = StatusFailed
+		result.Error = fmt.Sprintf("unsupported target: %s", args.Type)
+		return result, errors.New(result.Error)
+	}
+
+	// A failing step doesn't stop the remaining ones, their errors are joined.
+	completed := false
+	var errs []error
+	for _, s := range steps {
+		if len(s.values) == 0 && !pruneEmpty(ctx, args, s.variables) {
+			continue
+		}
+		if err := s.handle(); err != nil {
+			if s.variables && variablesUnsupported(err, completed) {
+				logger.Printf("Warning: not syncing variables to %s, the variables API isn't available on this GitHub instance: %v\n", result.Target(), err)
+				continue
+			}
+			for _, keyErr := range keyErrors(err) {
+				logger.Printf("Failed to sync key %s in %s/%s: %v\n", keyErr.Key, owner, repoName, keyErr.Err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		completed = true
+	}
+	if len(errs) > 0 {
+		err = errors.Join(errs...)
+		// Failing keys don't stop the remaining keys of a step, and deletions aren't part of the values, so it's
+		// only a complete failure if neither a step nor a single key succeeded.
+		result.Status = StatusFailed
+		if completed || keys.succeeded() {
+			result.Status = StatusPartial
+		}
+		result.Error = err.Error()
+		result.Keys = keys.Results()
+		return result, err
 	}
 
-	log.Printf("Successfully processed values for %s/%s\n", owner, repoName)
+	// Variables with the desired values aren't written, so a completed step doesn't mean anything changed.
+	result.Keys = keys.Results()
+	result.Status = syncedStatus(result.Keys)
+	if environmentCreated {
+		result.Status = StatusSynced
+	}
+	logger.Printf("Successfully processed values for %s/%s\n", owner, repoName)
+	return result, nil
 }
 
-func handleRepoSecrets(ctx context.Context, args EnvArgs, client GitHubActionClient, owner, repo string, secrets map[string]string) {
-	if len(secrets) == 0 {
-		return
+// recordCreated records the keys of values as created, except those marked for deletion.
+func recordCreated(ctx context.Context, kind string, values map[string]string) {
+	for _, name := range sortedKeys(values) {
+		if values[name] != deleteSentinel {
+			recordKey(ctx, KeyResult{Kind: kind, Name: name, Outcome: KeyCreated})
+		}
+	}
+}
+
+func handleRepoSecrets(ctx context.Context, args EnvArgs, client GitHubActionClient, owner, repo string, secrets map[string]string) error {
+	if len(secrets) == 0 && !pruneEmpty(ctx, args, false) {
+		return nil
 	}
-	if args.Prune {
+	ctx, secrets, prune := pruneContext(ctx, args, secrets)
+	if prune {
 		err := client.SyncRepoSecrets(ctx, owner, repo, secrets)
 		if err != nil {
-			log.Fatalf("Failed to sync repository secrets: %v", err)
+			return fmt.Errorf("failed to sync repository secrets: %w", err)
 		}
 	} else {
 		err := client.PutRepoSecrets(ctx, owner, repo, secrets)
 		if err != nil {
-			log.Fatalf("Failed to put repository secrets: %v", err)
+			return fmt.Errorf("failed to put repository secrets: %w", err)
 		}
 	}
-	log.Println("Repository secrets processed successfully.")
+	loggerFrom(ctx).Printf("Repository secrets processed successfully.")
+	return nil
 }
 
-func handleRepoVariables(ctx context.Context, args EnvArgs, client GitHubActionClient, owner, repo string, variables map[string]string) {
-	if len(variables) == 0 {
-		return
+// variablesUnsupported reports whether syncing variables failed because the GitHub instance lacks the variables API,
+// as GitHub Enterprise Server before 3.8 does. Unknown endpoints answer with 404 like inaccessible repositories,
+// so a 404 only counts if verified is set because secrets were already synced to the same target.
+func variablesUnsupported(err error, verified bool) bool {
+	if len(keyErrors(err)) > 0 {
+		return false
+	}
+	return isStatus(err, http.StatusGone) || (verified && isStatus(err, http.StatusNotFound))
+}
+
+func handleRepoVariables(ctx context.Context, args EnvArgs, client GitHubActionClient, owner, repo string, variables map[string]string) error {
+	if len(variables) == 0 && !pruneEmpty(ctx, args, true) {
+		return nil
 	}
-	if args.Prune {
+	ctx, variables, prune := pruneContext(ctx, args, variables)
+	if prune {
 		err := client.SyncRepoVariables(ctx, owner, repo, variables)
 		if err != nil {
-			log.Fatalf("Failed to sync repository secrets: %v", err)
+			return fmt.Errorf("failed to sync repository variables: %w", err)
 		}
 	} else {
 		err := client.PutRepoVariables(ctx, owner, repo, variables)
 		if err != nil {
-			log.Fatalf("Failed to put repository secrets: %v", err)
+			return fmt.Errorf("failed to put repository variables: %w", err)
 		}
 	}
-	log.Println("Repository variables processed successfully.")
+	loggerFrom(ctx).Printf("Repository variables processed successfully.")
+	return nil
 }
 
-func handleEnvironmentSecrets(ctx context.Context, args EnvArgs, client GitHubActionClient, owner, repo, environment string, secrets map[string]string) {
-	if len(secrets) == 0 {
-		return
+func handleEnvironmentSecrets(ctx context.Context, args EnvArgs, client GitHubActionClient, target envTarget, environment string, secrets map[string]string) error {
+	if len(secrets) == 0 && !pruneEmpty(ctx, args, false) {
+		return nil
 	}
-	if args.Prune {
-		err := client.SyncEnvSecrets(ctx, owner, repo, environment, secrets)
+	ctx, secrets, prune := pruneContext(ctx, args, secrets)
+	if prune {
+		err := client.SyncEnvSecrets(ctx, target, environment, secrets)
 		if err != nil {
-			log.Fatalf("Failed to sync environment secrets: %v", err)
+			return fmt.Errorf("failed to sync environment secrets: %w", err)
 		}
 	} else {
-		err := client.PutEnvSecrets(ctx, owner, repo, environment, secrets)
+		err := client.PutEnvSecrets(ctx, target, environment, secrets)
 		if err != nil {
-			log.Fatalf("Failed to put environment secrets: %v", err)
+			return fmt.Errorf("failed to put environment secrets: %w", err)
 		}
 	}
-	log.Println("Environment secrets processed successfully.")
+	loggerFrom(ctx).Printf("Environment secrets processed successfully.")
+	return nil
 }
 
-func handleEnvironmentVariables(ctx context.Context, args EnvArgs, client GitHubActionClient, owner, repo, environment string, variables map[string]string) {
-	if len(variables) == 0 {
-		return
+func handleEnvironmentVariables(ctx context.Context, args EnvArgs, client GitHubActionClient, target envTarget, environment string, variables map[string]string) error {
+	if len(variables) == 0 && !pruneEmpty(ctx, args, true) {
+		return nil
 	}
-	if args.Prune {
-		err := client.SyncEnvVariables(ctx, owner, repo, environment, variables)
+	ctx, variables, prune := pruneContext(ctx, args, variables)
+	if prune {
+		err := client.SyncEnvVariables(ctx, target, environment, variables)
 		if err != nil {
-			log.Fatalf("Failed to sync environment variables: %v", err)
+			return fmt.Errorf("failed to sync environment variables: %w", err)
 		}
 	} else {
-		err := client.PutEnvVariables(ctx, owner, repo, environment, variables)
+		err := client.PutEnvVariables(ctx, target, environment, variables)
 		if err != nil {
-			log.Fatalf("Failed to put environment variables: %v", err)
+			return fmt.Errorf("failed to put environment variables: %w", err)
 		}
 	}
-	log.Println("Environment variables processed successfully.")
+	loggerFrom(ctx).Printf("Environment variables processed successfully.")
+	return nil
 }
 
-func handleDependabotSecrets(ctx context.Context, args EnvArgs, client GitHubActionClient, owner, repo string, secrets map[string]string) {
-	if len(secrets) == 0 {
-		return
+func handleDependabotSecrets(ctx context.Context, args EnvArgs, client GitHubActionClient, owner, repo string, secrets map[string]string) error {
+	if len(secrets) == 0 && !pruneEmpty(ctx, args, false) {
+		return nil
 	}
-	if args.Prune {
+	ctx, secrets, prune := pruneContext(ctx, args, secrets)
+	if prune {
 		err := client.SyncDependabotSecrets(ctx, owner, repo, secrets)
 		if err != nil {
-			log.Fatalf("Failed to sync Dependabot secrets: %v", err)
+			return fmt.Errorf("failed to sync Dependabot secrets: %w", err)
 		}
 	} else {
 		err := client.PutDependabotSecrets(ctx, owner, repo, secrets)
 		if err != nil {
-			log.Fatalf("Failed to put Dependabot secrets: %v", err)
+			return fmt.Errorf("failed to put Dependabot secrets: %w", err)
 		}
 	}
-	log.Println("Dependabot secrets processed successfully.")
+	loggerFrom(ctx).Printf("Dependabot secrets processed successfully.")
+	return nil
 }
 
-func handleCodespacesSecrets(ctx context.Context, args EnvArgs, client GitHubActionClient, owner, repo string, secrets map[string]string) {
-	if len(secrets) == 0 {
-		return
+func handleCodespacesSecrets(ctx context.Context, args EnvArgs, client GitHubActionClient, owner, repo string, secrets map[string]string) error {
+	if len(secrets) == 0 && !pruneEmpty(ctx, args, false) {
+		return nil
 	}
-	if args.Prune {
+	ctx, secrets, prune := pruneContext(ctx, args, secrets)
+	if prune {
 		err := client.SyncCodespacesSecrets(ctx, owner, repo, secrets)
 		if err != nil {
-			log.Fatalf("Failed to sync Codespaces secrets: %v", err)
+			return fmt.Errorf("failed to sync Codespaces secrets: %w", err)
 		}
 	} else {
 		err := client.PutCodespacesSecrets(ctx, owner, repo, secrets)
 		if err != nil {
-			log.Fatalf("Failed to put Codespaces secrets: %v", err)
+			return fmt.Errorf("failed to put Codespaces secrets: %w", err)
 		}
 	}
-	log.Println("Codespaces secrets processed successfully.")
+	loggerFrom(ctx).Printf("Codespaces secrets processed successfully.")
+	return nil
 }
 
-func parseKeyValuePairs(secretsRaw string) (map[string]string, error) {
+// parseOptions controls how key=value input is parsed.
+type parseOptions struct {
+	// skipEmpty ignores keys with empty values instead of failing.
+	skipEmpty bool
+	// raw keeps byte order marks and carriage returns instead of normalizing them away, see normalizeInput.
+	raw bool
+}
+
+// newParseOptions returns the parse options configured by the command-line arguments.
+func newParseOptions(args EnvArgs) parseOptions {
+	return parseOptions{skipEmpty: args.SkipEmpty, raw: args.RawInput}
+}
+
+// byteOrderMark is prepended to files by some Windows editors and ends up in keys and values if not removed.
+const byteOrderMark = "\ufeff"
+
+// normalizeInput converts CRLF and lone CR line endings to LF and removes byte order marks at the start of lines,
+// as left by inputs generated on Windows runners or pasted from editors.
+func normalizeInput(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimLeft(line, byteOrderMark)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parseKeyValuePairs(secretsRaw string, opts parseOptions) (map[string]string, error) {
 	secrets := make(map[string]string)
 
+	if !opts.raw {
+		secretsRaw = normalizeInput(secretsRaw)
+	}
 	if secretsRaw == "" {
 		return secrets, nil
 	}
@@ -236,14 +1161,21 @@ func parseKeyValuePairs(secretsRaw string) (map[string]string, error) {
 	lines := strings.Split(secretsRaw, "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
-		if line == "" {
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 		parts := strings.SplitN(line, "=", 2)
 		if len(parts) != 2 {
 			return nil, fmt.Errorf("malformed secret, does not contain a key=value pair: %s", line)
 		}
-		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		key := strings.TrimSpace(parts[0])
+		value, err := parseValue(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed secret, %v: %s", err, line)
+		}
+		if key != "" && value == "" && opts.skipEmpty {
+			continue
+		}
 		if key == "" || value == "" {
 			return nil, fmt.Errorf("malformed secret, key or value is empty: %s", line)
 		}
@@ -252,6 +1184,75 @@ func parseKeyValuePairs(secretsRaw string) (map[string]string, error) {
 	return secrets, nil
 }
 
+// parseValue returns the value with surrounding quotes and trailing comments removed.
+// Double-quoted values support the escapes \n, \r, \t, \", and \\, single-quoted values are taken literally.
+// A trailing comment starts with a # preceded by whitespace, so unquoted values like pass#word stay intact.
+func parseValue(raw string) (string, error) {
+	value := strings.TrimSpace(raw)
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		return stripTrailingComment(raw), nil
+	}
+
+	quote := value[0]
+	var b strings.Builder
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == quote:
+			if rest := strings.TrimSpace(value[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return "", fmt.Errorf("unexpected characters after closing quote")
+			}
+			return b.String(), nil
+		case c == '\\' && quote == '"' && i+1 < len(value):
+			i++
+			switch value[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(value[i])
+			default:
+				return "", fmt.Errorf("unsupported escape sequence \\%c", value[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("missing closing quote")
+}
+
+// checkExpectedKeys verifies that every expected key is present in at least one of the parsed inputs.
+func checkExpectedKeys(expected []string, inputs ...map[string]string) error {
+	present := make(map[string]bool)
+	for _, input := range inputs {
+		for key := range input {
+			present[key] = true
+		}
+	}
+
+	var upper []string
+	for _, key := range expected {
+		upper = append(upper, strings.ToUpper(key))
+	}
+	if missing := missingKeys(upper, present); len(missing) > 0 {
+		return fmt.Errorf("expected keys missing from input: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// stripTrailingComment removes a comment introduced by whitespace followed by # and trims the remainder.
+func stripTrailingComment(value string) string {
+	for i := 1; i < len(value); i++ {
+		if value[i] == '#' && (value[i-1] == ' ' || value[i-1] == '\t') {
+			return strings.TrimSpace(value[:i])
+		}
+	}
+	return strings.TrimSpace(value)
+}
+
 func parseRepoFullName(fullName string) (owner, repo string) {
 	parts := strings.SplitN(fullName, "/", 2)
 	if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
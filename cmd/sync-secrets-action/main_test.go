@@ -1,14 +1,42 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+	"github.com/google/go-github/v68/github"
+	"golang.org/x/crypto/nacl/box"
 )
 
 func TestParseSecrets(t *testing.T) {
 	testCases := []struct {
 		name        string
 		secretsRaw  string
+		opts        parseOptions
 		expected    map[string]string
 		expectError bool
 	}{
@@ -36,6 +64,20 @@ func TestParseSecrets(t *testing.T) {
 			expected:    nil,
 			expectError: true,
 		},
+		{
+			name:        "Missing value with skip empty",
+			secretsRaw:  "SECRET1=\nSECRET2=value2\nSECRET3=\"\"",
+			opts:        parseOptions{skipEmpty: true},
+			expected:    map[string]string{"SECRET2": "value2"},
+			expectError: false,
+		},
+		{
+			name:        "Missing key with skip empty",
+			secretsRaw:  "=value1",
+			opts:        parseOptions{skipEmpty: true},
+			expected:    nil,
+			expectError: true,
+		},
 		{
 			name:        "Missing key",
 			secretsRaw:  "=value1",
@@ -54,11 +96,96 @@ func TestParseSecrets(t *testing.T) {
 			expected:    map[string]string{"SECRET1": "value1=value2"},
 			expectError: false,
 		},
+		{
+			name:        "Double-quoted value",
+			secretsRaw:  `SECRET1="  value with = and # and leading space"`,
+			expected:    map[string]string{"SECRET1": "  value with = and # and leading space"},
+			expectError: false,
+		},
+		{
+			name:        "Escape sequences in double-quoted value",
+			secretsRaw:  `SECRET1="line1\nline2\t\"quoted\" \\"`,
+			expected:    map[string]string{"SECRET1": "line1\nline2\t\"quoted\" \\"},
+			expectError: false,
+		},
+		{
+			name:        "Single-quoted value is literal",
+			secretsRaw:  `SECRET1='no\nescape '`,
+			expected:    map[string]string{"SECRET1": `no\nescape `},
+			expectError: false,
+		},
+		{
+			name:        "Unterminated quote",
+			secretsRaw:  `SECRET1="value`,
+			expected:    nil,
+			expectError: true,
+		},
+		{
+			name:        "Characters after closing quote",
+			secretsRaw:  `SECRET1="value" trailing`,
+			expected:    nil,
+			expectError: true,
+		},
+		{
+			name:        "Comment lines",
+			secretsRaw:  "# shared credentials\nSECRET1=value1\n  # indented comment",
+			expected:    map[string]string{"SECRET1": "value1"},
+			expectError: false,
+		},
+		{
+			name:        "Trailing comments",
+			secretsRaw:  "SECRET1=value1 # comment\nSECRET2=\"value # kept\" # comment",
+			expected:    map[string]string{"SECRET1": "value1", "SECRET2": "value # kept"},
+			expectError: false,
+		},
+		{
+			name:        "Hash without preceding whitespace is part of the value",
+			secretsRaw:  "SECRET1=pass#word",
+			expected:    map[string]string{"SECRET1": "pass#word"},
+			expectError: false,
+		},
+		{
+			name:        "Value consisting of a comment only",
+			secretsRaw:  "SECRET1= # comment",
+			expected:    nil,
+			expectError: true,
+		},
+		{
+			name:        "Unsupported escape sequence",
+			secretsRaw:  `SECRET1="\x"`,
+			expected:    nil,
+			expectError: true,
+		},
+		{
+			name:        "CRLF line endings",
+			secretsRaw:  "SECRET1=value1\r\nSECRET2=\"value2\"\r\n",
+			expected:    map[string]string{"SECRET1": "value1", "SECRET2": "value2"},
+			expectError: false,
+		},
+		{
+			name:        "Lone CR line endings",
+			secretsRaw:  "SECRET1=value1\rSECRET2=value2",
+			expected:    map[string]string{"SECRET1": "value1", "SECRET2": "value2"},
+			expectError: false,
+		},
+		{
+			name:        "Byte order marks",
+			secretsRaw:  "\ufeffSECRET1=value1\n\ufeffSECRET2=value2",
+			expected:    map[string]string{"SECRET1": "value1", "SECRET2": "value2"},
+			expectError: false,
+		},
+		{
+			name:        "Raw input keeps byte order marks and lone CRs",
+			secretsRaw:  "\ufeffSECRET1=value1\rSECRET2=value2",
+			opts:        parseOptions{raw: true},
+			expected:    map[string]string{"\ufeffSECRET1": "value1\rSECRET2=value2"},
+			expectError: false,
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			result, err := parseKeyValuePairs(tc.secretsRaw)
+			result, err := parseKeyValuePairs(tc.secretsRaw, tc.opts)
 			if (err != nil) != tc.expectError {
 				t.Fatalf("Expected error: %v, got: %v", tc.expectError, err)
 			}
@@ -68,3 +195,3520 @@ func TestParseSecrets(t *testing.T) {
 		})
 	}
 }
+
+func TestSortRepositories(t *testing.T) {
+	newRepo := func(name string, pushed, created int) *github.Repository {
+		return &github.Repository{
+			FullName:  github.Ptr(name),
+			PushedAt:  &github.Timestamp{Time: time.Unix(int64(pushed), 0)},
+			CreatedAt: &github.Timestamp{Time: time.Unix(int64(created), 0)},
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		order    RepoOrder
+		expected []string
+	}{
+		{
+			name:     "Alpha",
+			order:    OrderAlpha,
+			expected: []string{"org/a", "org/b", "org/c"},
+		},
+		{
+			name:     "Least recently pushed first",
+			order:    OrderPushed,
+			expected: []string{"org/b", "org/c", "org/a"},
+		},
+		{
+			name:     "Oldest first",
+			order:    OrderCreated,
+			expected: []string{"org/c", "org/a", "org/b"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repos := []*github.Repository{
+				newRepo("org/c", 20, 1),
+				newRepo("org/a", 30, 2),
+				newRepo("org/b", 10, 3),
+			}
+			sortRepositories(repos, tc.order)
+
+			var result []string
+			for _, repo := range repos {
+				result = append(result, repo.GetFullName())
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected order: %v, got: %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestIsPermissionError(t *testing.T) {
+	newErrorResponse := func(status int, path string) error {
+		return &github.ErrorResponse{Response: &http.Response{StatusCode: status, Request: &http.Request{URL: &url.URL{Path: path}}}}
+	}
+
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "Forbidden",
+			err:      newErrorResponse(http.StatusForbidden, "/repos/example/service/actions/secrets"),
+			expected: true,
+		},
+		{
+			name:     "Wrapped not found",
+			err:      fmt.Errorf("failed to list secrets: %w", newErrorResponse(http.StatusNotFound, "/repos/example/service/actions/secrets")),
+			expected: true,
+		},
+		{
+			name:     "Not found listing environments on GHES",
+			err:      newErrorResponse(http.StatusNotFound, "/api/v3/repositories/42/environments"),
+			expected: true,
+		},
+		{
+			name:     "Environment not found",
+			err:      newErrorResponse(http.StatusNotFound, "/repos/example/service/environments/production"),
+			expected: false,
+		},
+		{
+			name:     "Missing environment",
+			err:      &missingEnvironmentError{Environment: "production", Repository: "example/service", Err: newErrorResponse(http.StatusNotFound, "/repos/example/service/environments/production")},
+			expected: false,
+		},
+		{
+			name:     "Not found outside of repositories",
+			err:      newErrorResponse(http.StatusNotFound, "/orgs/example/codespaces/secrets"),
+			expected: false,
+		},
+		{
+			name:     "Unprocessable entity",
+			err:      newErrorResponse(http.StatusUnprocessableEntity, "/repos/example/service/actions/variables"),
+			expected: false,
+		},
+		{
+			name:     "Rate limited",
+			err:      &github.RateLimitError{Response: &http.Response{StatusCode: http.StatusForbidden}},
+			expected: false,
+		},
+		{
+			name:     "Plain error",
+			err:      errors.New("boom"),
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := isPermissionError(tc.err); result != tc.expected {
+				t.Errorf("Expected: %v, got: %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestDiffVariables(t *testing.T) {
+	left := map[string]string{"SAME": "1", "CHANGED": "a", "REMOVED": "x"}
+	right := map[string]string{"SAME": "1", "CHANGED": "b", "ADDED": "y"}
+
+	expected := []variableDiff{
+		{Key: "ADDED", Right: github.Ptr("y")},
+		{Key: "CHANGED", Left: github.Ptr("a"), Right: github.Ptr("b")},
+		{Key: "REMOVED", Left: github.Ptr("x")},
+	}
+
+	result := diffVariables(left, right)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected diff: %+v, got: %+v", expected, result)
+	}
+}
+
+func TestMissingKeys(t *testing.T) {
+	existing := map[string]bool{"DOCKER_USER": true, "OTHER": true}
+
+	result := missingKeys([]string{"DOCKER_USER", "DOCKER_PASS", "NPM_TOKEN"}, existing)
+	expected := []string{"DOCKER_PASS", "NPM_TOKEN"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected missing keys: %v, got: %v", expected, result)
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	testCases := []struct {
+		age         string
+		expected    time.Duration
+		expectError bool
+	}{
+		{age: "90d", expected: 90 * 24 * time.Hour},
+		{age: "36h", expected: 36 * time.Hour},
+		{age: "d", expectError: true},
+		{age: "-1d", expectError: true},
+		{age: "soon", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.age, func(t *testing.T) {
+			result, err := parseAge(tc.age)
+			if (err != nil) != tc.expectError {
+				t.Fatalf("Expected error: %v, got: %v", tc.expectError, err)
+			}
+			if err == nil && result != tc.expected {
+				t.Errorf("Expected age: %v, got: %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestCheckExpectedKeys(t *testing.T) {
+	secrets := map[string]string{"SECRET1": "value1"}
+	variables := map[string]string{"VAR1": "value1"}
+
+	if err := checkExpectedKeys([]string{"secret1", "VAR1"}, secrets, variables); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if err := checkExpectedKeys([]string{"SECRET1", "SECRET2"}, secrets, variables); err == nil {
+		t.Error("Expected error for missing key, got nil")
+	}
+}
+
+func TestLintValue(t *testing.T) {
+	testCases := []struct {
+		name      string
+		value     string
+		templated bool
+		expected  []string
+	}{
+		{name: "Clean value", value: "value", expected: nil},
+		{name: "Trailing newline", value: "value\n", expected: []string{"trailing newline"}},
+		{name: "CRLF", value: "line1\r\nline2\r\n", expected: []string{"trailing newline", "CRLF line endings"}},
+		{name: "Surrounding quotes", value: `"value"`, expected: []string{"surrounding quotes"}},
+		{name: "Single quote only", value: `'`, expected: nil},
+		{name: "Filler word", value: "CHANGEME", expected: []string{"placeholder value"}},
+		{name: "Filler word in sentence", value: "todo list", expected: nil},
+		{name: "Angle-bracketed hint", value: "<insert-token>", expected: []string{"placeholder value"}},
+		{name: "Unrendered expression", value: "${{ secrets.TOKEN }}", expected: []string{"placeholder value"}},
+		{name: "Template expression", value: "{{ randAlphaNum 32 }}", templated: true, expected: nil},
+		{name: "Filler word in template", value: "TODO", templated: true, expected: []string{"placeholder value"}},
+		{name: "Blank value", value: "  ", expected: []string{"blank value"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := lintValue(tc.value, tc.templated); !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected problems: %v, got: %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestKeyErrors(t *testing.T) {
+	errA := &KeyError{Key: "A", Err: errors.New("failed")}
+	errB := &KeyError{Key: "B", Err: errors.New("failed")}
+
+	testCases := []struct {
+		name     string
+		err      error
+		expected []*KeyError
+	}{
+		{name: "No error", err: nil, expected: nil},
+		{name: "Other error", err: errors.New("failed"), expected: nil},
+		{name: "Single key error", err: errA, expected: []*KeyError{errA}},
+		{name: "Joined and wrapped", err: fmt.Errorf("sync: %w", errors.Join(errA, errors.New("other"), errors.Join(errB))), expected: []*KeyError{errA, errB}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := keyErrors(tc.err); !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected key errors: %v, got: %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestKeyRecorder(t *testing.T) {
+	rec := &keyRecorder{}
+	ctx := withKeyRecorder(context.Background(), rec)
+
+	recordKey(ctx, KeyResult{Kind: "variable", Name: "B", Outcome: KeyCreated})
+	_ = failKey(ctx, "secret", "A", errors.New("failed"))
+	// A retried sync replaces the earlier result of the same key.
+	recordKey(ctx, KeyResult{Kind: "secret", Name: "A", Outcome: KeyUpdated})
+	recordKey(ctx, KeyResult{Kind: "secret", Name: "C", Outcome: KeyDeleted})
+	// Contexts without recorder are ignored.
+	recordKey(context.Background(), KeyResult{Kind: "secret", Name: "D", Outcome: KeyCreated})
+
+	expected := []KeyResult{
+		{Kind: "secret", Name: "A", Outcome: KeyUpdated},
+		{Kind: "secret", Name: "C", Outcome: KeyDeleted},
+		{Kind: "variable", Name: "B", Outcome: KeyCreated},
+	}
+	if result := rec.Results(); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected results: %v, got: %v", expected, result)
+	}
+}
+
+func TestSkipRepositories(t *testing.T) {
+	skip, err := loadSkipList("example/Frozen, other/opted-out\n", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	repos := []*github.Repository{
+		newRepository("example", "a"),
+		newRepository("example", "frozen"),
+		newRepository("other", "opted-out"),
+		newRepository("example", "b"),
+	}
+	all := func(yield func(*github.Repository, error) bool) {
+		for _, repo := range repos {
+			if !yield(repo, nil) {
+				return
+			}
+		}
+	}
+	var out strings.Builder
+	ctx := withLogger(context.Background(), log.New(&out, "", 0))
+	var result []string
+	for repo, err := range skipRepositories(ctx, all, skip) {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		result = append(result, repo.GetFullName())
+	}
+	expected := []string{"example/a", "example/b"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected repositories: %v, got: %v", expected, result)
+	}
+	if !strings.Contains(out.String(), "Skipping other/opted-out: listed in skip-repos") {
+		t.Errorf("Expected the skipped repository in the injected logger, got:\n%s", out.String())
+	}
+
+	if _, err := loadSkipList("example", ""); err == nil {
+		t.Error("Expected error for invalid repository, got nil")
+	}
+}
+
+// searchClient is a GitHubActionClient that answers searches from a fixed set of results per query.
+type searchClient struct {
+	GitHubActionClient
+	results map[string][]*github.Repository
+	files   map[string]bool
+}
+
+func (c *searchClient) HasFile(_ context.Context, owner, repo, path string) (bool, error) {
+	return c.files[owner+"/"+repo+"/"+path], nil
+}
+
+func (c *searchClient) SearchRepositories(_ context.Context, query string) ([]*github.Repository, error) {
+	return c.results[query], nil
+}
+
+func (c *searchClient) SearchRepositoriesPage(_ context.Context, query string, _ *github.SearchOptions) (*github.RepositoriesSearchResult, *github.Response, error) {
+	return &github.RepositoriesSearchResult{Repositories: c.results[query]}, &github.Response{}, nil
+}
+
+// envClient is a GitHubActionClient that records the targets of environment writes.
+type envClient struct {
+	GitHubActionClient
+	lookups int
+	targets []envTarget
+}
+
+func (c *envClient) GetRepository(_ context.Context, owner, repo string) (*github.Repository, *github.Response, error) {
+	c.lookups++
+	return &github.Repository{ID: github.Ptr(int64(42)), Name: github.Ptr(repo), Owner: &github.User{Login: github.Ptr(owner)}}, &github.Response{}, nil
+}
+
+func (c *envClient) GetEnvironment(_ context.Context, _, _, envName string) (*github.Environment, *github.Response, error) {
+	return &github.Environment{Name: github.Ptr(envName)}, &github.Response{}, nil
+}
+
+func (c *envClient) PutEnvSecrets(_ context.Context, target envTarget, _ string, _ map[string]string) error {
+	c.targets = append(c.targets, target)
+	return nil
+}
+
+func (c *envClient) PutEnvVariables(_ context.Context, target envTarget, _ string, _ map[string]string) error {
+	c.targets = append(c.targets, target)
+	return nil
+}
+
+func TestProcessRepositoryResolvesEnvTargetOnce(t *testing.T) {
+	client := &envClient{}
+	repo := newRepository("example", "service")
+	secrets := map[string]string{"TOKEN": "secret"}
+	variables := map[string]string{"HOST": "example.com"}
+
+	for _, env := range []string{"staging", "production"} {
+		args := EnvArgs{Type: string(Actions), Environment: env}
+		if _, err := processRepository(context.Background(), args, client, repo, secrets, variables); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if client.lookups != 1 {
+		t.Errorf("Expected 1 repository lookup, got %d", client.lookups)
+	}
+	expected := envTarget{RepoID: 42, Owner: "example", Repo: "service"}
+	if len(client.targets) != 4 {
+		t.Fatalf("Expected 4 environment writes, got %d", len(client.targets))
+	}
+	for _, target := range client.targets {
+		if target != expected {
+			t.Errorf("Expected target %+v, got %+v", expected, target)
+		}
+	}
+}
+
+func TestResolveRepositoriesMultipleQueries(t *testing.T) {
+	client := &searchClient{results: map[string][]*github.Repository{
+		"team:a":         {newRepository("example", "b"), newRepository("example", "a")},
+		"legacy in:name": {newRepository("Example", "A"), newRepository("example", "legacy")},
+	}}
+
+	testCases := []struct {
+		order    string
+		expected []string
+	}{
+		{order: "alpha", expected: []string{"example/a", "example/b", "example/legacy"}},
+		{order: "search", expected: []string{"example/b", "example/a", "example/legacy"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.order, func(t *testing.T) {
+			args := EnvArgs{Query: "team:a\n\n  legacy in:name  \n", Order: tc.order}
+			repos, err := resolveRepositories(context.Background(), args, client)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var result []string
+			for repo, err := range repos {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				result = append(result, repo.GetFullName())
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected repositories: %v, got: %v", tc.expected, result)
+			}
+		})
+	}
+
+	if _, err := resolveRepositories(context.Background(), EnvArgs{Query: " \n\t\n"}, client); err == nil || err.Error() != "query is empty" {
+		t.Errorf("Expected an empty query error, got: %v", err)
+	}
+}
+
+func TestResolveRepositoriesFilters(t *testing.T) {
+	client := &searchClient{
+		results: map[string][]*github.Repository{
+			"org:example":                {newRepository("example", "a"), newRepository("example", "external"), newRepository("example", "b")},
+			"org:example topic:external": {newRepository("example", "External")},
+			"service":                    {newRepository("example", "service-a"), newRepository("example", "docs"), newRepository("example", "service-b")},
+		},
+		files: map[string]bool{"example/a/.github/workflows": true, "example/external/.github/workflows": true},
+	}
+
+	testCases := []struct {
+		name        string
+		args        EnvArgs
+		expected    []string
+		expectError bool
+	}{
+		{
+			name:     "Exclude query",
+			args:     EnvArgs{Query: "org:example", ExcludeQuery: "org:example topic:external"},
+			expected: []string{"example/a", "example/b"},
+		},
+		{
+			name:     "Name regex",
+			args:     EnvArgs{Query: "service", NameRegex: "^example/service-"},
+			expected: []string{"example/service-a", "example/service-b"},
+		},
+		{
+			name:        "Invalid name regex",
+			args:        EnvArgs{Query: "service", NameRegex: "("},
+			expectError: true,
+		},
+		{
+			name:     "Require file",
+			args:     EnvArgs{Query: "org:example", RequireFile: "/.github/workflows/"},
+			expected: []string{"example/a", "example/external"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.args.Order = "alpha"
+			repos, err := resolveRepositories(context.Background(), tc.args, client)
+			if tc.expectError {
+				if err == nil {
+					t.Error("Expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var result []string
+			for repo, err := range repos {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				result = append(result, repo.GetFullName())
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected repositories: %v, got: %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestResolveRepositoriesRepos(t *testing.T) {
+	client := &searchClient{}
+
+	args := EnvArgs{Repos: "example/service-b,\nexample/service-a, example/service-b", SkipRepos: "example/docs", Order: "alpha"}
+	repos, err := resolveRepositories(context.Background(), args, client)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var result []string
+	for repo, err := range repos {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		result = append(result, repo.GetFullName())
+	}
+	expected := []string{"example/service-b", "example/service-a"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected repositories: %v, got: %v", expected, result)
+	}
+
+	for _, invalid := range []EnvArgs{
+		{Repos: "example/service,example", Order: "alpha"},
+		{Repos: "example/service", TargetRepo: "example/docs", Order: "alpha"},
+	} {
+		if _, err := resolveRepositories(context.Background(), invalid, client); err == nil {
+			t.Errorf("Expected error for %+v, got nil", invalid)
+		}
+	}
+}
+
+func TestResolveRepositoriesReposFile(t *testing.T) {
+	client := &searchClient{}
+	path := filepath.Join(t.TempDir(), "repos.txt")
+	if err := os.WriteFile(path, []byte("# services\nexample/service-a\n\n  example/service-c  \r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	args := EnvArgs{Repos: "example/service-b", ReposFile: path, Order: "alpha"}
+	repos, err := resolveRepositories(context.Background(), args, client)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var result []string
+	for repo, err := range repos {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		result = append(result, repo.GetFullName())
+	}
+	expected := []string{"example/service-b", "example/service-a", "example/service-c"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected repositories: %v, got: %v", expected, result)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.txt")
+	if err := os.WriteFile(empty, []byte("# nothing yet\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, invalid := range []EnvArgs{
+		{ReposFile: filepath.Join(t.TempDir(), "missing.txt"), Order: "alpha"},
+		{ReposFile: empty, Order: "alpha"},
+		{ReposFile: path, Query: "org:example", Order: "alpha"},
+	} {
+		if _, err := resolveRepositories(context.Background(), invalid, client); err == nil {
+			t.Errorf("Expected error for %+v, got nil", invalid)
+		}
+	}
+}
+
+func TestMatrixTargets(t *testing.T) {
+	testCases := []struct {
+		name         string
+		types        string
+		environments string
+		expected     []syncTarget
+		expectError  bool
+	}{
+		{name: "Defaults to actions", expected: []syncTarget{{Type: Actions}}},
+		{
+			name:         "Environments of actions",
+			types:        "actions",
+			environments: "staging, production",
+			expected:     []syncTarget{{Type: Actions, Environment: "staging"}, {Type: Actions, Environment: "production"}},
+		},
+		{
+			name:         "Environments only apply to actions",
+			types:        "actions,dependabot,actions",
+			environments: "staging\nproduction",
+			expected:     []syncTarget{{Type: Actions, Environment: "staging"}, {Type: Actions, Environment: "production"}, {Type: Dependabot}},
+		},
+		{name: "Unsupported type", types: "actions,pages", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := matrixTargets(tc.types, tc.environments)
+			if tc.expectError {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected targets: %v, got: %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestEnvironmentTargets(t *testing.T) {
+	patterns, err := parseNamePatterns("prod-*, /^staging$/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	names := []string{"prod-eu", "Prod-US", "staging", "staging-2", "development"}
+	testCases := []struct {
+		name     string
+		targets  []syncTarget
+		names    []string
+		expected []syncTarget
+	}{
+		{
+			name:     "Replaces the repository",
+			targets:  []syncTarget{{Type: Actions}},
+			names:    names,
+			expected: []syncTarget{{Type: Actions, Environment: "prod-eu"}, {Type: Actions, Environment: "Prod-US"}, {Type: Actions, Environment: "staging"}},
+		},
+		{
+			name:     "Keeps listed environments and other types",
+			targets:  []syncTarget{{Type: Actions, Environment: "development"}, {Type: Actions, Environment: "PROD-EU"}, {Type: Dependabot}},
+			names:    names,
+			expected: []syncTarget{{Type: Actions, Environment: "development"}, {Type: Actions, Environment: "PROD-EU"}, {Type: Dependabot}, {Type: Actions, Environment: "Prod-US"}, {Type: Actions, Environment: "staging"}},
+		},
+		{name: "Without environments", targets: []syncTarget{{Type: Actions}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := environmentTargets(tc.targets, tc.names, patterns); !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected targets: %v, got: %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+// listEnvironmentsClient fails to list the environments of a repository with err.
+type listEnvironmentsClient struct {
+	GitHubActionClient
+	err error
+}
+
+func (c *listEnvironmentsClient) ListEnvironments(context.Context, string, string, *github.EnvironmentListOptions) (*github.EnvResponse, *github.Response, error) {
+	return nil, nil, c.err
+}
+
+func TestSyncRepositoryEnvironmentListing(t *testing.T) {
+	patterns, err := parseNamePatterns("prod-*")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	repo := &github.Repository{Owner: &github.User{Login: github.Ptr("example")}, Name: github.Ptr("service")}
+
+	testCases := []struct {
+		name         string
+		err          error
+		expectError  bool
+		expectStatus RepoStatus
+	}{
+		{
+			name:         "Forbidden",
+			err:          &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusForbidden, Request: &http.Request{URL: &url.URL{Path: "/repos/example/service/environments"}}}},
+			expectStatus: StatusSkipped,
+		},
+		{
+			name:         "Server error",
+			err:          &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusInternalServerError, Request: &http.Request{URL: &url.URL{Path: "/repos/example/service/environments"}}}},
+			expectError:  true,
+			expectStatus: StatusFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			report := &Report{}
+			run := &syncRun{
+				args:         EnvArgs{PerPage: defaultPerPage},
+				client:       &listEnvironmentsClient{err: tc.err},
+				targets:      []syncTarget{{Type: Actions}},
+				environments: patterns,
+				report:       report,
+			}
+			err := run.syncRepository(context.Background(), repo)
+			if (err != nil) != tc.expectError {
+				t.Errorf("Expected error: %v, got: %v", tc.expectError, err)
+			}
+			if len(report.Repositories) != 1 || report.Repositories[0].Status != tc.expectStatus {
+				t.Errorf("Expected a single %s result, got: %+v", tc.expectStatus, report.Repositories)
+			}
+		})
+	}
+}
+
+// getEnvironmentClient counts the requests for single environments.
+type getEnvironmentClient struct {
+	GitHubActionClient
+	calls int
+}
+
+func (c *getEnvironmentClient) GetEnvironment(ctx context.Context, owner, repo, envName string) (*github.Environment, *github.Response, error) {
+	c.calls++
+	return c.GitHubActionClient.GetEnvironment(ctx, owner, repo, envName)
+}
+
+func TestSyncRepositoryListedEnvironments(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+    environments: [prod-eu, prod-us, staging]
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mock, err := newMockServer(fixture)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server := httptest.NewServer(mock.handler())
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+	ctx := context.Background()
+	client := &getEnvironmentClient{GitHubActionClient: NewGitHubAPI(ctx, ClientOptions{Token: "mock", BaseURL: baseURL})}
+	patterns, err := parseNamePatterns("prod-*")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	report := &Report{}
+	run := &syncRun{
+		args:         EnvArgs{PerPage: defaultPerPage},
+		client:       client,
+		targets:      []syncTarget{{Type: Actions}, {Type: Actions, Environment: "staging"}},
+		environments: patterns,
+		secrets:      map[string]string{"TOKEN": "secret"},
+		report:       report,
+	}
+	repo := &github.Repository{Owner: &github.User{Login: github.Ptr("example")}, Name: github.Ptr("service"), ID: github.Ptr(int64(1))}
+	if err := run.syncRepository(ctx, repo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(report.Repositories) != 3 {
+		t.Errorf("Expected results of three environments, got: %+v", report.Repositories)
+	}
+	for _, result := range report.Repositories {
+		if result.Status != StatusSynced {
+			t.Errorf("Expected %s to be synced, got: %s", result.Target(), result.Status)
+		}
+	}
+	if client.calls != 0 {
+		t.Errorf("Expected listed environments not to be checked again, got %d requests", client.calls)
+	}
+}
+
+func TestCheckTargetInputs(t *testing.T) {
+	variables := map[string]string{"HOST": "example.com"}
+	testCases := []struct {
+		name         string
+		types        string
+		environments string
+		variables    map[string]string
+		expectError  bool
+	}{
+		{name: "Variables of actions", types: "actions", environments: "staging", variables: variables},
+		{name: "Variables with actions among the types", types: "dependabot,actions", variables: variables},
+		{name: "Secrets of dependabot", types: "dependabot,codespaces"},
+		{name: "Variables of dependabot", types: "dependabot", variables: variables, expectError: true},
+		{name: "Variables of codespaces", types: "codespaces", variables: variables, expectError: true},
+		{name: "Environment of dependabot", types: "dependabot", environments: "production", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			targets, err := matrixTargets(tc.types, tc.environments)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			err = checkTargetInputs(targets, tc.environments, tc.variables)
+			if tc.expectError && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestPerPage(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+    secrets: [TOKEN]
+    variables:
+      HOST: example.com
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		perPage  int
+		expected string
+	}{
+		{name: "Default", expected: "100"},
+		{name: "Smaller pages", perPage: 30, expected: "30"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock, err := newMockServer(fixture)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var mu sync.Mutex
+			pages := make(map[string]string)
+			handler := mock.handler()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet && (r.URL.Path == "/search/repositories" || strings.HasSuffix(r.URL.Path, "/secrets") || strings.HasSuffix(r.URL.Path, "/variables")) {
+					mu.Lock()
+					pages[r.URL.Path] = r.URL.Query().Get("per_page")
+					mu.Unlock()
+				}
+				handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+			baseURL, _ := url.Parse(server.URL + "/")
+			ctx := context.Background()
+			client := NewGitHubAPI(ctx, ClientOptions{Token: "mock", BaseURL: baseURL, PerPage: tc.perPage})
+
+			if _, err := client.SearchRepositories(ctx, "org:example"); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := client.SyncRepoSecrets(ctx, "example", "service", map[string]string{"TOKEN": "secret"}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := client.PutRepoVariables(ctx, "example", "service", map[string]string{"HOST": "example.org"}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			expected := map[string]string{
+				"/search/repositories":                     tc.expected,
+				"/repos/example/service/actions/secrets":   tc.expected,
+				"/repos/example/service/actions/variables": tc.expected,
+			}
+			if !reflect.DeepEqual(pages, expected) {
+				t.Errorf("Expected page sizes %v, got %v", expected, pages)
+			}
+		})
+	}
+}
+
+func TestFetchPages(t *testing.T) {
+	testCases := []struct {
+		name             string
+		limit            int
+		withLast         bool
+		failPage         int
+		expected         []int
+		expectedRequests []int
+		expectErr        bool
+	}{
+		{name: "Concurrent pages", limit: maxParallelPages, withLast: true, expected: []int{1, 2, 3, 4, 5, 6}},
+		{name: "Concurrent search pages", limit: maxParallelSearchPages, withLast: true, expected: []int{1, 2, 3, 4, 5, 6}},
+		{name: "Sequential pages", limit: maxParallelPages, withLast: false, expected: []int{1, 2, 3, 4, 5, 6}, expectedRequests: []int{1, 2, 3, 4, 5, 6}},
+		{name: "One page at a time", limit: 1, withLast: true, expected: []int{1, 2, 3, 4, 5, 6}, expectedRequests: []int{1, 2, 3, 4, 5, 6}},
+		{name: "Failing page", limit: maxParallelPages, withLast: true, failPage: 5, expectErr: true},
+		{name: "No pages after a failure", limit: 1, withLast: true, failPage: 3, expectErr: true, expectedRequests: []int{1, 2, 3}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			const lastPage = 6
+			var mu sync.Mutex
+			var requested []int
+			var inFlight, maxInFlight int
+			result, err := fetchPages(tc.limit, func(page int) ([]int, *github.Response, error) {
+				mu.Lock()
+				requested = append(requested, page)
+				inFlight++
+				maxInFlight = max(maxInFlight, inFlight)
+				mu.Unlock()
+				defer func() {
+					mu.Lock()
+					inFlight--
+					mu.Unlock()
+				}()
+				if page == tc.failPage {
+					return nil, nil, errors.New("failed")
+				}
+				resp := &github.Response{}
+				if page < lastPage {
+					resp.NextPage = page + 1
+				}
+				if tc.withLast {
+					resp.LastPage = lastPage
+				}
+				return []int{page}, resp, nil
+			})
+			if maxInFlight > tc.limit {
+				t.Errorf("Expected at most %d pages at a time, got %d", tc.limit, maxInFlight)
+			}
+			if tc.expectedRequests != nil && !reflect.DeepEqual(requested, tc.expectedRequests) {
+				t.Errorf("Expected the pages %v to be requested in order, got: %v", tc.expectedRequests, requested)
+			}
+			if tc.expectErr {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected items: %v, got: %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestRateLimitReset(t *testing.T) {
+	reset := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "Policy abort", err: fmt.Errorf("sync: %w", &RateLimitExceededError{Reset: reset}), expected: true},
+		{name: "Exhausted rate limit", err: &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: reset}}}, expected: true},
+		{name: "Other error", err: errors.New("failed"), expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, ok := rateLimitReset(tc.err)
+			if ok != tc.expected {
+				t.Fatalf("Expected rate limited: %v, got: %v", tc.expected, ok)
+			}
+			if ok && !result.Equal(reset) {
+				t.Errorf("Expected reset: %v, got: %v", reset, result)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	apiError := func(status int) error {
+		return &github.ErrorResponse{Response: &http.Response{StatusCode: status}}
+	}
+
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "Connection reset", err: &url.Error{Op: "Put", URL: "https://api.github.com", Err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, expected: true},
+		{name: "DNS failure", err: &url.Error{Op: "Get", Err: &net.DNSError{Err: "server misbehaving", Name: "api.github.com", IsTemporary: true}}, expected: true},
+		{name: "Unknown host", err: &url.Error{Op: "Get", Err: &net.DNSError{Err: "no such host", Name: "github.invalid", IsNotFound: true}}, expected: false},
+		{name: "Timeout", err: &url.Error{Op: "Get", Err: context.DeadlineExceeded}, expected: true},
+		{name: "Read timeout", err: &url.Error{Op: "Put", Err: &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}}, expected: true},
+		{name: "Closed connection", err: &url.Error{Op: "Get", Err: &net.OpError{Op: "read", Err: net.ErrClosed}}, expected: true},
+		{name: "Unexpected EOF", err: fmt.Errorf("failed to list secrets: %w", io.ErrUnexpectedEOF), expected: true},
+		{name: "Server error", err: apiError(http.StatusBadGateway), expected: true},
+		{name: "Too many requests", err: apiError(http.StatusTooManyRequests), expected: true},
+		{name: "Validation failure", err: apiError(http.StatusUnprocessableEntity), expected: false},
+		{name: "Joined with transient", err: errors.Join(&KeyError{Key: "A", Err: apiError(http.StatusUnprocessableEntity)}, &KeyError{Key: "B", Err: io.ErrUnexpectedEOF}), expected: true},
+		{name: "Joined permanent", err: errors.Join(&KeyError{Key: "A", Err: apiError(http.StatusNotFound)}), expected: false},
+		{name: "Other error", err: errors.New("failed to encrypt secret"), expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := isRetryable(tc.err); result != tc.expected {
+				t.Errorf("Expected retryable: %v, got: %v", tc.expected, result)
+			}
+			var permanent *backoff.PermanentError
+			if err := classifyRetry(tc.err); errors.As(err, &permanent) == tc.expected || err.Error() != tc.err.Error() {
+				t.Errorf("Unexpected classification: %v", err)
+			}
+		})
+	}
+}
+
+func TestWithRetryAfter(t *testing.T) {
+	tooManyRequests := func(retryAfter string) error {
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+		if retryAfter != "" {
+			resp.Header.Set("Retry-After", retryAfter)
+		}
+		return &github.ErrorResponse{Response: resp, Message: "too many requests"}
+	}
+
+	testCases := []struct {
+		name     string
+		err      error
+		expected time.Duration
+		ok       bool
+	}{
+		{name: "Seconds", err: tooManyRequests("30"), expected: 30 * time.Second, ok: true},
+		{name: "Date in the past", err: tooManyRequests(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)), expected: 0, ok: true},
+		{name: "Missing header", err: tooManyRequests(""), ok: false},
+		{name: "Secondary rate limit", err: &github.AbuseRateLimitError{Response: &http.Response{Request: &http.Request{}}, RetryAfter: github.Ptr(time.Minute)}, expected: time.Minute, ok: true},
+		{name: "Other error", err: errors.New("failed"), ok: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := withRetryAfter(tc.err)
+			if err.Error() != tc.err.Error() {
+				t.Errorf("Expected message: %q, got: %q", tc.err.Error(), err.Error())
+			}
+			if !errors.Is(err, tc.err) {
+				t.Errorf("Expected original error to be kept")
+			}
+
+			var retryAfterErr *backoff.RetryAfterError
+			if ok := errors.As(err, &retryAfterErr); ok != tc.ok {
+				t.Fatalf("Expected retry after: %v, got: %v", tc.ok, ok)
+			}
+			if tc.ok && retryAfterErr.Duration != tc.expected {
+				t.Errorf("Expected duration: %v, got: %v", tc.expected, retryAfterErr.Duration)
+			}
+		})
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	path := t.TempDir() + "/output"
+	t.Setenv("GITHUB_OUTPUT", path)
+
+	if err := setOutput("single", "value"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := setOutput("multi", "line1\nline2"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 5 || lines[0] != "single=value" || !strings.HasPrefix(lines[1], "multi<<ghadelimiter_") ||
+		lines[2] != "line1" || lines[3] != "line2" || lines[4] != strings.TrimPrefix(lines[1], "multi<<") {
+		t.Errorf("Unexpected output file content:\n%s", data)
+	}
+}
+
+func TestWaitWithProgress(t *testing.T) {
+	if err := waitWithProgress(context.Background(), log.Default(), 10*time.Millisecond, time.Millisecond); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if err := waitWithProgress(ctx, log.Default(), time.Hour, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Expected cancelled wait to return immediately")
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/search" {
+			w.Header().Set("X-RateLimit-Resource", "search")
+		}
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "42")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	}))
+	defer server.Close()
+
+	tracker := newRateLimitTracker()
+	client := &http.Client{Transport: &rateLimitTransport{base: http.DefaultTransport, tracker: tracker}}
+
+	if _, ok := tracker.rate("core"); ok {
+		t.Fatal("Expected unknown rate before the first response")
+	}
+	resp, err := client.Get(server.URL + "/repos")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	rate, ok := tracker.rate("core")
+	if !ok || rate.Limit != 5000 || rate.Remaining != 42 || !rate.Reset.Time.Equal(reset) {
+		t.Errorf("Unexpected core rate: %+v (known: %v)", rate, ok)
+	}
+	if _, ok := tracker.rate("search"); ok {
+		t.Error("Expected unknown search rate")
+	}
+
+	// Rates whose window has been reset are unknown again.
+	tracker.update("core", github.Rate{Limit: 5000, Remaining: 0, Reset: github.Timestamp{Time: time.Now().Add(-time.Second)}})
+	if _, ok := tracker.rate("core"); ok {
+		t.Error("Expected unknown rate after reset")
+	}
+}
+
+// rateLimitsClient is a GitHubActionClient that only answers rate limit queries.
+type rateLimitsClient struct {
+	GitHubActionClient
+	limits *github.RateLimits
+	err    error
+	calls  int
+}
+
+func (c *rateLimitsClient) Ratelimits(context.Context) (*github.RateLimits, *github.Response, error) {
+	c.calls++
+	return c.limits, nil, c.err
+}
+
+func TestAPIUsage(t *testing.T) {
+	usage := newAPIUsage()
+	for _, call := range []struct{ method, path string }{
+		{http.MethodGet, "/search/repositories"},
+		{http.MethodGet, "/repos/owner/repo/actions/secrets"},
+		{http.MethodGet, "/repos/owner/repo/actions/secrets/public-key"},
+		{http.MethodPut, "/repos/owner/repo/actions/secrets/TOKEN"},
+		{http.MethodPost, "/api/v3/repos/owner/repo/actions/variables"},
+		{http.MethodDelete, "/repos/owner/repo/actions/secrets/OLD"},
+		{http.MethodGet, "/rate_limit"},
+	} {
+		usage.count(&http.Request{Method: call.method, URL: &url.URL{Path: call.path}})
+	}
+	usage.tracker.update(coreResource, github.Rate{Limit: 5000, Remaining: 4990, Reset: github.Timestamp{Time: time.Now().Add(time.Hour)}})
+
+	summary := usage.Summary()
+	expected := "API calls: 1 search, 2 list, 2 put, 1 delete, 1 rate-limit (7 total), core rate limit 4990/5000 remaining until "
+	if !strings.HasPrefix(summary, expected) || strings.Contains(summary, "search rate limit") {
+		t.Errorf("Unexpected summary: %s", summary)
+	}
+}
+
+func TestEnsureResourceRatelimits(t *testing.T) {
+	reset := github.Timestamp{Time: time.Now().Add(time.Minute)}
+	client := &rateLimitsClient{limits: &github.RateLimits{
+		Core:   &github.Rate{Limit: 5000, Remaining: 4000, Reset: reset},
+		Search: &github.Rate{Limit: 30, Remaining: 1, Reset: reset},
+	}}
+	api := newRateLimitedGitHubAPI(client, newRateLimitTracker(), RateLimitFail, 0).(*rateLimitedGitHubAPI)
+
+	if err := api.ensureRatelimits(context.Background()); err != nil {
+		t.Errorf("Expected core requests to proceed, got: %v", err)
+	}
+	var exceededErr *RateLimitExceededError
+	if err := api.ensureSearchRatelimits(context.Background()); !errors.As(err, &exceededErr) || exceededErr.Resource != searchResource {
+		t.Errorf("Expected search rate limit to be exceeded, got: %v", err)
+	}
+}
+
+func TestReadRateLimits(t *testing.T) {
+	reset := github.Timestamp{Time: time.Now().Add(time.Minute)}
+	client := &rateLimitsClient{limits: &github.RateLimits{
+		Core:   &github.Rate{Limit: 5000, Remaining: 4000, Reset: reset},
+		Search: &github.Rate{Limit: 30, Remaining: 20, Reset: reset},
+	}}
+	tracker := newRateLimitTracker()
+	ctx := context.Background()
+	rateLimits := readRateLimits(ctx, client, tracker)
+	if err := checkToken(ctx, rateLimits, "ghp_example"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if identity, err := whoami(ctx, &noUserClient{client}, rateLimits, "ghp_example"); err != nil || identity.Core == nil || identity.Core.Remaining != 4000 {
+		t.Errorf("Expected the core rate limit of the shared response, got %+v (%v)", identity.Core, err)
+	}
+
+	// The rate limit checks of the run start from the shared response.
+	api := newRateLimitedGitHubAPI(client, tracker, RateLimitFail, 0).(*rateLimitedGitHubAPI)
+	if err := api.ensureRatelimits(ctx); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := api.ensureSearchRatelimits(ctx); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if client.calls != 1 {
+		t.Errorf("Expected the rate limit to be read once, got %d requests", client.calls)
+	}
+}
+
+// noUserClient is refused the authenticated user, like a GitHub App installation token.
+type noUserClient struct {
+	GitHubActionClient
+}
+
+func (c *noUserClient) AuthenticatedUser(context.Context) (*github.User, *github.Response, error) {
+	return nil, nil, &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusForbidden}}
+}
+
+func TestEnsureResourceRatelimitsDisabled(t *testing.T) {
+	client := &rateLimitsClient{err: &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}}}
+	api := newRateLimitedGitHubAPI(client, newRateLimitTracker(), RateLimitFail, 0).(*rateLimitedGitHubAPI)
+
+	for range 3 {
+		if err := api.ensureRatelimits(context.Background()); err != nil {
+			t.Errorf("Expected requests to proceed, got: %v", err)
+		}
+	}
+	if client.calls != 1 {
+		t.Errorf("Expected rate limits to be fetched once, got: %d", client.calls)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	t.Setenv("MAX_RETRIES", "5")
+	t.Setenv("TYPE", "dependabot")
+	t.Setenv("SYNC_SECRETS_REQUIRE_KEYS", "TOKEN")
+	t.Setenv("DELETE", "TOKEN")
+	t.Setenv("INPUT_GITHUB-TOKEN", "ghp_input")
+	t.Setenv("INPUT_SKIP_REPOS", "owner/skipped")
+	t.Setenv("INPUT_MAX-RETRIES", "7")
+
+	testCases := []struct {
+		name   string
+		argv   []string
+		ran    bool
+		verify func(t *testing.T, args EnvArgs)
+	}{
+		{
+			name: "Environment fallback",
+			argv: []string{"--target", "owner/repo"},
+			ran:  true,
+			verify: func(t *testing.T, args EnvArgs) {
+				if args.TargetRepo != "owner/repo" || args.MaxRetries != 5 || args.Type != "dependabot" || args.Order != "alpha" || args.Delete != "" {
+					t.Errorf("Unexpected args: %+v", args)
+				}
+			},
+		},
+		{
+			name: "Action inputs",
+			argv: []string{},
+			ran:  true,
+			verify: func(t *testing.T, args EnvArgs) {
+				if args.GithubToken != "ghp_input" || args.SkipRepos != "owner/skipped" {
+					t.Errorf("Unexpected args: %+v", args)
+				}
+			},
+		},
+		{
+			name: "Flag takes precedence",
+			argv: []string{"--max-retries=1", "--dry-run=true"},
+			ran:  true,
+			verify: func(t *testing.T, args EnvArgs) {
+				if args.MaxRetries != 1 || !args.DryRun {
+					t.Errorf("Unexpected args: %+v", args)
+				}
+			},
+		},
+		{
+			name: "Disable fail fast",
+			argv: []string{"--fail-fast=false"},
+			ran:  true,
+			verify: func(t *testing.T, args EnvArgs) {
+				if args.FailFast == nil || *args.FailFast || args.ContinueOnError || args.failFast() {
+					t.Errorf("Unexpected args: %+v", args)
+				}
+			},
+		},
+		{
+			name: "Empty fail fast input",
+			argv: []string{"--fail-fast=", "--continue-on-error=true"},
+			ran:  true,
+			verify: func(t *testing.T, args EnvArgs) {
+				if args.FailFast != nil || args.failFast() {
+					t.Errorf("Unexpected args: %+v", args)
+				}
+			},
+		},
+		{
+			name: "Fail fast without value",
+			argv: []string{"--fail-fast"},
+			ran:  true,
+			verify: func(t *testing.T, args EnvArgs) {
+				if args.FailFast == nil || !*args.FailFast || !args.failFast() {
+					t.Errorf("Unexpected args: %+v", args)
+				}
+			},
+		},
+		{
+			name: "Subcommand with global flags",
+			argv: []string{"check", "--query", "org:example"},
+			ran:  true,
+			verify: func(t *testing.T, args EnvArgs) {
+				if args.Check == nil || args.Check.RequireKeys != "TOKEN" || args.Query != "org:example" {
+					t.Errorf("Unexpected args: %+v", args)
+				}
+			},
+		},
+		{
+			name: "Positional arguments",
+			argv: []string{"diff", "owner/a", "owner/b:production"},
+			ran:  true,
+			verify: func(t *testing.T, args EnvArgs) {
+				if args.Diff == nil || args.Diff.Left != "owner/a" || args.Diff.Right != "owner/b:production" {
+					t.Errorf("Unexpected args: %+v", args.Diff)
+				}
+			},
+		},
+		{
+			name: "Completion script",
+			argv: []string{"completion", "bash"},
+			ran:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args, ran, err := parseArgs(tc.argv, io.Discard)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if ran != tc.ran {
+				t.Fatalf("Expected ran: %v, got: %v", tc.ran, ran)
+			}
+			if tc.verify != nil {
+				tc.verify(t, args)
+			}
+		})
+	}
+}
+
+func TestReportRender(t *testing.T) {
+	report := &Report{DryRun: true}
+	report.Add(RepoResult{Repository: "owner/repo", Type: Actions, Status: StatusSynced, Keys: []KeyResult{
+		{Kind: "secret", Name: "API_KEY", Outcome: KeyCreated},
+		{Kind: "secret", Name: "OLD", Outcome: KeyDeleted},
+		{Kind: "variable", Name: "UNCHANGED", Outcome: KeyUnchanged},
+	}})
+	report.Add(RepoResult{Repository: "owner/other", Type: Actions, Environment: "prod", Status: StatusSynced, Keys: []KeyResult{
+		{Kind: "variable", Name: "REGION", Outcome: KeyUpdated},
+	}})
+
+	var plain strings.Builder
+	report.Render(&plain, false)
+	expected := `Planned changes:
+  + secret    API_KEY  owner/repo
+  - secret    OLD      owner/repo
+  ~ variable  REGION   owner/other (environment prod)
+
+Summary: 2 synced, 0 unchanged, 0 skipped, 0 partial, 0 failed
+`
+	if plain.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, plain.String())
+	}
+
+	var colored strings.Builder
+	report.Render(&colored, true)
+	if !strings.Contains(colored.String(), colorGreen+"  + secret    API_KEY  owner/repo"+colorReset) ||
+		!strings.Contains(colored.String(), colorRed+"  - secret    OLD      owner/repo"+colorReset) {
+		t.Errorf("Expected colorized rows, got:\n%q", colored.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.txt")
+	if err := report.WritePlan(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != expected {
+		t.Errorf("Expected plan file:\n%s\ngot:\n%s (%v)", expected, data, err)
+	}
+}
+
+func TestModifiedRepositories(t *testing.T) {
+	report := &Report{}
+	report.Add(RepoResult{Repository: "owner/a", Status: StatusSynced, Keys: []KeyResult{{Kind: "secret", Name: "TOKEN", Outcome: KeyUpdated}}})
+	report.Add(RepoResult{Repository: "owner/b", Status: StatusUnchanged})
+	report.Add(RepoResult{Repository: "owner/c", Status: StatusFailed, Keys: []KeyResult{{Kind: "secret", Name: "TOKEN", Outcome: KeyFailed}}})
+	report.Add(RepoResult{Repository: "owner/a", Environment: "prod", Status: StatusSynced, Keys: []KeyResult{{Kind: "variable", Name: "OLD", Outcome: KeyDeleted}}})
+
+	expected := []string{"owner/a"}
+	if result := report.ModifiedRepositories(); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected repositories: %v, got: %v", expected, result)
+	}
+	if result := (&Report{}).ModifiedRepositories(); result == nil || len(result) != 0 {
+		t.Errorf("Expected empty list, got: %#v", result)
+	}
+}
+
+func TestVariableOutcome(t *testing.T) {
+	existing := map[string]string{"HOST": "example.com"}
+	testCases := []struct {
+		name     string
+		key      string
+		value    string
+		expected KeyOutcome
+	}{
+		{name: "Missing", key: "PORT", value: "8080", expected: KeyCreated},
+		{name: "Unchanged", key: "HOST", value: "example.com", expected: KeyUnchanged},
+		{name: "Changed", key: "HOST", value: "example.org", expected: KeyUpdated},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if outcome := variableOutcome(existing, tc.key, tc.value); outcome != tc.expected {
+				t.Errorf("Expected outcome %s, got %s", tc.expected, outcome)
+			}
+		})
+	}
+}
+
+func TestValueTemplates(t *testing.T) {
+	templates, err := parseValueTemplates(map[string]string{
+		"WEBHOOK_SECRET": "{{ randAlphaNum 24 }}",
+		"ID":             "{{ uuidv4 }}",
+		"SERVICE":        "{{ .Repository }}-{{ .Environment }}",
+		"AUTH":           `{{ printf "%s:token" .Owner | b64enc }}`,
+		"PLAIN":          "value",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data := templateData{Owner: "example", Repository: "service", FullName: "example/service", Environment: "production"}
+	first, err := templates.render(data, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !regexp.MustCompile(`^[A-Za-z0-9]{24}$`).MatchString(first["WEBHOOK_SECRET"]) {
+		t.Errorf("Unexpected random value: %q", first["WEBHOOK_SECRET"])
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(first["ID"]) {
+		t.Errorf("Unexpected UUID: %q", first["ID"])
+	}
+	expected := map[string]string{"SERVICE": "service-production", "AUTH": "ZXhhbXBsZTp0b2tlbg==", "PLAIN": "value"}
+	for name, value := range expected {
+		if first[name] != value {
+			t.Errorf("Expected %s to be %q, got %q", name, value, first[name])
+		}
+	}
+
+	second, err := templates.render(data, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if first["WEBHOOK_SECRET"] == second["WEBHOOK_SECRET"] {
+		t.Error("Expected a new random value per rendering")
+	}
+
+	for _, invalid := range []string{"{{ secrets.TOKEN }}", "{{ randAlphaNum 8", "{{ .Unknown }}"} {
+		templates, err := parseValueTemplates(map[string]string{"KEY": invalid})
+		if err == nil {
+			_, err = templates.render(data, nil)
+		}
+		if err == nil {
+			t.Errorf("Expected error for %q, got nil", invalid)
+		}
+	}
+}
+
+func TestAddMask(t *testing.T) {
+	var b strings.Builder
+	addMask(&b, "line1\r\n100%\n\nline3")
+	expected := "::add-mask::line1\n::add-mask::100%25\n::add-mask::line3\n"
+	if b.String() != expected {
+		t.Errorf("Expected commands %q, got %q", expected, b.String())
+	}
+
+	templates, err := parseValueTemplates(map[string]string{"GENERATED": "{{ randAlphaNum 16 }}", "STATIC": "value"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var masked []string
+	values, err := templates.render(templateData{}, func(value string) { masked = append(masked, value) })
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(masked, []string{values["GENERATED"]}) {
+		t.Errorf("Expected only the derived value to be masked, got: %v", masked)
+	}
+}
+
+func TestRepoConfig(t *testing.T) {
+	cfg, err := parseRepoConfig([]byte("exclude-keys: [legacy_token]\nenvironments: [staging]\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	targets := []syncTarget{{Type: Actions, Environment: "staging"}, {Type: Actions, Environment: "production"}, {Type: Dependabot}}
+	expectedTargets := []syncTarget{{Type: Actions, Environment: "staging"}, {Type: Dependabot}}
+	if result := cfg.applyTargets(targets); !reflect.DeepEqual(result, expectedTargets) {
+		t.Errorf("Expected targets: %v, got: %v", expectedTargets, result)
+	}
+
+	values := map[string]string{"TOKEN": "a", "LEGACY_TOKEN": "b"}
+	expectedValues := map[string]string{"TOKEN": "a"}
+	if result := cfg.applyValues(values); !reflect.DeepEqual(result, expectedValues) {
+		t.Errorf("Expected values: %v, got: %v", expectedValues, result)
+	}
+	if len(values) != 2 {
+		t.Error("Expected the central values to be left unchanged")
+	}
+
+	ctx := withKeptKeys(context.Background(), cfg.ExcludeKeys)
+	if shouldPrune(ctx, expectedValues, "LEGACY_TOKEN") || shouldPrune(ctx, expectedValues, "TOKEN") || !shouldPrune(ctx, expectedValues, "OTHER") {
+		t.Error("Expected only keys neither in the input nor excluded to be pruned")
+	}
+
+	if cfg, err := parseRepoConfig(nil); err != nil || cfg.OptOut {
+		t.Errorf("Expected empty configuration, got: %+v, %v", cfg, err)
+	}
+	for _, invalid := range []string{"opt_out: true\n", "exclude-keys: [GITHUB_TOKEN]\n", "environments: ['']\n"} {
+		if _, err := parseRepoConfig([]byte(invalid)); err == nil {
+			t.Errorf("Expected error for %q, got nil", invalid)
+		}
+	}
+}
+
+func TestPrunePolicy(t *testing.T) {
+	protect, err := parseNamePatterns("DO_NOT_TOUCH_*\n/^tf_[a-z]+$/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ctx := withPrunePolicy(context.Background(), &prunePolicy{protect: protect})
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"DO_NOT_TOUCH_TOKEN", false},
+		{"do_not_touch_token", false},
+		{"TF_STATE", false},
+		{"TF_STATE_1", true},
+		{"TOKEN", true},
+	}
+	for _, tt := range tests {
+		if result := shouldPrune(ctx, nil, tt.name); result != tt.expected {
+			t.Errorf("Expected pruning %s to be %v, got: %v", tt.name, tt.expected, result)
+		}
+	}
+
+	ctx = withPrunePolicy(context.Background(), &prunePolicy{managedPrefix: "sync_"})
+	if shouldPrune(ctx, nil, "TOKEN") || !shouldPrune(ctx, nil, "SYNC_TOKEN") {
+		t.Error("Expected only keys with the managed prefix to be pruned")
+	}
+
+	for _, invalid := range []string{"KEY_[", "/(/"} {
+		if _, err := parseNamePatterns(invalid); err == nil {
+			t.Errorf("Expected error for %q, got nil", invalid)
+		}
+	}
+}
+
+func TestRepoFilter(t *testing.T) {
+	pushed := github.Timestamp{Time: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}
+	repo := &github.Repository{
+		Name:             github.Ptr("service"),
+		Owner:            &github.User{Login: github.Ptr("example")},
+		Topics:           []string{"docker", "go"},
+		Private:          github.Ptr(true),
+		Archived:         github.Ptr(false),
+		Language:         github.Ptr("Go"),
+		PushedAt:         &pushed,
+		CustomProperties: map[string]any{"team": "platform"},
+	}
+
+	testCases := []struct {
+		expr        string
+		expected    bool
+		expectError bool
+	}{
+		{expr: `!repo.archived && "docker" in repo.topics`, expected: true},
+		{expr: `repo.visibility == "private" && repo.language == "Go"`, expected: true},
+		{expr: `repo.pushed_at > timestamp("2025-01-01T00:00:00Z")`, expected: false},
+		{expr: `repo.custom_properties.team == "platform" && repo.full_name.startsWith("example/")`, expected: true},
+		{expr: `repo.custom_properties.owner == "platform"`, expectError: true},
+		{expr: `repo.name`, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expr, func(t *testing.T) {
+			filter, err := compileRepoFilter(tc.expr)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			matched, err := filter.match(repo)
+			if tc.expectError {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if matched != tc.expected {
+				t.Errorf("Expected match: %v, got: %v", tc.expected, matched)
+			}
+		})
+	}
+
+	for _, invalid := range []string{`repo.name +`, `size(repo.topics)`, `unknown.name == "a"`} {
+		if _, err := compileRepoFilter(invalid); err == nil {
+			t.Errorf("Expected error for %q, got nil", invalid)
+		}
+	}
+}
+
+func TestPromoteValues(t *testing.T) {
+	source := map[string]string{"API_URL": "https://staging.example.com", "LOG_LEVEL": "info", "DEBUG_TOOLBAR": "true"}
+
+	promoted, promotion, err := promoteValues(source, []string{"api_url", "LOG_LEVEL"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]string{"API_URL": "https://staging.example.com", "LOG_LEVEL": "info"}
+	if !reflect.DeepEqual(promoted, expected) {
+		t.Errorf("Expected values: %v, got: %v", expected, promoted)
+	}
+	if !reflect.DeepEqual(promotion.Keys, []string{"API_URL", "LOG_LEVEL"}) || !reflect.DeepEqual(promotion.Excluded, []string{"DEBUG_TOOLBAR"}) {
+		t.Errorf("Unexpected promotion: %+v", promotion)
+	}
+
+	if _, _, err := promoteValues(source, []string{"API_URL", "FEATURE_FLAGS"}); err == nil {
+		t.Error("Expected error for allowed key missing from the source, got nil")
+	}
+}
+
+func TestDryRunVariableDiff(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+    variables:
+      HOST: old.example.com
+      REGION: eu
+      LEGACY: "true"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock, err := newMockServer(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(mock.handler())
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+	var out strings.Builder
+	client := NewGitHubAPI(context.Background(), ClientOptions{Token: "mock", BaseURL: baseURL, DryRunEnabled: true})
+
+	variables := map[string]string{"HOST": "example.com", "REGION": "eu", "PORT": "443"}
+	if err := client.SyncRepoVariables(withLogger(context.Background(), log.New(&out, "", 0)), "example", "service", variables); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, line := range []string{
+		`Dry run: Would delete variable 'LEGACY' with value "true" from repo example/service`,
+		`Dry run: Would change variable 'HOST' from "old.example.com" to "example.com" in repo example/service`,
+		`Dry run: Would add variable 'PORT' with value "443" in repo example/service`,
+		"Dry run: Variable 'REGION' is unchanged in repo example/service",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected %q in:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestDescribeVariableChange(t *testing.T) {
+	// Values read from the targets must not inject workflow commands into the log.
+	existing := map[string]string{"HOST": "old\n::add-mask::example.com"}
+	expected := `Would change variable 'HOST' from "old\n::add-mask::example.com" to "example.com"`
+	if got := describeVariableChange(existing, "HOST", "example.com"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	var out strings.Builder
+	left, right := "old\n::set-output name=x::y", "new"
+	printVariableDiffs(&out, "staging", "production", []variableDiff{{Key: "HOST", Left: &left, Right: &right}})
+	if strings.Contains(out.String(), "\n::set-output") {
+		t.Errorf("Expected the values to be escaped, got:\n%s", out.String())
+	}
+}
+
+func TestPutVariablesSkipsUnchanged(t *testing.T) {
+	var writes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `{"total_count":2,"variables":[{"name":"HOST","value":"example.com"},{"name":"REGION","value":"us"}]}`)
+			return
+		}
+		writes = append(writes, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	api := newGitHubAPI(client, false, defaultPerPage)
+
+	keys := &keyRecorder{}
+	ctx := withKeyRecorder(context.Background(), keys)
+	variables := map[string]string{"HOST": "example.com", "REGION": "eu"}
+	if err := api.PutRepoVariables(ctx, "owner", "repo", variables); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := api.PutEnvVariables(ctx, envTarget{Owner: "owner", Repo: "repo"}, "production", variables); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"PATCH /repos/owner/repo/actions/variables/REGION", "PATCH /repos/owner/repo/environments/production/variables/REGION"}
+	if !reflect.DeepEqual(writes, expected) {
+		t.Errorf("Expected writes %v, got %v", expected, writes)
+	}
+	if results := keys.Results(); len(results) != 2 || results[0].Outcome != KeyUnchanged {
+		t.Errorf("Expected HOST to be recorded as unchanged, got %v", results)
+	}
+}
+
+func TestProcessRepositoryStatus(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+    variables:
+      HOST: example.com
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mock, err := newMockServer(fixture)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server := httptest.NewServer(mock.handler())
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+	client := NewGitHubAPI(context.Background(), ClientOptions{Token: "mock", BaseURL: baseURL})
+
+	testCases := []struct {
+		name      string
+		variables map[string]string
+		expected  RepoStatus
+	}{
+		{name: "same value", variables: map[string]string{"HOST": "example.com"}, expected: StatusUnchanged},
+		{name: "new value", variables: map[string]string{"HOST": "example.org"}, expected: StatusSynced},
+		{name: "nothing to sync", expected: StatusUnchanged},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := EnvArgs{Type: string(Actions)}
+			ctx := withLogger(context.Background(), log.New(io.Discard, "", 0))
+			result, err := processRepository(ctx, args, client, newRepository("example", "service"), nil, tc.variables)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Status != tc.expected {
+				t.Errorf("Expected status %s, got %s", tc.expected, result.Status)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateRepoVariableConflict(t *testing.T) {
+	var requests []string
+	missing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method)
+		switch {
+		case r.Method == http.MethodPatch && missing:
+			missing = false
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost:
+			// Another sync created the variable after the update.
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"message":"Already exists"}`)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	api := newGitHubAPI(client, false, defaultPerPage)
+
+	_, err := api.CreateOrUpdateRepoVariable(context.Background(), "owner", "repo", &github.ActionsVariable{Name: "HOST", Value: "example.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{http.MethodPatch, http.MethodPost, http.MethodPatch}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected requests: %v, got: %v", expected, requests)
+	}
+
+	// Existing variables are updated in place, never deleted.
+	requests = nil
+	_, err = api.CreateOrUpdateRepoVariable(context.Background(), "owner", "repo", &github.ActionsVariable{Name: "HOST", Value: "example.com"})
+	if err != nil || !reflect.DeepEqual(requests, []string{http.MethodPatch}) {
+		t.Errorf("Expected a single update, got %v (%v)", requests, err)
+	}
+}
+
+// notFoundClient is a GitHubActionClient whose environment public key is missing for the first calls.
+type notFoundClient struct {
+	GitHubActionClient
+	missing int
+	calls   int
+}
+
+func (c *notFoundClient) GetEnvPublicKey(_ context.Context, _ int, _ string) (*github.PublicKey, *github.Response, error) {
+	c.calls++
+	if c.calls <= c.missing {
+		return nil, nil, &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}}
+	}
+	return &github.PublicKey{KeyID: github.Ptr("key")}, &github.Response{}, nil
+}
+
+func TestCreatedEnvironmentRetry(t *testing.T) {
+	testCases := []struct {
+		name          string
+		created       bool
+		expectedCalls int
+		expectedErr   bool
+	}{
+		{name: "existing environment", expectedCalls: 1, expectedErr: true},
+		{name: "created environment", created: true, expectedCalls: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &notFoundClient{missing: 2}
+			api := &retryableGitHubAPI{
+				client:         client,
+				backoffOptions: []backoff.RetryOption{backoff.WithMaxTries(5), backoff.WithBackOff(&backoff.ZeroBackOff{})},
+			}
+			ctx := context.Background()
+			if tc.created {
+				ctx = withCreatedEnvironment(ctx)
+			}
+			_, _, err := api.GetEnvPublicKey(ctx, 42, "staging")
+			if (err != nil) != tc.expectedErr {
+				t.Errorf("Expected error: %v, got: %v", tc.expectedErr, err)
+			}
+			if client.calls != tc.expectedCalls {
+				t.Errorf("Expected %d calls, got %d", tc.expectedCalls, client.calls)
+			}
+		})
+	}
+}
+
+func TestRetryAttempts(t *testing.T) {
+	api := &retryableGitHubAPI{backoffOptions: []backoff.RetryOption{backoff.WithMaxTries(5), backoff.WithBackOff(&backoff.ZeroBackOff{})}}
+	statuses := []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusNotFound}
+	attempts := 0
+	err := api.retry(context.Background(), "GetRepoPublicKey", func() (bool, error) {
+		err := &github.ErrorResponse{Response: &http.Response{StatusCode: statuses[attempts]}}
+		attempts++
+		return true, classifyRetry(err)
+	})
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+	if !isStatus(err, http.StatusNotFound) || !strings.HasSuffix(err.Error(), "(after 3 attempts)") {
+		t.Errorf("Expected 404 after 3 attempts, got: %v", err)
+	}
+
+	if reason := retryReason(&github.AbuseRateLimitError{}); reason != "secondary rate limit" {
+		t.Errorf("Expected secondary rate limit, got %q", reason)
+	}
+
+	// The rate limit checks query the rate limit before any other request, a reset connection must not fail them.
+	limits := &flakyRateLimitsClient{err: &url.Error{Op: "Get", URL: "https://api.github.com/rate_limit", Err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}}
+	api = &retryableGitHubAPI{client: limits, backoffOptions: []backoff.RetryOption{backoff.WithMaxTries(5), backoff.WithBackOff(&backoff.ZeroBackOff{})}}
+	if _, _, err := api.Ratelimits(context.Background()); err != nil || limits.calls != 2 {
+		t.Errorf("Expected the rate limit to be queried again, got %d calls: %v", limits.calls, err)
+	}
+}
+
+// flakyRateLimitsClient is a GitHubActionClient whose first rate limit query fails with err.
+type flakyRateLimitsClient struct {
+	GitHubActionClient
+	err   error
+	calls int
+}
+
+func (c *flakyRateLimitsClient) Ratelimits(context.Context) (*github.RateLimits, *github.Response, error) {
+	c.calls++
+	if c.calls == 1 {
+		return nil, nil, c.err
+	}
+	return &github.RateLimits{}, nil, nil
+}
+
+func TestMockServer(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+    topics: [docker]
+    environments: [staging]
+    secrets: [OLD_TOKEN]
+    variables:
+      HOST: old.example.com
+  - name: example/archived
+    archived: true
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mock, err := newMockServer(fixture)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server := httptest.NewServer(mock.handler())
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+	ctx := context.Background()
+	client := NewGitHubAPI(ctx, ClientOptions{Token: "mock", BaseURL: baseURL})
+
+	repos, err := client.SearchRepositories(ctx, "org:example topic:docker archived:false")
+	if err != nil || len(repos) != 1 || repos[0].GetFullName() != "example/service" {
+		t.Fatalf("Expected example/service to be found, got %v (%v)", repos, err)
+	}
+
+	secrets := map[string]string{"TOKEN": "secret"}
+	variables := map[string]string{"HOST": "example.com"}
+	for _, env := range []string{"", "staging"} {
+		args := EnvArgs{Type: string(Actions), Environment: env, Prune: true}
+		if _, err := processRepository(ctx, args, client, repos[0], secrets, variables); err != nil {
+			t.Fatalf("Unexpected error syncing to %q: %v", env, err)
+		}
+	}
+
+	repo := mock.repos["example/service"]
+	for _, scope := range []string{"actions", "env:staging"} {
+		if names := sortedKeys(repo.scopes[scope].secrets); !reflect.DeepEqual(names, []string{"TOKEN"}) {
+			t.Errorf("Expected secrets [TOKEN] in %s, got %v", scope, names)
+		}
+		if !reflect.DeepEqual(repo.scopes[scope].variables, variables) {
+			t.Errorf("Expected variables %v in %s, got %v", variables, scope, repo.scopes[scope].variables)
+		}
+	}
+
+	args := EnvArgs{Type: string(Actions), Environment: "production"}
+	if _, err := processRepository(ctx, args, client, repos[0], secrets, variables); !isStatus(err, http.StatusNotFound) {
+		t.Errorf("Expected 404 syncing to a missing environment, got: %v", err)
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	testCases := []struct {
+		name        string
+		status      int
+		expectedErr string
+	}{
+		{name: "valid token", status: http.StatusOK},
+		{name: "expired token", status: http.StatusUnauthorized, expectedErr: "fine-grained personal access token"},
+		{name: "rate limiting disabled", status: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set(tokenExpirationHeader, time.Now().Add(time.Hour).UTC().Format("2006-01-02 15:04:05 MST"))
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, `{"message":"Bad credentials"}`)
+			}))
+			defer server.Close()
+			client := github.NewClient(nil)
+			client.BaseURL, _ = url.Parse(server.URL + "/")
+
+			api := newGitHubAPI(client, false, defaultPerPage)
+			err := checkToken(context.Background(), readRateLimits(context.Background(), api, newRateLimitTracker()), "github_pat_example")
+			if tc.expectedErr == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)) {
+				t.Errorf("Expected error containing %q, got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+
+	if expiry, ok := parseTokenExpiration("2026-11-01 12:00:00 UTC"); !ok || !expiry.Equal(time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected 2026-11-01T12:00:00Z, got %v (%v)", expiry, ok)
+	}
+}
+
+func TestAPIUsageConsumed(t *testing.T) {
+	usage := newAPIUsage()
+	if _, _, ok := usage.consumed(); ok {
+		t.Error("Expected consumption to be unknown without rate limits")
+	}
+
+	reset := github.Timestamp{Time: time.Now().Add(time.Hour).Truncate(time.Second)}
+	usage.tracker.update(coreResource, github.Rate{Limit: 5000, Remaining: 4900, Reset: reset})
+	usage.markStart()
+	usage.tracker.update(coreResource, github.Rate{Limit: 5000, Remaining: 4750, Reset: reset})
+	if used, remaining, ok := usage.consumed(); !ok || used != 150 || remaining != 4750 {
+		t.Errorf("Expected 150 used and 4750 remaining, got %d and %d (%v)", used, remaining, ok)
+	}
+
+	// After a reset, only the requests of the new window are known.
+	usage.tracker.update(coreResource, github.Rate{Limit: 5000, Remaining: 4980, Reset: github.Timestamp{Time: reset.Add(time.Hour)}})
+	if used, remaining, ok := usage.consumed(); !ok || used != 20 || remaining != 4980 {
+		t.Errorf("Expected 20 used and 4980 remaining, got %d and %d (%v)", used, remaining, ok)
+	}
+}
+
+// repositorySeq returns a sequence of repos, as returned by resolveRepositories.
+func repositorySeq(repos ...*github.Repository) iter.Seq2[*github.Repository, error] {
+	return func(yield func(*github.Repository, error) bool) {
+		for _, repo := range repos {
+			if !yield(repo, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	repos := repositorySeq(newRepository("example", "broken"), newRepository("example", "service"))
+	var synced []string
+	syncRepo := continueOnError(func(_ context.Context, repo *github.Repository) error {
+		synced = append(synced, repo.GetName())
+		if repo.GetName() == "broken" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	if err := syncByOwner(context.Background(), repos, 1, syncRepo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(synced, []string{"broken", "service"}) {
+		t.Errorf("Expected both repositories to be synced, got %v", synced)
+	}
+
+	synced = nil
+	syncRepo = continueOnError(func(_ context.Context, repo *github.Repository) error {
+		synced = append(synced, repo.GetName())
+		return &RateLimitExceededError{Resource: "core", Reset: time.Now().Add(time.Hour)}
+	})
+	if err := syncByOwner(context.Background(), repos, 1, syncRepo); err == nil || len(synced) != 1 {
+		t.Errorf("Expected the rate limit to stop the run after 1 repository, got %v (%v)", synced, err)
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/broken
+  - name: example/service
+    environments: [staging]
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	yes, no := true, false
+
+	testCases := []struct {
+		name            string
+		continueOnError bool
+		failFast        *bool
+		expectError     bool
+		expectResults   []RepoStatus
+	}{
+		{name: "Default", expectError: true, expectResults: []RepoStatus{StatusFailed}},
+		{name: "Fail fast", failFast: &yes, expectError: true, expectResults: []RepoStatus{StatusFailed}},
+		{name: "Continue on error", continueOnError: true, expectResults: []RepoStatus{StatusFailed, StatusSynced}},
+		{name: "No fail fast", failFast: &no, expectResults: []RepoStatus{StatusFailed, StatusSynced}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock, err := newMockServer(fixture)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			server := httptest.NewServer(mock.handler())
+			defer server.Close()
+			baseURL, _ := url.Parse(server.URL + "/")
+			ctx := context.Background()
+
+			report := &Report{}
+			run := &syncRun{
+				args: EnvArgs{
+					Repos:           "example/broken,example/service",
+					Order:           string(OrderAlpha),
+					PerPage:         defaultPerPage,
+					Concurrency:     1,
+					ContinueOnError: tc.continueOnError,
+					FailFast:        tc.failFast,
+				},
+				client:  NewGitHubAPI(ctx, ClientOptions{Token: "mock", BaseURL: baseURL}),
+				targets: []syncTarget{{Type: Actions, Environment: "staging"}},
+				secrets: map[string]string{"TOKEN": "secret"},
+				report:  report,
+			}
+			err = run.run(ctx)
+			if (err != nil) != tc.expectError {
+				t.Errorf("Expected error: %v, got: %v", tc.expectError, err)
+			}
+			var statuses []RepoStatus
+			for _, result := range report.Repositories {
+				statuses = append(statuses, result.Status)
+			}
+			if !reflect.DeepEqual(statuses, tc.expectResults) {
+				t.Errorf("Expected results %v, got %v", tc.expectResults, statuses)
+			}
+		})
+	}
+}
+
+func TestSyncByOwner(t *testing.T) {
+	repos := repositorySeq(
+		newRepository("alpha", "one"),
+		newRepository("beta", "one"),
+		newRepository("Alpha", "two"),
+		newRepository("beta", "two"),
+	)
+
+	var mu sync.Mutex
+	synced := make(map[string][]string)
+	owners := make(map[string]string)
+	report := &Report{}
+	err := syncByOwner(context.Background(), report.orderByOwner(repos), 1, func(ctx context.Context, repo *github.Repository) error {
+		mu.Lock()
+		defer mu.Unlock()
+		owner := strings.ToLower(repo.GetOwner().GetLogin())
+		synced[owner] = append(synced[owner], repo.GetName())
+		owners[repo.GetOwner().GetLogin()+"/"+repo.GetName()] = ownerFrom(ctx)
+		report.Add(RepoResult{Repository: repo.GetOwner().GetLogin() + "/" + repo.GetName()})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string][]string{"alpha": {"one", "two"}, "beta": {"one", "two"}}
+	if !reflect.DeepEqual(synced, expected) {
+		t.Errorf("Expected %v, got %v", expected, synced)
+	}
+	if expectedOwners := map[string]string{"alpha/one": "alpha", "Alpha/two": "alpha", "beta/one": "beta", "beta/two": "beta"}; !reflect.DeepEqual(owners, expectedOwners) {
+		t.Errorf("Expected the owners %v in the contexts, got %v", expectedOwners, owners)
+	}
+	report.sortResults()
+	var order []string
+	for _, result := range report.Repositories {
+		order = append(order, result.Repository)
+	}
+	if expectedOrder := []string{"alpha/one", "Alpha/two", "beta/one", "beta/two"}; !reflect.DeepEqual(order, expectedOrder) {
+		t.Errorf("Expected results in order %v, got %v", expectedOrder, order)
+	}
+
+	calls := 0
+	err = syncByOwner(context.Background(), repositorySeq(newRepository("alpha", "one"), newRepository("alpha", "two")), 1, func(context.Context, *github.Repository) error {
+		calls++
+		return errors.New("failed")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Expected the first error to stop the owner after 1 call, got %d calls (%v)", calls, err)
+	}
+
+	// With a concurrency of 2, both repositories of an owner are synced at the same time.
+	var running, maxRunning int
+	started := make(chan struct{})
+	err = syncByOwner(context.Background(), repos, 2, func(context.Context, *github.Repository) error {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		if running == 4 {
+			close(started)
+		}
+		mu.Unlock()
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+		}
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil || maxRunning != 4 {
+		t.Errorf("Expected all 4 repositories to be synced at the same time, got %d (%v)", maxRunning, err)
+	}
+
+	// Syncing starts before the selection is complete, as with streamed search results.
+	first := make(chan struct{})
+	streamed := func(yield func(*github.Repository, error) bool) {
+		if !yield(newRepository("alpha", "one"), nil) {
+			return
+		}
+		select {
+		case <-first:
+		case <-time.After(time.Second):
+			t.Error("Expected the first repository to be synced before the next one is selected")
+		}
+		yield(nil, errors.New("search failed"))
+	}
+	err = syncByOwner(context.Background(), streamed, 1, func(context.Context, *github.Repository) error {
+		close(first)
+		return nil
+	})
+	if err == nil || err.Error() != "search failed" {
+		t.Errorf("Expected the error of the selection, got: %v", err)
+	}
+}
+
+func TestOwnerRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/repos/alpha/") {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+	tracker := newRateLimitTracker()
+	client := &http.Client{Transport: &rateLimitTransport{base: http.DefaultTransport, tracker: tracker}}
+	for _, path := range []string{"/repos/alpha/service/actions/secrets", "/repos/beta/service/actions/secrets"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if until, ok := tracker.owner("Alpha").blockedUntil(); !ok || time.Until(until) < 50*time.Second {
+		t.Errorf("Expected alpha to be limited for a minute, got %v (%v)", until, ok)
+	}
+	if _, ok := tracker.owner("beta").blockedUntil(); ok {
+		t.Error("Expected beta not to be limited")
+	}
+
+	api := newRateLimitedGitHubAPI(nil, tracker, RateLimitFail, 0).(*rateLimitedGitHubAPI)
+	err := api.ensureRatelimits(withOwner(context.Background(), "alpha"))
+	var exceeded *RateLimitExceededError
+	if !errors.As(err, &exceeded) || exceeded.Resource != "secondary" {
+		t.Errorf("Expected the secondary rate limit of alpha to fail the request, got: %v", err)
+	}
+}
+
+// noVariablesClient is a GitHubActionClient of a GitHub instance without the variables API.
+type noVariablesClient struct {
+	GitHubActionClient
+	status int
+}
+
+func (c *noVariablesClient) PutRepoSecrets(context.Context, string, string, map[string]string) error {
+	return nil
+}
+
+func (c *noVariablesClient) PutRepoVariables(context.Context, string, string, map[string]string) error {
+	return fmt.Errorf("failed to list existing variables: %w", &github.ErrorResponse{Response: &http.Response{StatusCode: c.status}})
+}
+
+func TestProcessRepositoryWithoutVariablesAPI(t *testing.T) {
+	testCases := []struct {
+		name        string
+		status      int
+		secrets     map[string]string
+		expectError bool
+	}{
+		{name: "gone", status: http.StatusGone, expectError: false},
+		{name: "not found after secrets", status: http.StatusNotFound, secrets: map[string]string{"TOKEN": "secret"}, expectError: false},
+		{name: "not found without secrets", status: http.StatusNotFound, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &noVariablesClient{status: tc.status}
+			args := EnvArgs{Type: string(Actions)}
+			var out strings.Builder
+			ctx := withLogger(context.Background(), log.New(&out, "", 0))
+			_, err := processRepository(ctx, args, client, newRepository("example", "service"), tc.secrets, map[string]string{"HOST": "example.com"})
+			if (err != nil) != tc.expectError {
+				t.Errorf("Expected error: %v, got: %v", tc.expectError, err)
+			}
+			if !tc.expectError && !strings.Contains(out.String(), "Warning: not syncing variables to example/service") {
+				t.Errorf("Expected a warning in the injected logger, got:\n%s", out.String())
+			}
+		})
+	}
+}
+
+// failingStepsClient is a GitHubActionClient whose repository secrets and variables fail as configured.
+type failingStepsClient struct {
+	GitHubActionClient
+	failSecrets, failVariables bool
+	pruned                     []string
+	variables                  int
+}
+
+func (c *failingStepsClient) PutRepoSecrets(ctx context.Context, _, _ string, secrets map[string]string) error {
+	for _, name := range c.pruned {
+		recordKey(ctx, KeyResult{Kind: "secret", Name: name, Outcome: KeyDeleted})
+	}
+	if !c.failSecrets {
+		return nil
+	}
+	var errs []error
+	for _, name := range sortedKeys(secrets) {
+		errs = append(errs, failKey(ctx, "secret", name, errors.New("HTTP 422")))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *failingStepsClient) PutRepoVariables(ctx context.Context, _, _ string, variables map[string]string) error {
+	c.variables++
+	if c.failVariables {
+		return errors.New("HTTP 500")
+	}
+	recordCreated(ctx, "variable", variables)
+	return nil
+}
+
+func TestProcessRepositoryFailingStep(t *testing.T) {
+	testCases := []struct {
+		name           string
+		client         *failingStepsClient
+		expectedStatus RepoStatus
+		expectedErrors []string
+	}{
+		{
+			name:           "secrets fail",
+			client:         &failingStepsClient{failSecrets: true},
+			expectedStatus: StatusPartial,
+			expectedErrors: []string{"HTTP 422"},
+		},
+		{
+			name:           "secrets fail after a deletion",
+			client:         &failingStepsClient{failSecrets: true, failVariables: true, pruned: []string{"OLD_TOKEN"}},
+			expectedStatus: StatusPartial,
+			expectedErrors: []string{"HTTP 422", "HTTP 500"},
+		},
+		{
+			name:           "both fail",
+			client:         &failingStepsClient{failSecrets: true, failVariables: true},
+			expectedStatus: StatusFailed,
+			expectedErrors: []string{"HTTP 422", "HTTP 500"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := EnvArgs{Type: string(Actions)}
+			ctx := withLogger(context.Background(), log.New(io.Discard, "", 0))
+			result, err := processRepository(ctx, args, tc.client, newRepository("example", "service"), map[string]string{"TOKEN": "secret"}, map[string]string{"HOST": "example.com"})
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if tc.client.variables != 1 {
+				t.Errorf("Expected the variables to be synced despite the failed secrets, got %d calls", tc.client.variables)
+			}
+			if result.Status != tc.expectedStatus {
+				t.Errorf("Expected status %s, got %s", tc.expectedStatus, result.Status)
+			}
+			for _, expected := range tc.expectedErrors {
+				if !strings.Contains(err.Error(), expected) || !strings.Contains(result.Error, expected) {
+					t.Errorf("Expected the error to contain %q, got %q", expected, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSkipKinds(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+    secrets: [OLD_TOKEN]
+    variables:
+      OLD_HOST: example.com
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		args     EnvArgs
+		expected []KeyResult
+	}{
+		{
+			name: "Skip secrets",
+			args: EnvArgs{SkipSecrets: true},
+			expected: []KeyResult{
+				{Kind: "variable", Name: "HOST", Outcome: KeyCreated},
+				{Kind: "variable", Name: "OLD_HOST", Outcome: KeyDeleted},
+			},
+		},
+		{
+			name: "Skip variables",
+			args: EnvArgs{SkipVariables: true},
+			expected: []KeyResult{
+				{Kind: "secret", Name: "OLD_TOKEN", Outcome: KeyDeleted},
+				{Kind: "secret", Name: "TOKEN", Outcome: KeyCreated},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock, err := newMockServer(fixture)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			server := httptest.NewServer(mock.handler())
+			defer server.Close()
+			baseURL, _ := url.Parse(server.URL + "/")
+			ctx := withLogger(context.Background(), log.New(io.Discard, "", 0))
+			client := NewGitHubAPI(ctx, ClientOptions{Token: "mock", BaseURL: baseURL})
+
+			// Neither writing nor pruning the skipped kind, even with an empty prune allowed.
+			args := tc.args
+			args.Type, args.Prune, args.AllowEmptyPrune = string(Actions), true, true
+			secrets, variables, err := prepareValues(args, map[string]string{"TOKEN": "secret"}, map[string]string{"HOST": "example.org"})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			result, err := processRepository(ctx, args, client, newRepository("example", "service"), secrets, variables)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Keys, tc.expected) {
+				t.Errorf("Expected keys %v, got: %v", tc.expected, result.Keys)
+			}
+		})
+	}
+}
+
+func TestAllowEmptyPrune(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+    secrets: [OLD_TOKEN]
+    variables:
+      HOST: example.com
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mock, err := newMockServer(fixture)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server := httptest.NewServer(mock.handler())
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+	client := NewGitHubAPI(context.Background(), ClientOptions{Token: "mock", BaseURL: baseURL})
+	repo := newRepository("example", "service")
+
+	args := EnvArgs{Type: string(Actions), Prune: true}
+	result, err := processRepository(context.Background(), args, client, repo, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Keys) != 0 {
+		t.Errorf("Expected empty mappings to be skipped, got: %v", result.Keys)
+	}
+
+	args = EnvArgs{Type: string(Actions), Prune: true, AllowEmptyPrune: true, SkipVariables: true}
+	result, err = processRepository(context.Background(), args, client, repo, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []KeyResult{{Kind: "secret", Name: "OLD_TOKEN", Outcome: KeyDeleted}}
+	if !reflect.DeepEqual(result.Keys, expected) {
+		t.Errorf("Expected keys %v, got: %v", expected, result.Keys)
+	}
+
+	// An empty kind is left alone while the other one is synced.
+	args = EnvArgs{Type: string(Actions), Prune: true, AllowEmptyPrune: true}
+	result, err = processRepository(context.Background(), args, client, repo, map[string]string{"TOKEN": "secret"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = []KeyResult{{Kind: "secret", Name: "TOKEN", Outcome: KeyCreated}}
+	if !reflect.DeepEqual(result.Keys, expected) {
+		t.Errorf("Expected keys %v, got: %v", expected, result.Keys)
+	}
+}
+
+func TestDeleteKeys(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+    secrets: [OLD_TOKEN, TOKEN, DO_NOT_TOUCH_TOKEN]
+    variables:
+      OLD_HOST: old.example.com
+      HOST: example.com
+  - name: example/app
+    secrets: [OLD_TOKEN, TOKEN]
+    variables:
+      HOST: example.com
+  - name: example/legacy
+    secrets: [OLD_TOKEN, SYNC_OLD_TOKEN, TOKEN]
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mock, err := newMockServer(fixture)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server := httptest.NewServer(mock.handler())
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+	client := NewGitHubAPI(context.Background(), ClientOptions{Token: "mock", BaseURL: baseURL})
+
+	protect, _ := parseNamePatterns("DO_NOT_TOUCH_*")
+	ctx := withPrunePolicy(context.Background(), &prunePolicy{protect: protect, managedPrefix: "SYNC_"})
+	args := EnvArgs{Type: string(Actions), Delete: "old_token,OLD_HOST,DO_NOT_TOUCH_TOKEN"}
+	result, err := processRepository(ctx, args, client, newRepository("example", "service"), nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []KeyResult{
+		{Kind: "secret", Name: "OLD_TOKEN", Outcome: KeyDeleted},
+		{Kind: "variable", Name: "OLD_HOST", Outcome: KeyDeleted},
+	}
+	if !reflect.DeepEqual(result.Keys, expected) {
+		t.Errorf("Expected keys %v, got: %v", expected, result.Keys)
+	}
+
+	// Keys marked with the sentinel are only deleted for their kind.
+	args = EnvArgs{Type: string(Actions)}
+	result, err = processRepository(ctx, args, client, newRepository("example", "service"), map[string]string{"HOST": deleteSentinel, "TOKEN": deleteSentinel}, map[string]string{"HOST": "example.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = []KeyResult{
+		{Kind: "secret", Name: "TOKEN", Outcome: KeyDeleted},
+		{Kind: "variable", Name: "HOST", Outcome: KeyUnchanged},
+	}
+	if !reflect.DeepEqual(result.Keys, expected) {
+		t.Errorf("Expected keys %v, got: %v", expected, result.Keys)
+	}
+
+	// With prune, keys marked with the sentinel don't count as values to sync.
+	args = EnvArgs{Type: string(Actions), Prune: true}
+	if _, _, err := prepareValues(args, map[string]string{"OLD_TOKEN": deleteSentinel}, nil); err == nil {
+		t.Error("Expected error for prune with only keys marked for deletion, got nil")
+	}
+	result, err = processRepository(context.Background(), args, client, newRepository("example", "app"), map[string]string{"OLD_TOKEN": deleteSentinel}, map[string]string{"HOST": "example.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = []KeyResult{
+		{Kind: "secret", Name: "OLD_TOKEN", Outcome: KeyDeleted},
+		{Kind: "variable", Name: "HOST", Outcome: KeyUnchanged},
+	}
+	if !reflect.DeepEqual(result.Keys, expected) {
+		t.Errorf("Expected keys %v, got: %v", expected, result.Keys)
+	}
+
+	// With prune, keys named by delete are deleted although they lack the managed prefix.
+	args = EnvArgs{Type: string(Actions), Prune: true, Delete: "old_token"}
+	result, err = processRepository(ctx, args, client, newRepository("example", "legacy"), map[string]string{"SYNC_TOKEN": "secret"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = []KeyResult{
+		{Kind: "secret", Name: "OLD_TOKEN", Outcome: KeyDeleted},
+		{Kind: "secret", Name: "SYNC_OLD_TOKEN", Outcome: KeyDeleted},
+		{Kind: "secret", Name: "SYNC_TOKEN", Outcome: KeyCreated},
+	}
+	if !reflect.DeepEqual(result.Keys, expected) {
+		t.Errorf("Expected keys %v, got: %v", expected, result.Keys)
+	}
+
+	for _, invalid := range []EnvArgs{
+		{Delete: "token"},
+		{Delete: "DO_NOT_TOUCH_TOKEN", PruneProtect: "do_not_touch_*"},
+		{Delete: "OLD-TOKEN"},
+	} {
+		if _, _, err := prepareValues(invalid, map[string]string{"TOKEN": "secret"}, nil); err == nil {
+			t.Errorf("Expected error for %+v, got nil", invalid)
+		}
+	}
+}
+
+func TestCreateEnvironment(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dry run %v", dryRun), func(t *testing.T) {
+			fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+    environments: [staging]
+`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			mock, err := newMockServer(fixture)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			server := httptest.NewServer(mock.handler())
+			defer server.Close()
+			baseURL, _ := url.Parse(server.URL + "/")
+			client := NewGitHubAPI(context.Background(), ClientOptions{Token: "mock", BaseURL: baseURL, DryRunEnabled: dryRun})
+			repo := newRepository("example", "service")
+
+			args := EnvArgs{Type: string(Actions), Environment: "production", DryRun: dryRun}
+			_, err = processRepository(context.Background(), args, client, repo, map[string]string{"TOKEN": "secret"}, nil)
+			if expected := "environment production doesn't exist in example/service"; err == nil || !strings.HasPrefix(err.Error(), expected) {
+				t.Fatalf("Expected error starting with %q, got: %v", expected, err)
+			}
+
+			args.SkipMissingEnv = true
+			result, err := processRepository(context.Background(), args, client, repo, map[string]string{"TOKEN": "secret"}, nil)
+			if err != nil || result.Status != StatusSkipped {
+				t.Errorf("Expected the missing environment to be skipped, got: %s %v", result.Status, err)
+			}
+
+			args.CreateEnvironment = true
+			result, err = processRepository(context.Background(), args, client, repo, map[string]string{"TOKEN": "secret"}, map[string]string{"HOST": "example.com"})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			expected := []KeyResult{
+				{Kind: "secret", Name: "TOKEN", Outcome: KeyCreated},
+				{Kind: "variable", Name: "HOST", Outcome: KeyCreated},
+			}
+			if result.Status != StatusSynced || !reflect.DeepEqual(result.Keys, expected) {
+				t.Errorf("Expected synced keys %v, got: %s %v", expected, result.Status, result.Keys)
+			}
+		})
+	}
+}
+
+func TestHealthcheck(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusUnauthorized} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(status)
+				fmt.Fprint(w, `{"message":"Bad credentials"}`)
+			}))
+			defer server.Close()
+			client := github.NewClient(nil)
+			client.BaseURL, _ = url.Parse(server.URL + "/")
+
+			var out strings.Builder
+			healthy, err := runHealthcheck(context.Background(), newGitHubAPI(client, false, defaultPerPage), "ghs_example", client.BaseURL.String(), &out)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var health Health
+			if err := json.Unmarshal([]byte(out.String()), &health); err != nil {
+				t.Fatalf("Expected JSON output, got %q: %v", out.String(), err)
+			}
+			expected := status == http.StatusOK
+			if healthy != expected || health.Healthy != expected || health.Auth.OK != expected {
+				t.Errorf("Expected healthy: %v, got %v: %s", expected, healthy, out.String())
+			}
+			if health.APIURL != client.BaseURL.String() || health.Auth.TokenType != "GitHub App installation token" || health.Build.GoVersion == "" {
+				t.Errorf("Unexpected health: %s", out.String())
+			}
+		})
+	}
+
+	var out strings.Builder
+	if err := runVersion(&VersionCmd{JSON: true}, &out); err != nil || !json.Valid([]byte(out.String())) {
+		t.Errorf("Expected the version as JSON, got %q (%v)", out.String(), err)
+	}
+}
+
+func TestWhoami(t *testing.T) {
+	testCases := []struct {
+		name       string
+		token      string
+		scopes     []string
+		userStatus int
+		expected   []string
+	}{
+		{
+			name:       "classic token",
+			token:      "ghp_example",
+			scopes:     []string{"repo, workflow"},
+			userStatus: http.StatusOK,
+			expected:   []string{"Authenticated as octocat (User) with a classic personal access token", "Scopes: repo, workflow", "Rate limit: 4990 of 5000"},
+		},
+		{
+			name:       "installation token",
+			token:      "ghs_example",
+			userStatus: http.StatusForbidden,
+			expected:   []string{"Authenticated with a GitHub App installation token", "Permissions: not reported"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /rate_limit", func(w http.ResponseWriter, _ *http.Request) {
+				if tc.scopes != nil {
+					w.Header()[oauthScopesHeader] = tc.scopes
+				}
+				fmt.Fprint(w, `{"resources":{"core":{"limit":5000,"remaining":4990,"reset":1893456000}}}`)
+			})
+			mux.HandleFunc("GET /user", func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tc.userStatus)
+				fmt.Fprint(w, `{"login":"octocat","type":"User","message":"Resource not accessible by integration"}`)
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+			client := github.NewClient(nil)
+			client.BaseURL, _ = url.Parse(server.URL + "/")
+
+			api := newGitHubAPI(client, false, defaultPerPage)
+			identity, err := whoami(context.Background(), api, readRateLimits(context.Background(), api, newRateLimitTracker()), tc.token)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var out strings.Builder
+			logIdentity(log.New(&out, "", 0), identity)
+			for _, line := range tc.expected {
+				if !strings.Contains(out.String(), line) {
+					t.Errorf("Expected %q in:\n%s", line, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	dir := t.TempDir()
+	cool := newCooldown(dir, "ghp_example")
+	ctx := context.Background()
+	if err := cool.await(ctx, RateLimitFail, 0); err != nil {
+		t.Fatalf("Expected no cooldown without an earlier run, got: %v", err)
+	}
+
+	retryAfter := time.Hour
+	api := &retryableGitHubAPI{
+		backoffOptions: []backoff.RetryOption{backoff.WithMaxTries(1)},
+		cooldown:       cool,
+	}
+	err := api.retry(ctx, "PutRepoSecret", func() (bool, error) {
+		return true, classifyRetry(withRetryAfter(&github.AbuseRateLimitError{RetryAfter: &retryAfter}))
+	})
+	if err == nil {
+		t.Fatal("Expected the secondary rate limit error")
+	}
+
+	var exceededErr *RateLimitExceededError
+	if err := newCooldown(dir, "ghp_example").await(ctx, RateLimitWait, time.Minute); !errors.As(err, &exceededErr) || time.Until(exceededErr.Reset) < 59*time.Minute {
+		t.Errorf("Expected the recorded cooldown to exceed the maximum wait, got: %v", err)
+	}
+	if err := newCooldown(dir, "ghp_other").await(ctx, RateLimitFail, 0); err != nil {
+		t.Errorf("Expected other tokens to have no cooldown, got: %v", err)
+	}
+}
+
+func TestCustomTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"resources":{}}`)
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rootCAs, err := loadRootCAs(bundle)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name        string
+		opts        ClientOptions
+		expectError bool
+	}{
+		{name: "system roots", opts: ClientOptions{}, expectError: true},
+		{name: "custom roots", opts: ClientOptions{RootCAs: rootCAs}},
+		{name: "insecure", opts: ClientOptions{InsecureSkipTLSVerify: true}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.Token, tc.opts.BaseURL = "ghp_example", baseURL
+			_, _, err := NewGitHubAPI(context.Background(), tc.opts).Ratelimits(context.Background())
+			if (err != nil) != tc.expectError {
+				t.Errorf("Expected error: %v, got: %v", tc.expectError, err)
+			}
+		})
+	}
+
+	if _, err := loadRootCAs(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("Expected an error for a missing bundle")
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	testCases := []struct {
+		name          string
+		opts          ClientOptions
+		expectDisable bool
+	}{
+		{name: "zero value", opts: ClientOptions{}},
+		{name: "interval", opts: ClientOptions{KeepAlive: 30 * time.Second}},
+		{name: "disabled", opts: ClientOptions{KeepAlive: 30 * time.Second, DisableKeepAlives: true}, expectDisable: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := newHTTPClient(tc.opts, newAPIUsage()).Transport.(*usageTransport).base.(*rateLimitTransport).base.(*http.Transport)
+			if rt.DisableKeepAlives != tc.expectDisable {
+				t.Errorf("Expected DisableKeepAlives %v, got %v", tc.expectDisable, rt.DisableKeepAlives)
+			}
+		})
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/template
+    environments: [staging, production]
+    variables:
+      LOG_LEVEL: info
+      REGION: eu
+  - name: example/new-service
+  - name: example/provisioned
+    secrets: [TOKEN]
+    variables:
+      REGION: ap
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mock, err := newMockServer(fixture)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server := httptest.NewServer(mock.handler())
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+	ctx := context.Background()
+	client := NewGitHubAPI(ctx, ClientOptions{Token: "mock", BaseURL: baseURL})
+
+	args := EnvArgs{Query: "org:example", Order: string(OrderAlpha), PerPage: defaultPerPage}
+	secrets := map[string]string{"TOKEN": "secret"}
+	variables := map[string]string{"REGION": "us"}
+	report := &Report{}
+	if err := runBootstrap(ctx, &BootstrapCmd{Template: "example/template"}, args, client, secrets, variables, report); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	repo := mock.repos["example/new-service"]
+	if envs := sortedKeys(repo.scopes); !reflect.DeepEqual(envs, []string{"actions", "codespaces", "dependabot", "env:production", "env:staging"}) {
+		t.Errorf("Expected the environments of the template, got scopes %v", envs)
+	}
+	if expected := map[string]string{"LOG_LEVEL": "info", "REGION": "us"}; !reflect.DeepEqual(repo.scopes["actions"].variables, expected) {
+		t.Errorf("Expected variables %v, got %v", expected, repo.scopes["actions"].variables)
+	}
+	if names := sortedKeys(repo.scopes["actions"].secrets); !reflect.DeepEqual(names, []string{"TOKEN"}) {
+		t.Errorf("Expected secrets [TOKEN], got %v", names)
+	}
+	if template := mock.repos["example/template"]; len(template.scopes["actions"].secrets) != 0 {
+		t.Errorf("Expected the template to be left alone, got secrets %v", sortedKeys(template.scopes["actions"].secrets))
+	}
+	if expected := map[string]string{"LOG_LEVEL": "info", "REGION": "ap"}; !reflect.DeepEqual(mock.repos["example/provisioned"].scopes["actions"].variables, expected) {
+		t.Errorf("Expected existing variables to be kept, got %v", mock.repos["example/provisioned"].scopes["actions"].variables)
+	}
+
+	report.sortResults()
+	expectedResults := []RepoResult{
+		{Repository: "example/new-service", Type: Actions, Status: StatusSynced, Keys: []KeyResult{
+			{Kind: "secret", Name: "TOKEN", Outcome: KeyCreated},
+			{Kind: "variable", Name: "LOG_LEVEL", Outcome: KeyCreated},
+			{Kind: "variable", Name: "REGION", Outcome: KeyCreated},
+		}},
+		{Repository: "example/provisioned", Type: Actions, Status: StatusSynced, Keys: []KeyResult{
+			{Kind: "variable", Name: "LOG_LEVEL", Outcome: KeyCreated},
+		}},
+	}
+	if !reflect.DeepEqual(report.Repositories, expectedResults) {
+		t.Errorf("Expected results %+v, got %+v", expectedResults, report.Repositories)
+	}
+
+	// Skipped kinds are neither listed nor written.
+	report = &Report{}
+	args.SkipSecrets = true
+	secrets["NEW_TOKEN"] = "secret"
+	if err := runBootstrap(ctx, &BootstrapCmd{Template: "example/template"}, args, client, secrets, variables, report); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, result := range report.Repositories {
+		if result.Status != StatusUnchanged || len(result.Keys) != 0 {
+			t.Errorf("Expected %s to be unchanged, got %+v", result.Repository, result)
+		}
+	}
+}
+
+func TestMetadataVariables(t *testing.T) {
+	t.Setenv("GITHUB_REPOSITORY", "example/platform")
+	repo := newRepository("example", "service")
+	repo.DefaultBranch = github.Ptr("main")
+
+	variables := withMetadataVariables(repo, map[string]string{"HOST": "example.com", "SYNC_SOURCE": "manual"})
+	expected := map[string]string{
+		"HOST":           "example.com",
+		"REPO_NAME":      "service",
+		"REPO_OWNER":     "example",
+		"DEFAULT_BRANCH": "main",
+		"SYNC_SOURCE":    "manual",
+	}
+	if !reflect.DeepEqual(variables, expected) {
+		t.Errorf("Expected %v, got %v", expected, variables)
+	}
+
+	t.Setenv("GITHUB_REPOSITORY", "")
+	variables = metadataVariables(newRepository("example", "empty"))
+	if _, ok := variables["DEFAULT_BRANCH"]; ok || variables["SYNC_SOURCE"] != defaultSyncSource {
+		t.Errorf("Unexpected variables of an empty repository outside of Actions: %v", variables)
+	}
+}
+
+func TestSyncRepositoryMetadataVariables(t *testing.T) {
+	testCases := []struct {
+		name    string
+		targets []syncTarget
+		expect  []string
+	}{
+		{
+			name:    "Repository and environments",
+			targets: []syncTarget{{Type: Actions, Environment: "staging"}, {Type: Actions}, {Type: Actions, Environment: "production"}},
+			expect:  []string{"actions"},
+		},
+		{
+			name:    "Environments only",
+			targets: []syncTarget{{Type: Dependabot}, {Type: Actions, Environment: "staging"}, {Type: Actions, Environment: "production"}},
+			expect:  []string{"env:staging"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+    environments: [staging, production]
+`))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			mock, err := newMockServer(fixture)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			server := httptest.NewServer(mock.handler())
+			defer server.Close()
+			baseURL, _ := url.Parse(server.URL + "/")
+			ctx := context.Background()
+
+			run := &syncRun{
+				args:      EnvArgs{PerPage: defaultPerPage, MetadataVars: true},
+				client:    NewGitHubAPI(ctx, ClientOptions{Token: "mock", BaseURL: baseURL}),
+				targets:   tc.targets,
+				variables: map[string]string{"HOST": "example.com"},
+				report:    &Report{},
+			}
+			repo := mock.repos["example/service"].repo
+			if err := run.syncRepository(ctx, repo); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var scopes []string
+			for _, scope := range sortedKeys(mock.repos["example/service"].scopes) {
+				if _, ok := mock.repos["example/service"].scopes[scope].variables["REPO_NAME"]; ok {
+					scopes = append(scopes, scope)
+				}
+			}
+			if !reflect.DeepEqual(scopes, tc.expect) {
+				t.Errorf("Expected the metadata variables in %v, got %v", tc.expect, scopes)
+			}
+		})
+	}
+}
+
+func TestProcessOrganization(t *testing.T) {
+	publicKey, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu      sync.Mutex
+		puts    = make(map[string]github.EncryptedSecret)
+		deletes []string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /orgs/example/codespaces/secrets/public-key", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, `{"key_id":"key","key":%q}`, base64.StdEncoding.EncodeToString(publicKey[:]))
+	})
+	mux.HandleFunc("GET /orgs/example/codespaces/secrets", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"total_count":3,"secrets":[{"name":"TOKEN","visibility":"all"},{"name":"DEPLOY_KEY","visibility":"selected"},{"name":"OLD_TOKEN","visibility":"private"}]}`)
+	})
+	mux.HandleFunc("GET /orgs/example/codespaces/secrets/DEPLOY_KEY/repositories", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"total_count":2,"repositories":[{"id":1,"name":"service-a"},{"id":3,"name":"service-c"}]}`)
+	})
+	mux.HandleFunc("GET /repos/example/{repo}", func(w http.ResponseWriter, r *http.Request) {
+		ids := map[string]int{"service-a": 1, "service-b": 2}
+		fmt.Fprintf(w, `{"id":%d,"name":%q}`, ids[r.PathValue("repo")], r.PathValue("repo"))
+	})
+	mux.HandleFunc("PUT /orgs/example/codespaces/secrets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var secret github.EncryptedSecret
+		if err := json.NewDecoder(r.Body).Decode(&secret); err != nil {
+			t.Errorf("Failed to decode secret: %v", err)
+		}
+		mu.Lock()
+		puts[r.PathValue("name")] = secret
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /orgs/example/codespaces/secrets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		deletes = append(deletes, r.PathValue("name"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	var out strings.Builder
+	ctx := withLogger(context.Background(), log.New(&out, "", 0))
+	api := newGitHubAPI(client, false, defaultPerPage)
+	args := EnvArgs{Organization: "example", Type: string(Codespaces), Prune: true, OrgSecretVisibility: "DEPLOY_KEY=selected:service-a,service-b"}
+	targets := []syncTarget{{Type: Codespaces}}
+	secrets := map[string]string{"TOKEN": "secret", "PASSWORD": "secret", "DEPLOY_KEY": "secret"}
+	report := &Report{}
+	if err := syncOrganization(ctx, args, api, targets, secrets, report); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result := report.Repositories[0]; result.Status != StatusSynced || result.Target() != "example (codespaces)" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	expected := map[string]github.EncryptedSecret{
+		"TOKEN":      {Visibility: "all"},
+		"PASSWORD":   {Visibility: defaultOrgSecretVisibility},
+		"DEPLOY_KEY": {Visibility: "selected", SelectedRepositoryIDs: github.SelectedRepoIDs{1, 2}},
+	}
+	for name, secret := range puts {
+		secret.KeyID, secret.EncryptedValue = "", ""
+		puts[name] = secret
+	}
+	if !reflect.DeepEqual(puts, expected) {
+		t.Errorf("Expected puts %v, got %v", expected, puts)
+	}
+	if !reflect.DeepEqual(deletes, []string{"OLD_TOKEN"}) {
+		t.Errorf("Expected OLD_TOKEN to be pruned, got %v", deletes)
+	}
+	for _, line := range []string{"Adding repositories service-b to Codespaces secret 'DEPLOY_KEY'", "Removing repositories service-c from Codespaces secret 'DEPLOY_KEY'"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected %q in:\n%s", line, out.String())
+		}
+	}
+
+	for _, visibility := range []string{"DEPLOY_KEY=selected", "DEPLOY_KEY=all:service-a", "DEPLOY_KEY=public", "UNKNOWN=all"} {
+		if _, err := parseOrgSecretAccess(visibility, secrets); err == nil {
+			t.Errorf("Expected an error for %s", visibility)
+		}
+	}
+	if err := syncOrganization(ctx, EnvArgs{Organization: "example"}, nil, []syncTarget{{Type: Actions}}, secrets, report); err == nil {
+		t.Error("Expected an error for type actions")
+	}
+}
+
+func TestEnterpriseURLs(t *testing.T) {
+	testCases := []struct {
+		name, baseURL, uploadURL     string
+		expectedBase, expectedUpload string
+		expectError                  bool
+	}{
+		{name: "Host", baseURL: "https://github.example.com", expectedBase: "https://github.example.com/api/v3/", expectedUpload: "https://github.example.com/api/uploads/"},
+		{name: "API path", baseURL: "https://github.example.com/api/v3/", expectedBase: "https://github.example.com/api/v3/", expectedUpload: "https://github.example.com/api/uploads/"},
+		{name: "Upload URL", baseURL: "https://github.example.com", uploadURL: "https://uploads.example.com", expectedBase: "https://github.example.com/api/v3/", expectedUpload: "https://uploads.example.com/api/uploads/"},
+		{name: "GHE.com", baseURL: "https://octocorp.ghe.com", expectedBase: "https://api.octocorp.ghe.com/", expectedUpload: "https://api.octocorp.ghe.com/"},
+		{name: "GHE.com API", baseURL: "https://api.octocorp.ghe.com/", expectedBase: "https://api.octocorp.ghe.com/", expectedUpload: "https://api.octocorp.ghe.com/"},
+		{name: "Missing scheme", baseURL: "github.example.com", expectError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			base, upload, err := enterpriseURLs(tc.baseURL, tc.uploadURL)
+			if tc.expectError {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if base.String() != tc.expectedBase || upload.String() != tc.expectedUpload {
+				t.Errorf("Expected %s and %s, got %s and %s", tc.expectedBase, tc.expectedUpload, base, upload)
+			}
+		})
+	}
+
+	// The decorators send all requests, including the rate limit checks, to the instance.
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/api/v3/rate_limit":
+			fmt.Fprint(w, `{"resources":{"core":{"limit":5000,"remaining":4990,"reset":1893456000}}}`)
+		default:
+			fmt.Fprint(w, `{"id":1,"name":"service"}`)
+		}
+	}))
+	defer server.Close()
+	base, upload, err := enterpriseURLs(server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewGitHubAPI(context.Background(), ClientOptions{Token: "example", MaxRetries: 1, RateLimitCheckEnabled: true, BaseURL: base, UploadURL: upload})
+	if _, _, err := client.GetRepository(context.Background(), "example", "service"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(paths, []string{"/api/v3/rate_limit", "/api/v3/repos/example/service"}) {
+		t.Errorf("Unexpected requests: %v", paths)
+	}
+}
+
+func TestRunnerAPIURL(t *testing.T) {
+	testCases := []struct {
+		name, apiURL, serverURL string
+		expected                string
+	}{
+		{name: "Outside of Actions"},
+		{name: "github.com", apiURL: "https://api.github.com", serverURL: "https://github.com"},
+		{name: "GHES", apiURL: "https://github.example.com/api/v3", serverURL: "https://github.example.com", expected: "https://github.example.com/api/v3"},
+		{name: "GHES without API URL", serverURL: "https://github.example.com/", expected: "https://github.example.com"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("GITHUB_API_URL", tc.apiURL)
+			t.Setenv("GITHUB_SERVER_URL", tc.serverURL)
+			if apiURL, _ := runnerAPIURL(); apiURL != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, apiURL)
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+  - name: example/docs
+    permission: write
+  - name: example/website
+    permission: maintain
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock, err := newMockServer(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(mock.handler())
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+	client := NewGitHubAPI(context.Background(), ClientOptions{Token: "mock", BaseURL: baseURL})
+	ctx := withLogger(context.Background(), log.New(io.Discard, "", 0))
+
+	repos := []*github.Repository{newRepository("example", "service"), newRepository("example", "docs"), newRepository("example", "website"), newRepository("example", "missing")}
+	err = preflight(ctx, client, repos)
+	expected := "preflight failed, the token lacks admin access to 3 of 4 repositories: example/docs, example/website, example/missing (not found)"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got: %v", expected, err)
+	}
+	if err := preflight(ctx, client, []*github.Repository{newRepository("example", "service")}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+// membershipClient reports the membership of the token in an organization.
+type membershipClient struct {
+	GitHubActionClient
+	membership *github.Membership
+	err        error
+	synced     bool
+}
+
+func (c *membershipClient) GetOrgMembership(context.Context, string) (*github.Membership, *github.Response, error) {
+	return c.membership, nil, c.err
+}
+
+func (c *membershipClient) PutOrgCodespacesSecrets(context.Context, string, map[string]string, map[string]OrgSecretAccess) error {
+	c.synced = true
+	return nil
+}
+
+func TestPreflightOrganization(t *testing.T) {
+	testCases := []struct {
+		name        string
+		membership  *github.Membership
+		err         error
+		expectError string
+	}{
+		{
+			name:       "Admin",
+			membership: &github.Membership{State: github.Ptr("active"), Role: github.Ptr("admin")},
+		},
+		{
+			name:        "Member",
+			membership:  &github.Membership{State: github.Ptr("active"), Role: github.Ptr("member")},
+			expectError: "preflight failed, the token lacks admin access to the organization example",
+		},
+		{
+			name:        "Pending invitation",
+			membership:  &github.Membership{State: github.Ptr("pending"), Role: github.Ptr("admin")},
+			expectError: "preflight failed, the token lacks admin access to the organization example",
+		},
+		{
+			name:        "Not a member",
+			err:         &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}},
+			expectError: "preflight failed, the token isn't a member of the organization example",
+		},
+		{
+			name: "Membership not readable",
+			err:  &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusForbidden}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &membershipClient{membership: tc.membership, err: tc.err}
+			args := EnvArgs{Organization: "example", Preflight: true}
+			ctx := withLogger(context.Background(), log.New(io.Discard, "", 0))
+			err := syncOrganization(ctx, args, client, []syncTarget{{Type: Codespaces}}, map[string]string{"TOKEN": "secret"}, &Report{})
+			if tc.expectError == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tc.expectError != "" && (err == nil || err.Error() != tc.expectError) {
+				t.Errorf("Expected %q, got: %v", tc.expectError, err)
+			}
+			if client.synced != (tc.expectError == "") {
+				t.Errorf("Expected secrets to be synced only after a passed preflight, synced: %v", client.synced)
+			}
+		})
+	}
+}
+
+func TestWriteChanges(t *testing.T) {
+	report := &Report{DryRun: true}
+	report.Add(RepoResult{Repository: "example/service", Type: Actions, Environment: "production", Status: StatusSynced, Keys: []KeyResult{
+		{Kind: "secret", Name: "TOKEN", Outcome: KeyCreated},
+		{Kind: "variable", Name: "HOST", Outcome: KeyUnchanged},
+		{Kind: "variable", Name: "LEGACY", Outcome: KeyDeleted},
+	}})
+	report.Add(RepoResult{Repository: "example/docs", Status: StatusUnchanged})
+
+	var out bytes.Buffer
+	if err := report.WriteChanges(&out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var doc struct {
+		DryRun  bool     `json:"dry_run"`
+		Changes []Change `json:"changes"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid JSON %s: %v", out.String(), err)
+	}
+	expected := []Change{
+		{Repository: "example/service", Type: Actions, Environment: "production", Kind: "secret", Key: "TOKEN", Action: KeyCreated},
+		{Repository: "example/service", Type: Actions, Environment: "production", Kind: "variable", Key: "LEGACY", Action: KeyDeleted},
+	}
+	if !doc.DryRun || !reflect.DeepEqual(doc.Changes, expected) {
+		t.Errorf("Expected %v, got %s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := (&Report{}).WriteChanges(&out); err != nil || !strings.Contains(out.String(), `"changes": []`) {
+		t.Errorf("Expected an empty list of changes, got %s (%v)", out.String(), err)
+	}
+}
+
+func TestReportOutputs(t *testing.T) {
+	report := &Report{}
+	report.Add(RepoResult{Repository: "example/service", Type: Actions, Status: StatusSynced, Keys: []KeyResult{
+		{Kind: "secret", Name: "TOKEN", Outcome: KeyCreated},
+		{Kind: "variable", Name: "HOST", Outcome: KeyUpdated},
+		{Kind: "variable", Name: "REGION", Outcome: KeyUnchanged},
+		{Kind: "variable", Name: "LEGACY", Outcome: KeyDeleted},
+	}})
+	report.Add(RepoResult{Repository: "example/service", Type: Actions, Environment: "production", Status: StatusPartial, Keys: []KeyResult{
+		{Kind: "secret", Name: "TOKEN", Outcome: KeyUpdated},
+		{Kind: "secret", Name: "PASSWORD", Outcome: KeyFailed},
+	}})
+	report.Add(RepoResult{Repository: "example/docs", Type: Actions, Status: StatusSkipped, Reason: "archived"})
+
+	outputs, err := report.Outputs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"repos_processed":  "1",
+		"secrets_synced":   "2",
+		"variables_synced": "1",
+		"deleted_count":    "1",
+		"failed_repos":     `["example/service"]`,
+		"repos_synced":     "1",
+		"repos_unchanged":  "0",
+		"repos_skipped":    "1",
+		"repos_partial":    "1",
+		"repos_failed":     "0",
+	}
+	if !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("Expected %v, got %v", expected, outputs)
+	}
+	if outputs, _ := (&Report{}).Outputs(); outputs["failed_repos"] != "[]" {
+		t.Errorf("Expected an empty array, got %s", outputs["failed_repos"])
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	report := &Report{}
+	report.Add(RepoResult{Repository: "example/service", Type: Actions, Status: StatusPartial, Keys: []KeyResult{
+		{Kind: "secret", Name: "TOKEN", Outcome: KeyCreated},
+		{Kind: "variable", Name: "HOST", Outcome: KeyFailed, Error: "HTTP 422: invalid\nvalue"},
+	}})
+	report.Add(RepoResult{Repository: "example/api", Type: Actions, Environment: "production", Status: StatusFailed, Error: "not found"})
+	report.Add(RepoResult{Repository: "example/docs", Type: Actions, Status: StatusSkipped, Reason: "insufficient permissions"})
+
+	var out strings.Builder
+	report.Annotate(&out)
+	expected := "::error title=Failed to sync variable HOST to example/service::HTTP 422: invalid%0Avalue\n" +
+		"::error title=Failed to sync example/api (environment production)::not found\n" +
+		"::warning title=Skipped example/docs::insufficient permissions\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestStepSummary(t *testing.T) {
+	report := &Report{}
+	report.Add(RepoResult{Repository: "example/service", Type: Actions, Status: StatusPartial, Error: "failed | twice\nreally", Keys: []KeyResult{
+		{Kind: "secret", Name: "TOKEN", Outcome: KeyCreated},
+		{Kind: "variable", Name: "HOST", Outcome: KeyUpdated},
+		{Kind: "variable", Name: "PORT", Outcome: KeyFailed},
+	}})
+	report.Add(RepoResult{Repository: "example/docs", Type: Actions, Status: StatusSkipped, Reason: "archived"})
+
+	path := filepath.Join(t.TempDir(), "summary.md")
+	t.Setenv("GITHUB_STEP_SUMMARY", path)
+	if err := appendStepSummary(report.Markdown()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"| Repository | Status | Created | Updated | Deleted | Failed |",
+		"| example/service | partial | 1 | 1 | 0 | 1 |",
+		"- **example/service**: failed \\| twice really",
+		"- example/docs skipped: archived",
+		"0 synced, 0 unchanged, 1 skipped, 1 partial, 0 failed",
+	} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("Expected %q in:\n%s", line, data)
+		}
+	}
+}
+
+func TestGroupLogs(t *testing.T) {
+	fixture, err := parseMockFixture([]byte(`
+repositories:
+  - name: example/service
+    variables:
+      HOST: old.example.com
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock, err := newMockServer(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(mock.handler())
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL + "/")
+	client := NewGitHubAPI(context.Background(), ClientOptions{Token: "mock", BaseURL: baseURL, DryRunEnabled: true})
+
+	var out strings.Builder
+	syncRepo := groupLogs(&out, func(ctx context.Context, repo *github.Repository) error {
+		loggerFrom(ctx).Printf("Processing %s\n", repo.GetName())
+		return client.PutRepoVariables(ctx, repo.GetOwner().GetLogin(), repo.GetName(), map[string]string{"HOST": "example.com"})
+	})
+	if err := syncRepo(context.Background(), newRepository("example", "service")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, line := range []string{"::group::example/service\n", "Processing service\n", "Would change variable 'HOST'", "::endgroup::\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected %q in:\n%s", line, out.String())
+		}
+	}
+	if !strings.HasPrefix(out.String(), "::group::") || !strings.HasSuffix(out.String(), "::endgroup::\n") {
+		t.Errorf("Expected the output to be enclosed by the group:\n%s", out.String())
+	}
+
+	// Debug messages, e.g. of retried requests, are part of the group.
+	t.Setenv("GITHUB_ACTIONS", "true")
+	out.Reset()
+	syncRepo = groupLogs(&out, func(ctx context.Context, repo *github.Repository) error {
+		debugf(ctx, "Retrying %s", repo.GetName())
+		return nil
+	})
+	if err := syncRepo(context.Background(), newRepository("example", "service")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "::group::example/service\n::debug::Retrying service\n::endgroup::\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func BenchmarkEncryptSecret(b *testing.B) {
+	publicKey, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	key := &github.PublicKey{KeyID: github.Ptr("key"), Key: github.Ptr(base64.StdEncoding.EncodeToString(publicKey[:]))}
+	value := strings.Repeat("x", 1024)
+	b.ResetTimer()
+	for range b.N {
+		if _, err := encryptSecretWithPublicKey(key, "TOKEN", value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkMockSync syncs to the repositories of a generated mock organization, covering the enumeration,
+// encryption, and HTTP of a run. Profile it with -cpuprofile to see where the time of large runs goes.
+func BenchmarkMockSync(b *testing.B) {
+	fixture := &MockFixture{}
+	for i := range 100 {
+		fixture.Repositories = append(fixture.Repositories, MockRepository{
+			Name:      fmt.Sprintf("example/service-%03d", i),
+			Secrets:   []string{"OLD_TOKEN"},
+			Variables: map[string]string{"HOST": "old.example.com"},
+		})
+	}
+	secrets := map[string]string{"TOKEN": "secret", "PASSWORD": "secret"}
+	variables := map[string]string{"HOST": "example.com", "REGION": "eu"}
+	args := EnvArgs{Type: string(Actions), Prune: true}
+	ctx := withLogger(context.Background(), log.New(io.Discard, "", 0))
+
+	for range b.N {
+		b.StopTimer()
+		mock, err := newMockServer(fixture)
+		if err != nil {
+			b.Fatal(err)
+		}
+		server := httptest.NewServer(mock.handler())
+		baseURL, _ := url.Parse(server.URL + "/")
+		client := NewGitHubAPI(ctx, ClientOptions{Token: "mock", BaseURL: baseURL})
+		b.StartTimer()
+
+		repos, err := client.SearchRepositories(ctx, "org:example")
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, repo := range repos {
+			if _, err := processRepository(ctx, args, client, repo, secrets, variables); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.StopTimer()
+		server.Close()
+		b.StartTimer()
+	}
+}
+
+func TestCheckLoopback(t *testing.T) {
+	testCases := []struct {
+		addr        string
+		expectError bool
+	}{
+		{addr: "localhost:6060"},
+		{addr: "127.0.0.1:6060"},
+		{addr: "[::1]:6060"},
+		{addr: ":6060", expectError: true},
+		{addr: "0.0.0.0:6060", expectError: true},
+		{addr: "192.168.1.10:6060", expectError: true},
+		{addr: "localhost", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.addr, func(t *testing.T) {
+			err := checkLoopback(tc.addr)
+			if (err != nil) != tc.expectError {
+				t.Errorf("Expected error: %v, got: %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func TestWriteChangesDestination(t *testing.T) {
+	report := &Report{}
+	report.Add(RepoResult{Repository: "example/service", Type: Actions, Status: StatusSynced, Keys: []KeyResult{
+		{Kind: "secret", Name: "TOKEN", Outcome: KeyCreated},
+	}})
+
+	testCases := []struct {
+		name       string
+		outputFile bool
+		actions    bool
+		expected   string
+	}{
+		{name: "Output file", outputFile: true, actions: true, expected: "file"},
+		{name: "GitHub Actions", actions: true, expected: "output"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			outputs := filepath.Join(dir, "outputs")
+			t.Setenv("GITHUB_OUTPUT", outputs)
+			if tc.actions {
+				t.Setenv("GITHUB_ACTIONS", "true")
+			}
+			args := EnvArgs{Output: outputJSON}
+			if tc.outputFile {
+				args.OutputFile = filepath.Join(dir, "changes.json")
+			}
+			if err := writeChanges(args, report); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			written, _ := os.ReadFile(args.OutputFile)
+			set, _ := os.ReadFile(outputs)
+			var got string
+			switch {
+			case strings.Contains(string(written), `"key": "TOKEN"`) && len(set) == 0:
+				got = "file"
+			case strings.HasPrefix(string(set), "changes<<") && strings.Contains(string(set), `"key": "TOKEN"`):
+				got = "output"
+			}
+			if got != tc.expected {
+				t.Errorf("Expected the changes in the %s, got file %q and outputs %q", tc.expected, written, set)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"maps"
+	"os"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// defaultSyncSource names the source of synced values outside of GitHub Actions.
+const defaultSyncSource = "sync-secrets-action"
+
+// metadataVariables returns the variables derived from repo, which shared workflows would otherwise compute
+// at runtime. SYNC_SOURCE names the repository whose workflow ran the sync.
+func metadataVariables(repo *github.Repository) map[string]string {
+	source := os.Getenv("GITHUB_REPOSITORY")
+	if source == "" {
+		source = defaultSyncSource
+	}
+	variables := map[string]string{
+		"REPO_NAME":   repo.GetName(),
+		"REPO_OWNER":  repo.GetOwner().GetLogin(),
+		"SYNC_SOURCE": source,
+	}
+	// Empty repositories have no default branch.
+	if branch := repo.GetDefaultBranch(); branch != "" {
+		variables["DEFAULT_BRANCH"] = branch
+	}
+	return variables
+}
+
+// withMetadataVariables returns variables extended by the metadata variables of repo.
+// Variables of the input take precedence, so a metadata variable can be overridden.
+func withMetadataVariables(repo *github.Repository, variables map[string]string) map[string]string {
+	merged := metadataVariables(repo)
+	maps.Copy(merged, variables)
+	return merged
+}
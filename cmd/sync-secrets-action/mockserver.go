@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+	"golang.org/x/crypto/nacl/box"
+	"gopkg.in/yaml.v3"
+)
+
+// MockFixture describes the repositories served by the mock server, e.g. in mock.yml:
+//
+//	repositories:
+//	  - name: myorg/service
+//	    topics: [docker]
+//	    environments: [staging, production]
+//	    secrets: [OLD_TOKEN]
+//	    variables:
+//	      HOST: example.com
+type MockFixture struct {
+	Repositories []MockRepository `yaml:"repositories"`
+}
+
+// MockRepository is a repository of the mock server with its existing Actions secrets and variables.
+type MockRepository struct {
+	// Name of the repository as owner/repo.
+	Name       string   `yaml:"name"`
+	Topics     []string `yaml:"topics"`
+	Visibility string   `yaml:"visibility"`
+	Archived   bool     `yaml:"archived"`
+	Fork       bool     `yaml:"fork"`
+	// Permission of the token on the repository, admin, maintain, write, triage, or read. Defaults to admin.
+	Permission string `yaml:"permission"`
+	// DependabotAlerts enables vulnerability alerts, which Dependabot secrets are only synced with.
+	DependabotAlerts bool `yaml:"dependabot-alerts"`
+	// Environments of the repository. Environment secrets and variables can only be synced to these.
+	Environments []string `yaml:"environments"`
+	// Files on the default branch by path, e.g. a repository config.
+	Files map[string]string `yaml:"files"`
+	// Secrets are the names of the existing Actions secrets of the repository.
+	Secrets []string `yaml:"secrets"`
+	// Variables are the existing Actions variables of the repository.
+	Variables map[string]string `yaml:"variables"`
+}
+
+// parseMockFixture parses a fixture, rejecting unknown fields and repositories not named owner/repo.
+func parseMockFixture(data []byte) (*MockFixture, error) {
+	var fixture MockFixture
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&fixture); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	for _, repo := range fixture.Repositories {
+		if owner, name, ok := strings.Cut(repo.Name, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("repository %q must be named owner/repo", repo.Name)
+		}
+	}
+	return &fixture, nil
+}
+
+// mockScope is a set of secrets and variables, either of Actions, Dependabot, Codespaces, or an environment.
+type mockScope struct {
+	secrets   map[string]time.Time
+	variables map[string]string
+}
+
+// mockRepo is the state of a repository of the mock server.
+type mockRepo struct {
+	repo   *github.Repository
+	alerts bool
+	files  map[string]string
+	// scopes are keyed by actions, dependabot, codespaces, or env:<name> for environments.
+	scopes map[string]*mockScope
+}
+
+// mockServer is an in-memory double of the parts of the GitHub API the sync uses: repository search, repositories,
+// contents, environments, and the secrets and variables of Actions, environments, Dependabot, and Codespaces.
+// Writes change its state, so a run can be checked against its own changes, but nothing is persisted.
+type mockServer struct {
+	mu        sync.Mutex
+	repos     map[string]*mockRepo
+	byID      map[string]*mockRepo
+	publicKey string
+}
+
+// newMockServer returns the mock server serving the repositories of fixture.
+func newMockServer(fixture *MockFixture) (*mockServer, error) {
+	publicKey, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate public key: %w", err)
+	}
+	s := &mockServer{
+		repos:     make(map[string]*mockRepo),
+		byID:      make(map[string]*mockRepo),
+		publicKey: base64.StdEncoding.EncodeToString(publicKey[:]),
+	}
+	now := github.Timestamp{Time: time.Now().UTC().Truncate(time.Second)}
+	for i, fr := range fixture.Repositories {
+		owner, name, _ := strings.Cut(fr.Name, "/")
+		visibility := fr.Visibility
+		if visibility == "" {
+			visibility = "private"
+		}
+		permission := fr.Permission
+		if permission == "" {
+			permission = adminPermission
+		}
+		topics := fr.Topics
+		if topics == nil {
+			topics = []string{}
+		}
+		repo := &mockRepo{
+			repo: &github.Repository{
+				ID:            github.Ptr(int64(i + 1)),
+				Name:          github.Ptr(name),
+				FullName:      github.Ptr(fr.Name),
+				Owner:         &github.User{Login: github.Ptr(owner)},
+				Topics:        topics,
+				Visibility:    github.Ptr(visibility),
+				Private:       github.Ptr(visibility != "public"),
+				Archived:      github.Ptr(fr.Archived),
+				Fork:          github.Ptr(fr.Fork),
+				DefaultBranch: github.Ptr("main"),
+				CreatedAt:     &now,
+				PushedAt:      &now,
+				Permissions:   mockPermissions(permission),
+			},
+			alerts: fr.DependabotAlerts,
+			files:  fr.Files,
+			scopes: map[string]*mockScope{
+				"actions":    newMockScope(),
+				"dependabot": newMockScope(),
+				"codespaces": newMockScope(),
+			},
+		}
+		for _, secret := range fr.Secrets {
+			repo.scopes["actions"].secrets[secret] = now.Time
+		}
+		for key, value := range fr.Variables {
+			repo.scopes["actions"].variables[key] = value
+		}
+		for _, env := range fr.Environments {
+			repo.scopes["env:"+env] = newMockScope()
+		}
+		s.repos[strings.ToLower(fr.Name)] = repo
+		s.byID[strconv.Itoa(i+1)] = repo
+	}
+	return s, nil
+}
+
+// mockRoles are the roles of a repository from the lowest to the highest, as named by repository permissions.
+var mockRoles = []string{"pull", "triage", "push", "maintain", adminPermission}
+
+// mockPermissions returns the permissions of a role, which include those of the roles below it.
+// The roles read and write are aliases of pull and push.
+func mockPermissions(role string) map[string]bool {
+	switch role {
+	case "read":
+		role = "pull"
+	case "write":
+		role = "push"
+	}
+	granted := slices.Index(mockRoles, role)
+	permissions := make(map[string]bool, len(mockRoles))
+	for i, r := range mockRoles {
+		permissions[r] = i <= granted
+	}
+	return permissions
+}
+
+func newMockScope() *mockScope {
+	return &mockScope{secrets: make(map[string]time.Time), variables: make(map[string]string)}
+}
+
+// startMockServer serves the repositories of the fixture at path on a random local port until stop is called.
+// It returns the URL to use as base URL of the API.
+func startMockServer(path string) (baseURL string, stop func(), err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read mock fixture: %w", err)
+	}
+	fixture, err := parseMockFixture(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid mock fixture %s: %w", path, err)
+	}
+	s, err := newMockServer(fixture)
+	if err != nil {
+		return "", nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to start mock server: %w", err)
+	}
+	server := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = server.Serve(listener) }()
+	return "http://" + listener.Addr().String() + "/", func() { _ = server.Close() }, nil
+}
+
+// handler returns the routes of the mock server. Every request is logged at debug level.
+func (s *mockServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rate_limit", s.rateLimit)
+	mux.HandleFunc("GET /user", s.user)
+	mux.HandleFunc("GET /search/repositories", s.searchRepositories)
+	mux.HandleFunc("GET /repos/{owner}/{repo}", s.withRepo(s.getRepository))
+	mux.HandleFunc("GET /repos/{owner}/{repo}/contents/{path...}", s.withRepo(s.getContents))
+	mux.HandleFunc("GET /repos/{owner}/{repo}/vulnerability-alerts", s.withRepo(s.getVulnerabilityAlerts))
+	mux.HandleFunc("GET /repos/{owner}/{repo}/environments", s.withRepo(s.listEnvironments))
+	mux.HandleFunc("GET /repos/{owner}/{repo}/environments/{env}", s.withRepo(s.getEnvironment))
+	mux.HandleFunc("PUT /repos/{owner}/{repo}/environments/{env}", s.withRepo(s.putEnvironment))
+
+	for _, scope := range []string{"actions", "dependabot", "codespaces"} {
+		prefix := "/repos/{owner}/{repo}/" + scope + "/secrets"
+		mux.HandleFunc("GET "+prefix+"/public-key", s.withRepo(s.getPublicKey))
+		mux.HandleFunc("GET "+prefix, s.withScope(scope, s.listSecrets))
+		mux.HandleFunc("PUT "+prefix+"/{name}", s.withScope(scope, s.putSecret))
+		mux.HandleFunc("DELETE "+prefix+"/{name}", s.withScope(scope, s.deleteSecret))
+	}
+	mux.HandleFunc("GET /repositories/{id}/environments/{env}/secrets/public-key", s.withRepo(s.getPublicKey))
+	mux.HandleFunc("GET /repositories/{id}/environments/{env}/secrets", s.withScope("", s.listSecrets))
+	mux.HandleFunc("PUT /repositories/{id}/environments/{env}/secrets/{name}", s.withScope("", s.putSecret))
+	mux.HandleFunc("DELETE /repositories/{id}/environments/{env}/secrets/{name}", s.withScope("", s.deleteSecret))
+
+	for _, prefix := range []string{"/repos/{owner}/{repo}/actions/variables", "/repos/{owner}/{repo}/environments/{env}/variables"} {
+		mux.HandleFunc("GET "+prefix, s.withScope("actions", s.listVariables))
+		mux.HandleFunc("POST "+prefix, s.withScope("actions", s.createVariable))
+		mux.HandleFunc("PATCH "+prefix+"/{name}", s.withScope("actions", s.updateVariable))
+		mux.HandleFunc("DELETE "+prefix+"/{name}", s.withScope("actions", s.deleteVariable))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		debugf(r.Context(), "Mock server: %s %s", r.Method, r.URL.RequestURI())
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// withRepo looks up the repository of the request, addressed by owner and name or by ID, and answers with 404 if
+// there is none. The server is locked while h runs.
+func (s *mockServer) withRepo(h func(w http.ResponseWriter, r *http.Request, repo *mockRepo)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var repo *mockRepo
+		if id := r.PathValue("id"); id != "" {
+			repo = s.byID[id]
+		} else {
+			repo = s.repos[strings.ToLower(r.PathValue("owner")+"/"+r.PathValue("repo"))]
+		}
+		if repo == nil {
+			writeMockError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		h(w, r, repo)
+	}
+}
+
+// withScope looks up the secrets and variables the request refers to, which are those of the environment if the path
+// names one and otherwise those of scope. Missing environments are answered with 404.
+func (s *mockServer) withScope(scope string, h func(w http.ResponseWriter, r *http.Request, sc *mockScope)) http.HandlerFunc {
+	return s.withRepo(func(w http.ResponseWriter, r *http.Request, repo *mockRepo) {
+		key := scope
+		if env := r.PathValue("env"); env != "" {
+			key = "env:" + env
+		}
+		sc, ok := repo.scopes[key]
+		if !ok {
+			writeMockError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		h(w, r, sc)
+	})
+}
+
+func (s *mockServer) rateLimit(w http.ResponseWriter, _ *http.Request) {
+	reset := github.Timestamp{Time: time.Now().Add(time.Hour).Truncate(time.Second)}
+	writeMockJSON(w, http.StatusOK, map[string]any{"resources": map[string]*github.Rate{
+		"core":   {Limit: 5000, Remaining: 5000, Reset: reset},
+		"search": {Limit: 30, Remaining: 30, Reset: reset},
+	}})
+}
+
+func (s *mockServer) user(w http.ResponseWriter, _ *http.Request) {
+	writeMockJSON(w, http.StatusOK, &github.User{Login: github.Ptr("mock"), Type: github.Ptr("User")})
+}
+
+// searchRepositories answers repository searches with the repositories matching all terms of the query.
+// The qualifiers org, user, repo, topic, archived, fork, and is are supported, other terms are matched against names.
+func (s *mockServer) searchRepositories(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	terms := strings.Fields(r.URL.Query().Get("q"))
+	items := []*github.Repository{}
+	for _, name := range sortedKeys(s.repos) {
+		repo := s.repos[name].repo
+		if mockSearchMatch(repo, terms) {
+			items = append(items, repo)
+		}
+	}
+	writeMockJSON(w, http.StatusOK, &github.RepositoriesSearchResult{Total: github.Ptr(len(items)), IncompleteResults: github.Ptr(false), Repositories: items})
+}
+
+// mockSearchMatch reports whether repo matches all search terms. As on GitHub, forks are only matched with fork:true
+// or fork:only.
+func mockSearchMatch(repo *github.Repository, terms []string) bool {
+	forks := false
+	for _, term := range terms {
+		qualifier, value, ok := strings.Cut(term, ":")
+		if !ok {
+			if !strings.Contains(strings.ToLower(repo.GetName()), strings.ToLower(term)) {
+				return false
+			}
+			continue
+		}
+		matched := true
+		switch qualifier {
+		case "org", "user":
+			matched = strings.EqualFold(repo.GetOwner().GetLogin(), value)
+		case "repo":
+			matched = strings.EqualFold(repo.GetFullName(), value)
+		case "topic":
+			matched = slices.ContainsFunc(repo.Topics, func(topic string) bool { return strings.EqualFold(topic, value) })
+		case "archived":
+			matched = strconv.FormatBool(repo.GetArchived()) == value
+		case "fork":
+			forks = value == "true" || value == "only"
+			matched = value != "only" || repo.GetFork()
+		case "is":
+			if value == "archived" {
+				matched = repo.GetArchived()
+			} else {
+				matched = value == repo.GetVisibility()
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return forks || !repo.GetFork()
+}
+
+func (s *mockServer) getRepository(w http.ResponseWriter, _ *http.Request, repo *mockRepo) {
+	writeMockJSON(w, http.StatusOK, repo.repo)
+}
+
+func (s *mockServer) getContents(w http.ResponseWriter, r *http.Request, repo *mockRepo) {
+	path := r.PathValue("path")
+	content, ok := repo.files[path]
+	if !ok {
+		writeMockError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	writeMockJSON(w, http.StatusOK, &github.RepositoryContent{
+		Type:     github.Ptr("file"),
+		Path:     github.Ptr(path),
+		Encoding: github.Ptr("base64"),
+		Content:  github.Ptr(base64.StdEncoding.EncodeToString([]byte(content))),
+	})
+}
+
+func (s *mockServer) getVulnerabilityAlerts(w http.ResponseWriter, _ *http.Request, repo *mockRepo) {
+	if !repo.alerts {
+		writeMockError(w, http.StatusNotFound, "Vulnerability alerts are disabled.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *mockServer) listEnvironments(w http.ResponseWriter, _ *http.Request, repo *mockRepo) {
+	environments := []*github.Environment{}
+	for _, key := range sortedKeys(repo.scopes) {
+		if name, ok := strings.CutPrefix(key, "env:"); ok {
+			environments = append(environments, &github.Environment{Name: github.Ptr(name)})
+		}
+	}
+	writeMockJSON(w, http.StatusOK, &github.EnvResponse{TotalCount: github.Ptr(len(environments)), Environments: environments})
+}
+
+func (s *mockServer) getEnvironment(w http.ResponseWriter, r *http.Request, repo *mockRepo) {
+	env := r.PathValue("env")
+	if repo.scopes["env:"+env] == nil {
+		writeMockError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	writeMockJSON(w, http.StatusOK, &github.Environment{Name: github.Ptr(env)})
+}
+
+// putEnvironment creates an environment, protection rules aren't modeled.
+func (s *mockServer) putEnvironment(w http.ResponseWriter, r *http.Request, repo *mockRepo) {
+	env := r.PathValue("env")
+	if repo.scopes["env:"+env] == nil {
+		repo.scopes["env:"+env] = newMockScope()
+	}
+	writeMockJSON(w, http.StatusOK, &github.Environment{Name: github.Ptr(env)})
+}
+
+func (s *mockServer) getPublicKey(w http.ResponseWriter, r *http.Request, repo *mockRepo) {
+	if env := r.PathValue("env"); env != "" && repo.scopes["env:"+env] == nil {
+		writeMockError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	writeMockJSON(w, http.StatusOK, &github.PublicKey{KeyID: github.Ptr("mock"), Key: github.Ptr(s.publicKey)})
+}
+
+func (s *mockServer) listSecrets(w http.ResponseWriter, _ *http.Request, sc *mockScope) {
+	secrets := []*github.Secret{}
+	for _, name := range sortedKeys(sc.secrets) {
+		updated := github.Timestamp{Time: sc.secrets[name]}
+		secrets = append(secrets, &github.Secret{Name: name, CreatedAt: updated, UpdatedAt: updated})
+	}
+	writeMockJSON(w, http.StatusOK, &github.Secrets{TotalCount: len(secrets), Secrets: secrets})
+}
+
+func (s *mockServer) putSecret(w http.ResponseWriter, r *http.Request, sc *mockScope) {
+	var secret github.EncryptedSecret
+	if err := json.NewDecoder(r.Body).Decode(&secret); err != nil || secret.EncryptedValue == "" || secret.KeyID != "mock" {
+		writeMockError(w, http.StatusUnprocessableEntity, "Invalid request.")
+		return
+	}
+	name := r.PathValue("name")
+	_, exists := sc.secrets[name]
+	sc.secrets[name] = time.Now().UTC().Truncate(time.Second)
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *mockServer) deleteSecret(w http.ResponseWriter, r *http.Request, sc *mockScope) {
+	name := r.PathValue("name")
+	if _, ok := sc.secrets[name]; !ok {
+		writeMockError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	delete(sc.secrets, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *mockServer) listVariables(w http.ResponseWriter, _ *http.Request, sc *mockScope) {
+	variables := []*github.ActionsVariable{}
+	for _, name := range sortedKeys(sc.variables) {
+		variables = append(variables, &github.ActionsVariable{Name: name, Value: sc.variables[name]})
+	}
+	writeMockJSON(w, http.StatusOK, &github.ActionsVariables{TotalCount: len(variables), Variables: variables})
+}
+
+func (s *mockServer) createVariable(w http.ResponseWriter, r *http.Request, sc *mockScope) {
+	var variable github.ActionsVariable
+	if err := json.NewDecoder(r.Body).Decode(&variable); err != nil || variable.Name == "" {
+		writeMockError(w, http.StatusUnprocessableEntity, "Invalid request.")
+		return
+	}
+	if _, exists := sc.variables[variable.Name]; exists {
+		writeMockError(w, http.StatusConflict, "Already exists - Variable already exists.")
+		return
+	}
+	sc.variables[variable.Name] = variable.Value
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *mockServer) updateVariable(w http.ResponseWriter, r *http.Request, sc *mockScope) {
+	name := r.PathValue("name")
+	var variable github.ActionsVariable
+	if err := json.NewDecoder(r.Body).Decode(&variable); err != nil {
+		writeMockError(w, http.StatusUnprocessableEntity, "Invalid request.")
+		return
+	}
+	if _, exists := sc.variables[name]; !exists {
+		writeMockError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	sc.variables[name] = variable.Value
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *mockServer) deleteVariable(w http.ResponseWriter, r *http.Request, sc *mockScope) {
+	name := r.PathValue("name")
+	if _, ok := sc.variables[name]; !ok {
+		writeMockError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	delete(sc.variables, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeMockError(w http.ResponseWriter, status int, message string) {
+	writeMockJSON(w, status, map[string]string{"message": message})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// syncOrganization syncs the secrets to the organization given by --organization instead of its repositories
+// and adds the result to the report. Only Codespaces secrets can be synced to organizations.
+func syncOrganization(ctx context.Context, args EnvArgs, client GitHubActionClient, targets []syncTarget, secrets map[string]string, report *Report) error {
+	if args.TargetRepo != "" || args.Query != "" || args.Repos != "" || args.ReposFile != "" {
+		return fmt.Errorf("organization cannot be combined with target, query, repos, or repos-file")
+	}
+	for _, target := range targets {
+		if target.Type != Codespaces {
+			return fmt.Errorf("organization only supports type codespaces, not %s", target.Type)
+		}
+	}
+	access, err := parseOrgSecretAccess(args.OrgSecretVisibility, secrets)
+	if err != nil {
+		return err
+	}
+	if args.Preflight {
+		if err := preflightOrganization(ctx, client, args.Organization); err != nil {
+			return err
+		}
+	}
+	if err := resolveOrgSecretAccess(ctx, client, args.Organization, access); err != nil {
+		return err
+	}
+	result, err := processOrganization(ctx, args, client, args.Organization, secrets, access)
+	report.Add(result)
+	if err != nil {
+		return fmt.Errorf("failed to process %s: %w", result.Target(), err)
+	}
+	return nil
+}
+
+// processOrganization handles the synchronization of the Codespaces secrets of an organization.
+// The result names the organization in place of a repository.
+func processOrganization(ctx context.Context, args EnvArgs, client GitHubActionClient, org string, secrets map[string]string, access map[string]OrgSecretAccess) (result RepoResult, err error) {
+	result = RepoResult{Repository: org, Type: Codespaces}
+	logger := loggerFrom(ctx)
+	logger.Printf("Processing organization %s\n", org)
+	keys := &keyRecorder{}
+	ctx = withKeyRecorder(ctx, keys)
+	ctx = withEmptyPrune(ctx, args, secrets, nil)
+
+	if len(secrets) == 0 && !pruneEmpty(ctx, args, false) {
+		result.Status = StatusUnchanged
+		return result, nil
+	}
+	ctx, secrets, prune := pruneContext(ctx, args, secrets)
+	if prune {
+		err = client.SyncOrgCodespacesSecrets(ctx, org, secrets, access)
+	} else {
+		err = client.PutOrgCodespacesSecrets(ctx, org, secrets, access)
+	}
+	result.Keys = keys.Results()
+	if err != nil {
+		for _, keyErr := range keyErrors(err) {
+			logger.Printf("Failed to sync key %s in organization %s: %v\n", keyErr.Key, org, keyErr.Err)
+		}
+		// Deletions aren't part of the secrets, the recorded keys tell whether anything was applied.
+		result.Status = StatusFailed
+		if keys.succeeded() {
+			result.Status = StatusPartial
+		}
+		result.Error = err.Error()
+		return result, fmt.Errorf("failed to sync Codespaces secrets of organization %s: %w", org, err)
+	}
+	result.Status = syncedStatus(result.Keys)
+	logger.Printf("Successfully processed Codespaces secrets for organization %s\n", strings.ToLower(org))
+	return result, nil
+}
+
+// parseOrgSecretAccess parses the visibilities of organization secrets, given as newline-separated KEY=VISIBILITY pairs.
+// The visibility is all, private, or selected followed by a colon and the comma-separated repositories of the
+// organization that can use the secret, e.g. TOKEN=selected:service-a,service-b.
+func parseOrgSecretAccess(raw string, secrets map[string]string) (map[string]OrgSecretAccess, error) {
+	pairs, err := parseKeyValuePairs(raw, parseOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("invalid org-secret-visibility: %w", err)
+	}
+	access := make(map[string]OrgSecretAccess, len(pairs))
+	for _, key := range sortedKeys(pairs) {
+		if _, ok := secrets[key]; !ok {
+			return nil, fmt.Errorf("invalid org-secret-visibility: %s is not one of the synced secrets", key)
+		}
+		visibility, repos, _ := strings.Cut(pairs[key], ":")
+		visibility = strings.ToLower(strings.TrimSpace(visibility))
+		switch visibility {
+		case visibilityAll, visibilityPrivate:
+			if repos != "" {
+				return nil, fmt.Errorf("invalid org-secret-visibility: %s lists repositories for visibility %s, only selected takes repositories", key, visibility)
+			}
+		case visibilitySelected:
+			if len(splitList(repos)) == 0 {
+				return nil, fmt.Errorf("invalid org-secret-visibility: %s has visibility selected without repositories", key)
+			}
+		default:
+			return nil, fmt.Errorf("invalid org-secret-visibility: %s has unknown visibility %q, must be all, private, or selected", key, visibility)
+		}
+		access[key] = OrgSecretAccess{Visibility: visibility, Repositories: splitList(repos)}
+	}
+	return access, nil
+}
+
+// resolveOrgSecretAccess looks up the IDs of the selected repositories, which the API takes instead of their names.
+// Repositories are named without the organization, each is looked up once.
+func resolveOrgSecretAccess(ctx context.Context, client GitHubActionClient, org string, access map[string]OrgSecretAccess) error {
+	ids := make(map[string]int64)
+	for _, key := range sortedKeys(access) {
+		declared := access[key]
+		for _, name := range declared.Repositories {
+			id, ok := ids[strings.ToLower(name)]
+			if !ok {
+				repo, _, err := client.GetRepository(ctx, org, name)
+				if err != nil {
+					return fmt.Errorf("failed to look up repository %s/%s selected for secret %s: %w", org, name, key, err)
+				}
+				id = repo.GetID()
+				ids[strings.ToLower(name)] = id
+			}
+			declared.RepositoryIDs = append(declared.RepositoryIDs, id)
+		}
+		access[key] = declared
+	}
+	return nil
+}
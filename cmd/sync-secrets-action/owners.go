@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// maxParallelOwners limits the number of owners whose repositories are synced at the same time.
+const maxParallelOwners = 4
+
+// ownerBacklog is the number of repositories of an owner that are queued ahead of its goroutines. A long run of
+// repositories of one owner only holds back those of the others once that many are waiting, while the memory used
+// for streamed search results stays bounded.
+const ownerBacklog = 1000
+
+type ownerContextKey struct{}
+
+// withOwner returns a context for the requests on behalf of the repositories of owner, whose secondary rate limit
+// is tracked separately from that of other owners.
+func withOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerContextKey{}, owner)
+}
+
+// ownerFrom returns the owner set by withOwner, if any.
+func ownerFrom(ctx context.Context) string {
+	owner, _ := ctx.Value(ownerContextKey{}).(string)
+	return owner
+}
+
+// syncByOwner calls syncRepo for all repositories, dispatched to the goroutines of their owner as they arrive, so
+// syncing starts with the first repository instead of waiting for the complete selection. Secondary rate limits are
+// enforced per resource owner, so the repositories of different owners are synced in parallel, each owner by up to
+// concurrency goroutines whose requests carry the owner, see withOwner. At most maxParallelOwners times concurrency
+// repositories are synced at a time. The primary rate limit of the token is shared by all of them.
+// The first error, including one yielded by repos, stops all owners from starting another repository and is returned.
+// Repositories being synced by other goroutines at that time are finished, so they aren't left half synced.
+func syncByOwner(ctx context.Context, repos iter.Seq2[*github.Repository, error], concurrency int, syncRepo func(ctx context.Context, repo *github.Repository) error) error {
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	concurrency = max(concurrency, 1)
+	slots := make(chan struct{}, maxParallelOwners*concurrency)
+	queues := make(map[string]chan *github.Repository)
+	for repo, err := range repos {
+		if err != nil {
+			fail(err)
+			break
+		}
+		if failed() {
+			break
+		}
+		owner := strings.ToLower(repo.GetOwner().GetLogin())
+		queue, ok := queues[owner]
+		if !ok {
+			queue = make(chan *github.Repository, ownerBacklog)
+			queues[owner] = queue
+			ownerCtx := withOwner(ctx, owner)
+			for range concurrency {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for repo := range queue {
+						if failed() {
+							continue
+						}
+						slots <- struct{}{}
+						if err := syncRepo(ownerCtx, repo); err != nil {
+							fail(err)
+						}
+						<-slots
+					}
+				}()
+			}
+		}
+		queue <- repo
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+	return firstErr
+}
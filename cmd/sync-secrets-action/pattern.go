@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// namePattern matches names of keys or environments, either as glob like DO_NOT_TOUCH_* or as regular expression
+// between slashes like /^TF_[A-Z]+$/. Names are matched case-insensitively, like GitHub treats them.
+type namePattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// parseNamePatterns parses a comma- or newline-separated list of name patterns.
+func parseNamePatterns(list string) ([]namePattern, error) {
+	var patterns []namePattern
+	for _, item := range splitList(list) {
+		if len(item) > 2 && strings.HasPrefix(item, "/") && strings.HasSuffix(item, "/") {
+			re, err := regexp.Compile("(?i)" + item[1:len(item)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", item, err)
+			}
+			patterns = append(patterns, namePattern{re: re})
+			continue
+		}
+		glob := strings.ToUpper(item)
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", item, err)
+		}
+		patterns = append(patterns, namePattern{glob: glob})
+	}
+	return patterns, nil
+}
+
+func (p namePattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	ok, _ := path.Match(p.glob, strings.ToUpper(name))
+	return ok
+}
+
+// matchAny reports whether any of patterns matches name.
+func matchAny(patterns []namePattern, name string) bool {
+	for _, pattern := range patterns {
+		if pattern.match(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// adminPermission is the permission on a repository required to manage its secrets and variables.
+const adminPermission = "admin"
+
+// preflight verifies that the token has admin access to all repos before any of them is changed,
+// so a run over hundreds of repositories fails up front with the full list of repositories it can't sync, instead of
+// halfway through. Repositories found by search carry the permissions of the token, others are looked up.
+// Repositories without reported permissions, as with GitHub App installation tokens, are left to the sync.
+func preflight(ctx context.Context, client GitHubActionClient, repos []*github.Repository) error {
+	logger := loggerFrom(ctx)
+	var denied, unknown []string
+	total := len(repos)
+	for _, repo := range repos {
+		permissions := repo.Permissions
+		if permissions == nil {
+			found, _, err := client.GetRepository(ctx, repo.GetOwner().GetLogin(), repo.GetName())
+			if err != nil {
+				if isStatus(err, http.StatusNotFound) {
+					denied = append(denied, repo.GetFullName()+" (not found)")
+					continue
+				}
+				return fmt.Errorf("preflight failed to read the permissions on %s: %w", repo.GetFullName(), err)
+			}
+			permissions = found.Permissions
+		}
+		switch {
+		case permissions == nil:
+			unknown = append(unknown, repo.GetFullName())
+		case !permissions[adminPermission]:
+			denied = append(denied, repo.GetFullName())
+		}
+	}
+	if len(unknown) > 0 {
+		logger.Printf("Preflight: the permissions on %s aren't reported, they're checked by the sync\n", strings.Join(unknown, ", "))
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("preflight failed, the token lacks admin access to %d of %d repositories: %s", len(denied), total, strings.Join(denied, ", "))
+	}
+	logger.Printf("Preflight: the token has admin access to all %d repositories\n", total-len(unknown))
+	return nil
+}
+
+// preflightOrganization verifies that the token is an admin of org, as required to manage its secrets, before any of
+// them is changed. Tokens that can't read their membership, as GitHub App installation tokens, are left to the sync.
+func preflightOrganization(ctx context.Context, client GitHubActionClient, org string) error {
+	logger := loggerFrom(ctx)
+	membership, _, err := client.GetOrgMembership(ctx, org)
+	switch {
+	case isStatus(err, http.StatusNotFound):
+		return fmt.Errorf("preflight failed, the token isn't a member of the organization %s", org)
+	case isStatus(err, http.StatusForbidden):
+		logger.Printf("Preflight: the membership in %s isn't readable, it's checked by the sync\n", org)
+		return nil
+	case err != nil:
+		return fmt.Errorf("preflight failed to read the membership in %s: %w", org, err)
+	case membership.GetState() != "active" || membership.GetRole() != adminPermission:
+		return fmt.Errorf("preflight failed, the token lacks admin access to the organization %s", org)
+	}
+	logger.Printf("Preflight: the token has admin access to the organization %s\n", org)
+	return nil
+}
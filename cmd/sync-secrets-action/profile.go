@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+)
+
+// checkLoopback returns an error unless addr binds to a loopback address. The profiles expose the memory of the
+// process, which holds the token and the values being synced.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid pprof-addr: %w", err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("pprof-addr must bind to a loopback address such as localhost:6060, got %s", addr)
+	}
+	return nil
+}
+
+// startProfiling writes a CPU profile of the run to cpuProfile and serves the runtime profiles on pprofAddr,
+// each if set, so the time spent on large runs can be attributed to enumeration, rate limit checks, encryption,
+// and HTTP. The returned stop flushes the CPU profile, runs ending with log.Fatal leave it incomplete.
+// The command line isn't served, as it holds the token and values passed as flags.
+func startProfiling(cpuProfile, pprofAddr string) (stop func(), err error) {
+	if pprofAddr != "" {
+		if err := checkLoopback(pprofAddr); err != nil {
+			return nil, err
+		}
+	}
+	var stops []func()
+	stop = func() {
+		for _, s := range stops {
+			s()
+		}
+	}
+
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := rpprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		stops = append(stops, func() {
+			rpprof.StopCPUProfile()
+			f.Close()
+		})
+	}
+
+	if pprofAddr != "" {
+		listener, err := net.Listen("tcp", pprofAddr)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("failed to serve profiles: %w", err)
+		}
+		// Blocking and mutex profiles are only recorded if enabled.
+		runtime.SetBlockProfileRate(int(time.Millisecond))
+		runtime.SetMutexProfileFraction(100)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Warning: stopped serving profiles: %v\n", err)
+			}
+		}()
+		log.Printf("Serving profiles at http://%s/debug/pprof/\n", listener.Addr())
+		stops = append(stops, func() { _ = server.Close() })
+	}
+	return stop, nil
+}
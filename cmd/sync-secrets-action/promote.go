@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Promotion describes the variables promoted from a source environment, as recorded in the report.
+type Promotion struct {
+	Source string `json:"source"`
+	// Keys are the promoted keys.
+	Keys []string `json:"keys"`
+	// Excluded are the keys of the source that aren't on the allow-list and were left out.
+	Excluded []string `json:"excluded,omitempty"`
+}
+
+// promoteValues returns the values of the allowed keys. Keys of the source that aren't allowed, e.g. staging-only
+// settings, are left out and returned as excluded, so a target never silently receives them. Allowed keys missing
+// from the source are an error, as the promotion would be incomplete.
+func promoteValues(values map[string]string, allowed []string) (map[string]string, *Promotion, error) {
+	allow := make(map[string]bool, len(allowed))
+	for _, key := range allowed {
+		allow[strings.ToUpper(key)] = true
+	}
+
+	promoted := make(map[string]string, len(allowed))
+	promotion := &Promotion{Keys: []string{}}
+	for key, value := range values {
+		if !allow[strings.ToUpper(key)] {
+			promotion.Excluded = append(promotion.Excluded, key)
+			continue
+		}
+		promoted[key] = value
+		promotion.Keys = append(promotion.Keys, key)
+		delete(allow, strings.ToUpper(key))
+	}
+	if len(allow) > 0 {
+		missing := make([]string, 0, len(allow))
+		for key := range allow {
+			missing = append(missing, key)
+		}
+		sort.Strings(missing)
+		return nil, nil, fmt.Errorf("allowed keys missing from the source: %s", strings.Join(missing, ", "))
+	}
+	sort.Strings(promotion.Keys)
+	sort.Strings(promotion.Excluded)
+	return promoted, promotion, nil
+}
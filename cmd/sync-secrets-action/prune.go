@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// prunePolicy limits which of the existing keys that aren't part of the input prunes delete.
+type prunePolicy struct {
+	// protect matches keys that are never deleted, e.g. those managed by other automation.
+	protect []namePattern
+	// managedPrefix, if set, is the prefix of the keys the tool manages, only those are deleted.
+	managedPrefix string
+	// only, if set, holds the uppercased keys that are deleted, instead of all stale ones.
+	only map[string]bool
+	// named holds the uppercased keys named for deletion, which are deleted regardless of managedPrefix.
+	named map[string]bool
+}
+
+// allows reports whether the policy lets prunes delete the key.
+func (p *prunePolicy) allows(name string) bool {
+	if p == nil {
+		return true
+	}
+	upper := strings.ToUpper(name)
+	if p.only != nil && !p.only[upper] {
+		return false
+	}
+	if !p.named[upper] && !strings.HasPrefix(upper, strings.ToUpper(p.managedPrefix)) {
+		return false
+	}
+	return !matchAny(p.protect, name)
+}
+
+type emptyPruneContextKey struct{}
+
+// withEmptyPrune returns a context under which mappings without values prune every existing key of their kind, if
+// prune is confirmed with allow-empty-prune and there are neither secrets nor variables to sync. Otherwise, an empty
+// kind is left alone while the other one is synced, so a run that only sets secrets never wipes the variables.
+func withEmptyPrune(ctx context.Context, args EnvArgs, secrets, variables map[string]string) context.Context {
+	if !args.Prune || !args.AllowEmptyPrune || hasValues(secrets) || hasValues(variables) {
+		return ctx
+	}
+	return context.WithValue(ctx, emptyPruneContextKey{}, true)
+}
+
+// emptyPruneAllowed reports whether mappings without values prune under ctx.
+func emptyPruneAllowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(emptyPruneContextKey{}).(bool)
+	return allowed
+}
+
+// pruneEmpty reports whether an empty mapping of secrets, or of variables if variables is set, is synced to delete
+// keys instead of being skipped. Prunes only do so under withEmptyPrune, as they delete every existing key of the
+// kind then, while keys listed by delete are always deleted. Skipped kinds are never synced.
+func pruneEmpty(ctx context.Context, args EnvArgs, variables bool) bool {
+	skipped := args.SkipSecrets
+	if variables {
+		skipped = args.SkipVariables
+	}
+	return (emptyPruneAllowed(ctx) || args.Delete != "") && !skipped
+}
+
+// deleteSentinel is the value that marks keys of the input for deletion, like OLD_KEY=__DELETE__.
+const deleteSentinel = "__DELETE__"
+
+// deletedKeys returns the sorted keys of values marked for deletion with deleteSentinel.
+func deletedKeys(values map[string]string) []string {
+	var keys []string
+	for _, key := range sortedKeys(values) {
+		if values[key] == deleteSentinel {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// hasValues reports whether values holds keys to sync, apart from those marked for deletion.
+func hasValues(values map[string]string) bool {
+	return len(values) > len(deletedKeys(values))
+}
+
+// pruneContext returns the context to sync values under, the values without those marked for deletion, and whether
+// the sync deletes keys that aren't part of the input. Without prune, only the keys listed by delete or marked for
+// deletion are deleted, if any. Empty values and those that only mark keys for deletion don't prune, as they'd delete
+// every other key, unless allowed by withEmptyPrune.
+func pruneContext(ctx context.Context, args EnvArgs, values map[string]string) (context.Context, map[string]string, bool) {
+	marked := deletedKeys(values)
+	if len(marked) > 0 {
+		kept := make(map[string]string, len(values)-len(marked))
+		for key, value := range values {
+			if value != deleteSentinel {
+				kept[key] = value
+			}
+		}
+		values = kept
+	}
+	keys := append(splitList(args.Delete), marked...)
+	if args.Prune && (len(values) > 0 || emptyPruneAllowed(ctx)) {
+		if len(keys) > 0 {
+			ctx = withDeletions(ctx, keys, false)
+		}
+		return ctx, values, true
+	}
+	if len(keys) == 0 {
+		return ctx, values, false
+	}
+	return withDeletions(ctx, keys, true), values, true
+}
+
+// withDeletions returns a context under which prunes delete keys regardless of a managed prefix, as they are named
+// explicitly, and with only set, no other keys. Protected keys are still kept.
+func withDeletions(ctx context.Context, keys []string, only bool) context.Context {
+	var restricted prunePolicy
+	if policy, _ := ctx.Value(prunePolicyContextKey{}).(*prunePolicy); policy != nil {
+		restricted = *policy
+	}
+	restricted.named = make(map[string]bool, len(keys))
+	for _, key := range keys {
+		restricted.named[strings.ToUpper(key)] = true
+	}
+	if only {
+		restricted.only = restricted.named
+	}
+	return withPrunePolicy(ctx, &restricted)
+}
+
+type prunePolicyContextKey struct{}
+
+// withPrunePolicy returns a context under which prunes follow policy.
+func withPrunePolicy(ctx context.Context, policy *prunePolicy) context.Context {
+	return context.WithValue(ctx, prunePolicyContextKey{}, policy)
+}
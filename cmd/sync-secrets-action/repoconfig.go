@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RepoConfig is the configuration a repository commits to adjust the central sync to itself,
+// e.g. in .github/sync-secrets.yml.
+type RepoConfig struct {
+	// OptOut skips the repository entirely.
+	OptOut bool `yaml:"opt-out"`
+	// ExcludeKeys are neither written nor pruned in the repository.
+	ExcludeKeys []string `yaml:"exclude-keys"`
+	// Environments replace the environments Actions values are synced to.
+	Environments []string `yaml:"environments"`
+}
+
+// parseRepoConfig parses the configuration of a repository. Unknown fields are rejected,
+// so a typo like opt_out doesn't silently sync to a repository that meant to opt out.
+func parseRepoConfig(data []byte) (*RepoConfig, error) {
+	var cfg RepoConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	for _, key := range cfg.ExcludeKeys {
+		if err := validateKeyName(key); err != nil {
+			return nil, fmt.Errorf("exclude-keys: %w", err)
+		}
+	}
+	for _, env := range cfg.Environments {
+		if strings.TrimSpace(env) == "" {
+			return nil, errors.New("environments must not be empty")
+		}
+	}
+	return &cfg, nil
+}
+
+// applyTargets returns the targets of the repository. Its environments replace those of the Actions targets,
+// the other types are kept as they are.
+func (c *RepoConfig) applyTargets(targets []syncTarget) []syncTarget {
+	if len(c.Environments) == 0 {
+		return targets
+	}
+	var result []syncTarget
+	replaced := false
+	for _, t := range targets {
+		if t.Type != Actions {
+			result = append(result, t)
+			continue
+		}
+		if replaced {
+			continue
+		}
+		replaced = true
+		for _, env := range c.Environments {
+			result = append(result, syncTarget{Type: Actions, Environment: strings.TrimSpace(env)})
+		}
+	}
+	return result
+}
+
+// applyValues returns values without the keys the repository excludes.
+func (c *RepoConfig) applyValues(values map[string]string) map[string]string {
+	if len(c.ExcludeKeys) == 0 {
+		return values
+	}
+	result := make(map[string]string, len(values))
+	for key, value := range values {
+		result[key] = value
+	}
+	for _, key := range c.ExcludeKeys {
+		for name := range result {
+			if strings.EqualFold(name, key) {
+				delete(result, name)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,616 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// RepoStatus describes the outcome of processing a single repository.
+type RepoStatus string
+
+const (
+	// StatusSynced means all changes were applied.
+	StatusSynced RepoStatus = "synced"
+	// StatusUnchanged means there was nothing to do.
+	StatusUnchanged RepoStatus = "unchanged"
+	// StatusSkipped means the repository was deliberately not processed, see Reason.
+	StatusSkipped RepoStatus = "skipped"
+	// StatusPartial means some changes were applied while others, e.g. single keys, failed.
+	StatusPartial RepoStatus = "partial"
+	// StatusFailed means no changes could be applied.
+	StatusFailed RepoStatus = "failed"
+)
+
+// repoStatuses lists all statuses in the order they are summarized.
+var repoStatuses = []RepoStatus{StatusSynced, StatusUnchanged, StatusSkipped, StatusPartial, StatusFailed}
+
+// RepoResult holds the outcome of processing a single repository.
+type RepoResult struct {
+	Repository  string      `json:"repository"`
+	Type        TargetType  `json:"type,omitempty"`
+	Environment string      `json:"environment,omitempty"`
+	Status      RepoStatus  `json:"status"`
+	Reason      string      `json:"reason,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Keys        []KeyResult `json:"keys,omitempty"`
+}
+
+// Target returns a human-readable representation of the repository and the synced type or environment.
+func (r RepoResult) Target() string {
+	return syncTarget{Type: r.Type, Environment: r.Environment}.describe(r.Repository)
+}
+
+// KeyOutcome describes what happened to a single secret or variable.
+type KeyOutcome string
+
+const (
+	// KeyCreated means the key didn't exist before and was created.
+	KeyCreated KeyOutcome = "created"
+	// KeyUpdated means the existing key was overwritten.
+	KeyUpdated KeyOutcome = "updated"
+	// KeyDeleted means the key was pruned.
+	KeyDeleted KeyOutcome = "deleted"
+	// KeyUnchanged means the key already had the desired value and wasn't written.
+	KeyUnchanged KeyOutcome = "skipped-unchanged"
+	// KeyFailed means the key couldn't be written or deleted, see Error.
+	KeyFailed KeyOutcome = "failed"
+)
+
+// KeyResult holds the outcome of a single secret or variable.
+type KeyResult struct {
+	Kind    string     `json:"kind"`
+	Name    string     `json:"name"`
+	Outcome KeyOutcome `json:"outcome"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// changed reports whether the key was created, updated, or deleted.
+func (k KeyResult) changed() bool {
+	return k.Outcome == KeyCreated || k.Outcome == KeyUpdated || k.Outcome == KeyDeleted
+}
+
+// syncedStatus returns StatusSynced if any of the keys of a target that synced without errors was changed, and
+// StatusUnchanged if all of them already had the desired state.
+func syncedStatus(keys []KeyResult) RepoStatus {
+	for _, key := range keys {
+		if key.changed() {
+			return StatusSynced
+		}
+	}
+	return StatusUnchanged
+}
+
+// keyRecorder collects the per-key results of a single repository.
+// Syncs are retried as a whole, so a later result for a key replaces the earlier one.
+type keyRecorder struct {
+	mu      sync.Mutex
+	results map[[2]string]KeyResult
+}
+
+type keyRecorderContextKey struct{}
+
+// withKeyRecorder returns a context that records the per-key results of syncs into rec.
+func withKeyRecorder(ctx context.Context, rec *keyRecorder) context.Context {
+	return context.WithValue(ctx, keyRecorderContextKey{}, rec)
+}
+
+// recordKey records the result of a key if ctx carries a keyRecorder.
+func recordKey(ctx context.Context, result KeyResult) {
+	rec, ok := ctx.Value(keyRecorderContextKey{}).(*keyRecorder)
+	if !ok {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.results == nil {
+		rec.results = make(map[[2]string]KeyResult)
+	}
+	rec.results[[2]string{result.Kind, result.Name}] = result
+}
+
+// failKey records the failure of a key and returns it as KeyError.
+func failKey(ctx context.Context, kind, name string, err error) error {
+	recordKey(ctx, KeyResult{Kind: kind, Name: name, Outcome: KeyFailed, Error: err.Error()})
+	return &KeyError{Key: name, Err: err}
+}
+
+// succeeded reports whether any key was recorded with an outcome other than KeyFailed.
+func (r *keyRecorder) succeeded() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, result := range r.results {
+		if result.Outcome != KeyFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// Results returns the recorded results sorted by kind and name.
+func (r *keyRecorder) Results() []KeyResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := make([]KeyResult, 0, len(r.results))
+	for _, result := range r.results {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Kind != results[j].Kind {
+			return results[i].Kind < results[j].Kind
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+// Report collects the per-repository results of a run.
+type Report struct {
+	mu           sync.Mutex
+	DryRun       bool         `json:"dry_run"`
+	Repositories []RepoResult `json:"repositories"`
+	// Promotion lists the promoted keys if the variables were read from another environment.
+	Promotion *Promotion `json:"promotion,omitempty"`
+	// Modified is filled in when the report is written, see ModifiedRepositories.
+	Modified []string `json:"modified_repositories"`
+	// usage is summarized after the results, if set.
+	usage *apiUsage
+	// order is the position of every repository in the processing order, see orderByOwner.
+	order map[string]reportPosition
+	// owners counts the owners ordered so far.
+	owners int
+}
+
+// reportPosition is the position of a repository in the report: after those of owners that appeared before its
+// owner, and after the repositories of its owner that appeared before it.
+type reportPosition struct {
+	owner, repo int
+}
+
+// orderByOwner returns repos, recording the order results are reported in as the repositories pass: grouped by
+// owner, in the order the owners first appear, and within a group in the order of repos. The repositories of
+// different owners are synced in parallel, so their results are added in whichever order they complete.
+// Repositories ordered before, e.g. by an earlier job of the manifest, keep their position.
+func (r *Report) orderByOwner(repos iter.Seq2[*github.Repository, error]) iter.Seq2[*github.Repository, error] {
+	return func(yield func(*github.Repository, error) bool) {
+		owners := make(map[string]int)
+		for repo, err := range repos {
+			if err == nil {
+				r.place(repo, owners)
+			}
+			if !yield(repo, err) {
+				return
+			}
+		}
+	}
+}
+
+// place records the position of repo, numbering the owners not yet in owners after all owners ordered so far.
+func (r *Report) place(repo *github.Repository, owners map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.order == nil {
+		r.order = make(map[string]reportPosition)
+	}
+	name := repo.GetOwner().GetLogin() + "/" + repo.GetName()
+	if _, ok := r.order[name]; ok {
+		return
+	}
+	owner := strings.ToLower(repo.GetOwner().GetLogin())
+	rank, ok := owners[owner]
+	if !ok {
+		rank = r.owners
+		owners[owner] = rank
+		r.owners++
+	}
+	r.order[name] = reportPosition{owner: rank, repo: len(r.order)}
+}
+
+// sortResults sorts the results into the order set by orderByOwner, if any. The results of a repository keep their order.
+func (r *Report) sortResults() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.order == nil {
+		return
+	}
+	sort.SliceStable(r.Repositories, func(i, j int) bool {
+		a, b := r.order[r.Repositories[i].Repository], r.order[r.Repositories[j].Repository]
+		if a.owner != b.owner {
+			return a.owner < b.owner
+		}
+		return a.repo < b.repo
+	})
+}
+
+// Add records the result of a processed repository.
+func (r *Report) Add(result RepoResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Repositories = append(r.Repositories, result)
+}
+
+// Counts returns the number of repositories per status.
+func (r *Report) Counts() map[RepoStatus]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[RepoStatus]int)
+	for _, result := range r.Repositories {
+		counts[result.Status]++
+	}
+	return counts
+}
+
+// ModifiedRepositories returns the repositories that had a key created, updated, or deleted, in the order they were
+// processed. For dry runs, these are the repositories that would be modified.
+func (r *Report) ModifiedRepositories() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.modifiedRepositories()
+}
+
+func (r *Report) modifiedRepositories() []string {
+	modified := []string{}
+	seen := make(map[string]bool)
+	for _, result := range r.Repositories {
+		if seen[result.Repository] {
+			continue
+		}
+		for _, key := range result.Keys {
+			if key.changed() {
+				seen[result.Repository] = true
+				modified = append(modified, result.Repository)
+				break
+			}
+		}
+	}
+	return modified
+}
+
+// FailedRepositories returns the repositories that failed entirely or partially, in the order they were processed.
+func (r *Report) FailedRepositories() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failedRepositories()
+}
+
+func (r *Report) failedRepositories() []string {
+	failed := []string{}
+	for _, result := range r.Repositories {
+		if (result.Status == StatusFailed || result.Status == StatusPartial) && !slices.Contains(failed, result.Repository) {
+			failed = append(failed, result.Repository)
+		}
+	}
+	return failed
+}
+
+// Outputs returns the outputs of the action summarizing the results, for later steps of the workflow to branch on:
+// the number of processed repositories, of secrets and variables created or updated, and of deleted keys, and the
+// repositories that failed entirely or partially as JSON array.
+func (r *Report) Outputs() (map[string]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	processed := make(map[string]bool)
+	statuses := make(map[RepoStatus]int)
+	synced := make(map[string]int)
+	deleted := 0
+	for _, result := range r.Repositories {
+		statuses[result.Status]++
+		if result.Status != StatusSkipped {
+			processed[result.Repository] = true
+		}
+		for _, key := range result.Keys {
+			switch key.Outcome {
+			case KeyCreated, KeyUpdated:
+				synced[key.Kind]++
+			case KeyDeleted:
+				deleted++
+			}
+		}
+	}
+	failedRepos, err := json.Marshal(r.failedRepositories())
+	if err != nil {
+		return nil, err
+	}
+	outputs := map[string]string{
+		"repos_processed":  strconv.Itoa(len(processed)),
+		"secrets_synced":   strconv.Itoa(synced["secret"]),
+		"variables_synced": strconv.Itoa(synced["variable"]),
+		"deleted_count":    strconv.Itoa(deleted),
+		"failed_repos":     string(failedRepos),
+	}
+	// Every target counts once, so a repository can count for several statuses, e.g. for different environments.
+	for _, status := range repoStatuses {
+		outputs["repos_"+string(status)] = strconv.Itoa(statuses[status])
+	}
+	return outputs, nil
+}
+
+// Annotate writes an error annotation for every failed key and repository to w, and a warning for every skipped
+// repository, see annotate.
+func (r *Report) Annotate(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, result := range r.Repositories {
+		target := result.Target()
+		switch result.Status {
+		case StatusSkipped:
+			annotate(w, "warning", "Skipped "+target, result.Reason)
+		case StatusPartial:
+			for _, key := range result.Keys {
+				if key.Outcome == KeyFailed {
+					annotate(w, "error", fmt.Sprintf("Failed to sync %s %s to %s", key.Kind, key.Name, target), key.Error)
+				}
+			}
+		case StatusFailed:
+			annotate(w, "error", "Failed to sync "+target, result.Error)
+		}
+	}
+}
+
+// Log prints a summary of all recorded results.
+func (r *Report) Log() {
+	r.mu.Lock()
+	for _, result := range r.Repositories {
+		switch result.Status {
+		case StatusSkipped:
+			log.Printf("Skipped %s: %s\n", result.Target(), result.Reason)
+		case StatusPartial, StatusFailed:
+			log.Printf("%s %s: %s\n", result.Status, result.Target(), result.Error)
+		}
+	}
+	r.mu.Unlock()
+
+	counts := r.Counts()
+	summary := "Summary:"
+	for i, status := range repoStatuses {
+		if i > 0 {
+			summary += ","
+		}
+		summary += fmt.Sprintf(" %d %s", counts[status], status)
+	}
+	log.Println(summary)
+	if r.usage != nil {
+		log.Println(r.usage.Summary())
+	}
+}
+
+// outcomeStyle is the symbol and color a key outcome is rendered with.
+type outcomeStyle struct {
+	symbol, color string
+}
+
+// keyOutcomeStyles holds the style of every rendered outcome. Unchanged keys aren't rendered.
+var keyOutcomeStyles = map[KeyOutcome]outcomeStyle{
+	KeyCreated: {"+", colorGreen},
+	KeyUpdated: {"~", colorYellow},
+	KeyDeleted: {"-", colorRed},
+	KeyFailed:  {"!", colorRed},
+}
+
+// repoStatusColors holds the color every status is rendered with in the summary.
+var repoStatusColors = map[RepoStatus]string{
+	StatusSynced:  colorGreen,
+	StatusPartial: colorYellow,
+	StatusFailed:  colorRed,
+}
+
+// Render writes the per-key changes, or planned changes of a dry run, as aligned table followed by the summary.
+// It's used instead of Log for terminals, with color enabling green creations, yellow updates, and red deletions.
+func (r *Report) Render(w io.Writer, color bool) {
+	type row struct {
+		style              outcomeStyle
+		kind, name, target string
+		err                string
+	}
+	var rows []row
+	var kindWidth, nameWidth int
+
+	r.mu.Lock()
+	for _, result := range r.Repositories {
+		for _, key := range result.Keys {
+			style, ok := keyOutcomeStyles[key.Outcome]
+			if !ok {
+				continue
+			}
+			rows = append(rows, row{style: style, kind: key.Kind, name: key.Name, target: result.Target(), err: key.Error})
+			kindWidth = max(kindWidth, len(key.Kind))
+			nameWidth = max(nameWidth, len(key.Name))
+		}
+	}
+	var notes []string
+	for _, result := range r.Repositories {
+		switch result.Status {
+		case StatusSkipped:
+			notes = append(notes, fmt.Sprintf("Skipped %s: %s", result.Target(), result.Reason))
+		case StatusPartial, StatusFailed:
+			notes = append(notes, paint(color, repoStatusColors[result.Status], fmt.Sprintf("%s %s: %s", result.Status, result.Target(), result.Error)))
+		}
+	}
+	dryRun := r.DryRun
+	r.mu.Unlock()
+
+	if len(rows) > 0 {
+		if dryRun {
+			fmt.Fprintln(w, "Planned changes:")
+		} else {
+			fmt.Fprintln(w, "Changes:")
+		}
+		for _, row := range rows {
+			line := fmt.Sprintf("  %s %-*s  %-*s  %s", row.style.symbol, kindWidth, row.kind, nameWidth, row.name, row.target)
+			if row.err != "" {
+				line += ": " + row.err
+			}
+			fmt.Fprintln(w, paint(color, row.style.color, line))
+		}
+		fmt.Fprintln(w)
+	}
+	for _, note := range notes {
+		fmt.Fprintln(w, note)
+	}
+
+	counts := r.Counts()
+	summary := "Summary:"
+	for i, status := range repoStatuses {
+		if i > 0 {
+			summary += ","
+		}
+		part := fmt.Sprintf("%d %s", counts[status], status)
+		if counts[status] > 0 {
+			part = paint(color, repoStatusColors[status], part)
+		}
+		summary += " " + part
+	}
+	fmt.Fprintln(w, summary)
+	if r.usage != nil {
+		fmt.Fprintln(w, r.usage.Summary())
+	}
+}
+
+// WritePlan writes the rendered changes without color to the given file, so the plan of a dry run can be attached
+// to pull requests or tickets without the log around it.
+func (r *Report) WritePlan(path string) error {
+	var b bytes.Buffer
+	r.Render(&b, false)
+	if err := os.WriteFile(path, b.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write plan to %s: %w", path, err)
+	}
+	return nil
+}
+
+// Markdown returns the results as Markdown for the step summary of the workflow run: a table of the created, updated,
+// deleted, and failed keys per repository, followed by the errors and the summary.
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	if r.DryRun {
+		b.WriteString("### Sync secrets and variables (dry run)\n\n")
+	} else {
+		b.WriteString("### Sync secrets and variables\n\n")
+	}
+
+	r.mu.Lock()
+	var errs []string
+	if len(r.Repositories) > 0 {
+		b.WriteString("| Repository | Status | Created | Updated | Deleted | Failed |\n")
+		b.WriteString("| --- | --- | ---: | ---: | ---: | ---: |\n")
+	}
+	for _, result := range r.Repositories {
+		counts := make(map[KeyOutcome]int)
+		for _, key := range result.Keys {
+			counts[key.Outcome]++
+		}
+		fmt.Fprintf(&b, "| %s | %s | %d | %d | %d | %d |\n", markdownCell(result.Target()), result.Status,
+			counts[KeyCreated], counts[KeyUpdated], counts[KeyDeleted], counts[KeyFailed])
+		switch {
+		case result.Error != "":
+			errs = append(errs, fmt.Sprintf("- **%s**: %s", markdownCell(result.Target()), markdownCell(result.Error)))
+		case result.Reason != "":
+			errs = append(errs, fmt.Sprintf("- %s skipped: %s", markdownCell(result.Target()), markdownCell(result.Reason)))
+		}
+	}
+	r.mu.Unlock()
+
+	if len(errs) > 0 {
+		b.WriteString("\n" + strings.Join(errs, "\n") + "\n")
+	}
+	counts := r.Counts()
+	parts := make([]string, 0, len(repoStatuses))
+	for _, status := range repoStatuses {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+	}
+	fmt.Fprintf(&b, "\n%s\n", strings.Join(parts, ", "))
+	return b.String()
+}
+
+// markdownCell escapes s to be used in a Markdown table cell, which can't contain pipes or line breaks.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", "\\|", "\r\n", " ", "\n", " ").Replace(s)
+}
+
+// Output formats of the changes written at the end of a run.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// Change is a key written or deleted by a run, or planned to be by a dry run.
+type Change struct {
+	Repository  string     `json:"repository"`
+	Type        TargetType `json:"type,omitempty"`
+	Environment string     `json:"environment,omitempty"`
+	Kind        string     `json:"kind"`
+	Key         string     `json:"key"`
+	Action      KeyOutcome `json:"action"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Changes returns the changed keys of all repositories in the order they were processed, leaving out unchanged keys.
+func (r *Report) Changes() []Change {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changes := []Change{}
+	for _, result := range r.Repositories {
+		for _, key := range result.Keys {
+			if key.Outcome == KeyUnchanged {
+				continue
+			}
+			changes = append(changes, Change{
+				Repository:  result.Repository,
+				Type:        result.Type,
+				Environment: result.Environment,
+				Kind:        key.Kind,
+				Key:         key.Name,
+				Action:      key.Outcome,
+				Error:       key.Error,
+			})
+		}
+	}
+	return changes
+}
+
+// WriteChanges writes the changes as JSON document to w, so other tooling can consume the plan of a dry run.
+func (r *Report) WriteChanges(w io.Writer) error {
+	doc := struct {
+		DryRun  bool     `json:"dry_run"`
+		Changes []Change `json:"changes"`
+	}{DryRun: r.DryRun, Changes: r.Changes()}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode changes: %w", err)
+	}
+	return nil
+}
+
+// WriteJSON writes the report as JSON to the given file.
+func (r *Report) WriteJSON(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Modified = r.modifiedRepositories()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write report to %s: %w", path, err)
+	}
+	return nil
+}
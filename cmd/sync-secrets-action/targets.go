@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"math/rand/v2"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// RepoOrder defines the order in which matched repositories are processed.
+type RepoOrder string
+
+const (
+	OrderAlpha   RepoOrder = "alpha"
+	OrderPushed  RepoOrder = "pushed"
+	OrderCreated RepoOrder = "created"
+	OrderRandom  RepoOrder = "random"
+	// OrderSearch keeps the order of the search results, which allows processing them page by page as they arrive.
+	OrderSearch RepoOrder = "search"
+)
+
+// parseRepoOrder validates the given order string.
+func parseRepoOrder(order string) (RepoOrder, error) {
+	switch o := RepoOrder(strings.ToLower(order)); o {
+	case OrderAlpha, OrderPushed, OrderCreated, OrderRandom, OrderSearch:
+		return o, nil
+	default:
+		return "", fmt.Errorf("unsupported order %q, must be one of: alpha, pushed, created, random, search", order)
+	}
+}
+
+// syncTarget is one cell of the matrix of types and environments every repository is synced to.
+type syncTarget struct {
+	Type        TargetType
+	Environment string
+}
+
+// describe returns a human-readable representation of the target for the given repository.
+func (t syncTarget) describe(fullName string) string {
+	switch {
+	case t.Environment != "":
+		return fmt.Sprintf("%s (environment %s)", fullName, t.Environment)
+	case t.Type != Actions:
+		return fmt.Sprintf("%s (%s)", fullName, t.Type)
+	}
+	return fullName
+}
+
+// matrixTargets expands the comma or newline separated type and environment arguments into all their combinations.
+// Environments only exist for Actions, the other types are synced once per repository.
+func matrixTargets(types, environments string) ([]syncTarget, error) {
+	typeList := splitList(types)
+	if len(typeList) == 0 {
+		typeList = []string{string(Actions)}
+	}
+	envList := splitList(environments)
+
+	var targets []syncTarget
+	seen := make(map[syncTarget]bool)
+	add := func(t syncTarget) {
+		if !seen[t] {
+			seen[t] = true
+			targets = append(targets, t)
+		}
+	}
+	for _, typ := range typeList {
+		switch targetType := TargetType(typ); targetType {
+		case Actions:
+			if len(envList) == 0 {
+				add(syncTarget{Type: targetType})
+			}
+			for _, env := range envList {
+				add(syncTarget{Type: targetType, Environment: env})
+			}
+		case Dependabot, Codespaces:
+			add(syncTarget{Type: targetType})
+		default:
+			return nil, fmt.Errorf("unsupported type %q, must be one of: actions, dependabot, codespaces", typ)
+		}
+	}
+	return targets, nil
+}
+
+// checkTargetInputs rejects inputs that none of the targets supports, which would otherwise be ignored silently.
+// Variables and environments only exist for Actions, so they need actions among the types.
+func checkTargetInputs(targets []syncTarget, environments string, variables map[string]string) error {
+	types := make([]string, 0, len(targets))
+	for _, t := range targets {
+		if t.Type == Actions {
+			return nil
+		}
+		types = append(types, string(t.Type))
+	}
+	switch {
+	case len(variables) > 0:
+		return fmt.Errorf("variables are only supported for type actions, not for %s", strings.Join(types, ", "))
+	case len(splitList(environments)) > 0:
+		return fmt.Errorf("environments are only supported for type actions, not for %s", strings.Join(types, ", "))
+	}
+	return nil
+}
+
+// environmentTargets replaces the repository-level Actions target of targets with one target per environment of names
+// that matches any of patterns. Environments listed explicitly are kept and not added twice.
+func environmentTargets(targets []syncTarget, names []string, patterns []namePattern) []syncTarget {
+	var result []syncTarget
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		if t.Type == Actions && t.Environment == "" {
+			continue
+		}
+		if t.Type == Actions {
+			seen[strings.ToLower(t.Environment)] = true
+		}
+		result = append(result, t)
+	}
+	for _, name := range names {
+		if !seen[strings.ToLower(name)] && matchAny(patterns, name) {
+			seen[strings.ToLower(name)] = true
+			result = append(result, syncTarget{Type: Actions, Environment: name})
+		}
+	}
+	return result
+}
+
+type listedEnvironmentContextKey struct{}
+
+// withListedEnvironment marks the environment of the operations run with the returned context as found by listing
+// the environments of the repository, so it's known to exist.
+func withListedEnvironment(ctx context.Context) context.Context {
+	return context.WithValue(ctx, listedEnvironmentContextKey{}, true)
+}
+
+// environmentListed reports whether withListedEnvironment marked the environment as existing.
+func environmentListed(ctx context.Context) bool {
+	listed, _ := ctx.Value(listedEnvironmentContextKey{}).(bool)
+	return listed
+}
+
+// listEnvironmentNames returns the names of the environments of repo.
+func listEnvironmentNames(ctx context.Context, client GitHubActionClient, repo *github.Repository, perPage int) ([]string, error) {
+	environments, err := listAll(perPage, func(opts *github.ListOptions) ([]*github.Environment, *github.Response, error) {
+		e, resp, err := client.ListEnvironments(ctx, repo.GetOwner().GetLogin(), repo.GetName(), &github.EnvironmentListOptions{ListOptions: *opts})
+		if err != nil {
+			return nil, resp, err
+		}
+		return e.Environments, resp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(environments))
+	for _, environment := range environments {
+		names = append(names, environment.GetName())
+	}
+	return names, nil
+}
+
+// withTarget returns a copy of args that selects a single target of the matrix.
+func (args EnvArgs) withTarget(t syncTarget) EnvArgs {
+	args.Type = string(t.Type)
+	args.Environment = t.Environment
+	return args
+}
+
+// resolveRepositories returns the repositories selected by the target, query, or repos and repos-file arguments,
+// without those on the skip list. The query may list several search queries, one per line, whose results are combined without duplicates.
+// Invalid arguments are reported right away, errors while searching are yielded by the returned sequence.
+func resolveRepositories(ctx context.Context, args EnvArgs, client GitHubActionClient) (iter.Seq2[*github.Repository, error], error) {
+	selectors := 0
+	for _, selector := range []string{args.TargetRepo, args.Query, args.Repos + args.ReposFile} {
+		if selector != "" {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return nil, fmt.Errorf("exactly one of target, query, or repos and repos-file must be set")
+	}
+	queries := searchQueries(args.Query)
+	// A query of blank lines would otherwise select nothing without any notice.
+	if args.Query != "" && len(queries) == 0 {
+		return nil, fmt.Errorf("query is empty")
+	}
+	listed, err := loadRepoList(args.Repos, args.ReposFile)
+	if err != nil {
+		return nil, err
+	}
+	order, err := parseRepoOrder(args.Order)
+	if err != nil {
+		return nil, err
+	}
+
+	skip, err := loadSkipList(args.SkipRepos, args.SkipReposFile)
+	if err != nil {
+		return nil, err
+	}
+	var nameRegex *regexp.Regexp
+	if args.NameRegex != "" {
+		if nameRegex, err = regexp.Compile(args.NameRegex); err != nil {
+			return nil, fmt.Errorf("invalid name-regex: %w", err)
+		}
+	}
+	var filter *repoFilter
+	if args.Filter != "" {
+		// Explicit targets aren't looked up, so their attributes are unknown.
+		if args.TargetRepo != "" || len(listed) > 0 {
+			return nil, fmt.Errorf("filter can only be used with query")
+		}
+		if filter, err = compileRepoFilter(args.Filter); err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+
+	var repos iter.Seq2[*github.Repository, error]
+	switch {
+	case args.TargetRepo != "":
+		owner, repo := parseRepoFullName(args.TargetRepo)
+		repos = func(yield func(*github.Repository, error) bool) {
+			yield(newRepository(owner, repo), nil)
+		}
+	case len(listed) > 0:
+		// Listed repositories are synced in the order of the list.
+		repos = func(yield func(*github.Repository, error) bool) {
+			for _, repo := range listed {
+				if !yield(repo, nil) {
+					return
+				}
+			}
+		}
+	case order == OrderSearch:
+		repos = func(yield func(*github.Repository, error) bool) {
+			for _, query := range queries {
+				for repo, err := range streamRepositories(ctx, client, query, args.PerPage) {
+					if !yield(repo, err) || err != nil {
+						return
+					}
+				}
+			}
+		}
+	default:
+		// All other orders need the complete result before the first repository can be processed.
+		repos = func(yield func(*github.Repository, error) bool) {
+			var all []*github.Repository
+			for _, query := range queries {
+				found, err := client.SearchRepositories(ctx, query)
+				if err != nil {
+					yield(nil, fmt.Errorf("error searching for repositories matching %q: %w", query, err))
+					return
+				}
+				all = append(all, found...)
+			}
+			sortRepositories(all, order)
+			for _, repo := range all {
+				if !yield(repo, nil) {
+					return
+				}
+			}
+		}
+	}
+	repos = uniqueRepositories(repos)
+	if exclude := searchQueries(args.ExcludeQuery); len(exclude) > 0 {
+		repos = excludeRepositories(ctx, client, repos, exclude)
+	}
+	if nameRegex != nil {
+		repos = matchRepositories(ctx, repos, nameRegex)
+	}
+	if filter != nil {
+		repos = filterRepositories(ctx, client, repos, filter)
+	}
+	repos = skipRepositories(ctx, repos, skip)
+	if path := strings.Trim(args.RequireFile, "/ "); path != "" {
+		repos = requireFile(ctx, client, repos, path)
+	}
+	return repos, nil
+}
+
+// loadRepoList parses a comma or newline separated list of repositories given as owner/repo, followed by those of
+// the file at path, if set.
+func loadRepoList(list, path string) ([]*github.Repository, error) {
+	items := splitList(list)
+	if path != "" {
+		lines, err := readListFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read repos file: %w", err)
+		}
+		if len(lines) == 0 {
+			return nil, fmt.Errorf("repos file %s lists no repositories", path)
+		}
+		items = append(items, lines...)
+	}
+	var repos []*github.Repository
+	for _, fullName := range items {
+		owner, name, found := strings.Cut(fullName, "/")
+		if !found || owner == "" || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid repository %q in repos, expected owner/repo", fullName)
+		}
+		repos = append(repos, newRepository(owner, name))
+	}
+	return repos, nil
+}
+
+// searchQueries splits the query argument into its search queries, one per line.
+func searchQueries(query string) []string {
+	var queries []string
+	for _, line := range strings.Split(query, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			queries = append(queries, line)
+		}
+	}
+	return queries
+}
+
+// uniqueRepositories drops repositories already yielded by repos, as matched by several search queries.
+func uniqueRepositories(repos iter.Seq2[*github.Repository, error]) iter.Seq2[*github.Repository, error] {
+	return func(yield func(*github.Repository, error) bool) {
+		seen := make(map[string]bool)
+		for repo, err := range repos {
+			if err == nil {
+				if seen[repoKey(repo)] {
+					continue
+				}
+				seen[repoKey(repo)] = true
+			}
+			if !yield(repo, err) {
+				return
+			}
+		}
+	}
+}
+
+// excludeRepositories filters all repositories matching one of the exclude queries from repos.
+// The excluded repositories are searched once the first repository is requested.
+func excludeRepositories(ctx context.Context, client GitHubActionClient, repos iter.Seq2[*github.Repository, error], queries []string) iter.Seq2[*github.Repository, error] {
+	return func(yield func(*github.Repository, error) bool) {
+		excluded := make(map[string]bool)
+		for _, query := range queries {
+			found, err := client.SearchRepositories(ctx, query)
+			if err != nil {
+				yield(nil, fmt.Errorf("error searching for repositories to exclude matching %q: %w", query, err))
+				return
+			}
+			for _, repo := range found {
+				excluded[repoKey(repo)] = true
+			}
+		}
+
+		for repo, err := range repos {
+			if err == nil && excluded[repoKey(repo)] {
+				loggerFrom(ctx).Printf("Skipping %s/%s: matched by exclude-query\n", repo.GetOwner().GetLogin(), repo.GetName())
+				continue
+			}
+			if !yield(repo, err) {
+				return
+			}
+		}
+	}
+}
+
+// matchRepositories filters all repositories whose full name doesn't match the regular expression from repos.
+// The search API also matches descriptions and READMEs, so this allows a precise selection.
+func matchRepositories(ctx context.Context, repos iter.Seq2[*github.Repository, error], nameRegex *regexp.Regexp) iter.Seq2[*github.Repository, error] {
+	return func(yield func(*github.Repository, error) bool) {
+		for repo, err := range repos {
+			if err == nil {
+				if fullName := repo.GetOwner().GetLogin() + "/" + repo.GetName(); !nameRegex.MatchString(fullName) {
+					loggerFrom(ctx).Printf("Skipping %s: doesn't match name-regex\n", fullName)
+					continue
+				}
+			}
+			if !yield(repo, err) {
+				return
+			}
+		}
+	}
+}
+
+// repoKey identifies a repository by its lowercased full name, as GitHub names are case-insensitive.
+func repoKey(repo *github.Repository) string {
+	return strings.ToLower(repo.GetOwner().GetLogin() + "/" + repo.GetName())
+}
+
+// streamRepositories yields the repositories matching the query page by page, fetching the next page only once
+// the previous one has been consumed.
+func streamRepositories(ctx context.Context, client GitHubActionClient, query string, perPage int) iter.Seq2[*github.Repository, error] {
+	return func(yield func(*github.Repository, error) bool) {
+		opts := &github.SearchOptions{ListOptions: github.ListOptions{PerPage: perPage}}
+		for {
+			result, resp, err := client.SearchRepositoriesPage(ctx, query, opts)
+			if err != nil {
+				yield(nil, fmt.Errorf("error searching for repositories matching %q: %w", query, err))
+				return
+			}
+			for _, repo := range result.Repositories {
+				if !yield(repo, nil) {
+					return
+				}
+			}
+			if resp.NextPage == 0 {
+				return
+			}
+			opts.Page = resp.NextPage
+		}
+	}
+}
+
+// loadSkipList reads the repositories to skip from the given list and file, keyed by lowercased full name.
+// The file lists one repository per line, lines starting with # are comments.
+func loadSkipList(list, path string) (map[string]bool, error) {
+	items := splitList(list)
+	if path != "" {
+		lines, err := readListFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read skip list: %w", err)
+		}
+		items = append(items, lines...)
+	}
+
+	skip := make(map[string]bool, len(items))
+	for _, item := range items {
+		owner, repo, ok := strings.Cut(item, "/")
+		if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return nil, fmt.Errorf("invalid repository %q in skip list, expected owner/repo", item)
+		}
+		skip[strings.ToLower(item)] = true
+	}
+	return skip, nil
+}
+
+// readListFile reads the items of a file with one item per line. Empty lines and comments starting with # are ignored.
+func readListFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var items []string
+	for _, line := range strings.Split(normalizeInput(string(data)), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			items = append(items, line)
+		}
+	}
+	return items, nil
+}
+
+// skipRepositories filters all repositories on the skip list from repos, keeping the order of the others.
+func skipRepositories(ctx context.Context, repos iter.Seq2[*github.Repository, error], skip map[string]bool) iter.Seq2[*github.Repository, error] {
+	if len(skip) == 0 {
+		return repos
+	}
+	return func(yield func(*github.Repository, error) bool) {
+		for repo, err := range repos {
+			if err == nil {
+				if skip[repoKey(repo)] {
+					loggerFrom(ctx).Printf("Skipping %s/%s: listed in skip-repos\n", repo.GetOwner().GetLogin(), repo.GetName())
+					continue
+				}
+			}
+			if !yield(repo, err) {
+				return
+			}
+		}
+	}
+}
+
+// requireFile filters all repositories that don't contain the file or directory at path from repos,
+// so owners can opt in to a sync by committing a marker file. It's checked last, as it costs a request per repository.
+func requireFile(ctx context.Context, client GitHubActionClient, repos iter.Seq2[*github.Repository, error], path string) iter.Seq2[*github.Repository, error] {
+	return func(yield func(*github.Repository, error) bool) {
+		for repo, err := range repos {
+			if err == nil {
+				owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+				exists, checkErr := client.HasFile(ctx, owner, name, path)
+				if checkErr != nil && isPermissionError(checkErr) {
+					loggerFrom(ctx).Printf("Skipping %s/%s: insufficient permissions to check for %s: %v\n", owner, name, path, checkErr)
+					continue
+				}
+				if checkErr != nil {
+					yield(nil, fmt.Errorf("failed to check %s/%s for %s: %w", owner, name, path, checkErr))
+					return
+				}
+				if !exists {
+					loggerFrom(ctx).Printf("Skipping %s/%s: doesn't contain %s\n", owner, name, path)
+					continue
+				}
+			}
+			if !yield(repo, err) {
+				return
+			}
+		}
+	}
+}
+
+// repositoryID returns the ID of a repository, looking it up and remembering it if the reference doesn't carry it.
+// Search results include the ID, explicitly named targets don't.
+func repositoryID(ctx context.Context, client GitHubActionClient, repo *github.Repository) (int, error) {
+	if repo.GetID() != 0 {
+		return int(repo.GetID()), nil
+	}
+	r, _, err := client.GetRepository(ctx, repo.GetOwner().GetLogin(), repo.GetName())
+	if err != nil {
+		return 0, fmt.Errorf("failed to get repository %s: %w", repo.GetFullName(), err)
+	}
+	repo.ID = r.ID
+	return int(r.GetID()), nil
+}
+
+// resolveEnvTarget resolves a repository for environment operations. The ID is remembered in repo,
+// so syncing several environments of the same repository looks it up once.
+func resolveEnvTarget(ctx context.Context, client GitHubActionClient, repo *github.Repository) (envTarget, error) {
+	id, err := repositoryID(ctx, client, repo)
+	if err != nil {
+		return envTarget{}, err
+	}
+	return envTarget{RepoID: id, Owner: repo.GetOwner().GetLogin(), Repo: repo.GetName()}, nil
+}
+
+// splitList splits a comma or newline separated list, dropping empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// newRepository returns a minimal repository reference for an explicitly named target.
+func newRepository(owner, name string) *github.Repository {
+	return &github.Repository{
+		Name:     github.Ptr(name),
+		FullName: github.Ptr(owner + "/" + name),
+		Owner:    &github.User{Login: github.Ptr(owner)},
+	}
+}
+
+// sortRepositories orders repositories in place.
+// Pushed and created order put the least recently pushed or oldest repositories first,
+// ties are broken by full name so the result stays deterministic.
+func sortRepositories(repos []*github.Repository, order RepoOrder) {
+	if order == OrderRandom {
+		rand.Shuffle(len(repos), func(i, j int) {
+			repos[i], repos[j] = repos[j], repos[i]
+		})
+		return
+	}
+
+	sort.SliceStable(repos, func(i, j int) bool {
+		a, b := repos[i], repos[j]
+		switch order {
+		case OrderPushed:
+			if !a.GetPushedAt().Equal(b.GetPushedAt()) {
+				return a.GetPushedAt().Before(b.GetPushedAt().Time)
+			}
+		case OrderCreated:
+			if !a.GetCreatedAt().Equal(b.GetCreatedAt()) {
+				return a.GetCreatedAt().Before(b.GetCreatedAt().Time)
+			}
+		}
+		return strings.ToLower(a.GetFullName()) < strings.ToLower(b.GetFullName())
+	})
+}
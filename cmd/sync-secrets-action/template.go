@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// templateData is passed to value templates, so values can differ per repository and environment.
+type templateData struct {
+	// Owner of the repository.
+	Owner string
+	// Repository name, without the owner.
+	Repository string
+	// FullName of the repository as owner/repo.
+	FullName string
+	// Environment synced to, empty for repository values.
+	Environment string
+	// Type of the target: actions, dependabot, or codespaces.
+	Type string
+}
+
+// alphaNum are the characters generated by randAlphaNum.
+const alphaNum = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// templateFuncs are the functions available to value templates, named after their sprig counterparts.
+// Random values come from crypto/rand, as they're meant to be used as secrets.
+var templateFuncs = template.FuncMap{
+	"randAlphaNum": randAlphaNum,
+	"b64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"b64dec": func(s string) (string, error) {
+		decoded, err := base64.StdEncoding.DecodeString(s)
+		return string(decoded), err
+	},
+	"uuidv4": uuidv4,
+	"now":    time.Now,
+}
+
+// randAlphaNum returns a random string of n letters and digits.
+func randAlphaNum(n int) (string, error) {
+	if n < 0 {
+		return "", fmt.Errorf("randAlphaNum: negative length %d", n)
+	}
+	b := make([]byte, n)
+	limit := big.NewInt(int64(len(alphaNum)))
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+		b[i] = alphaNum[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+// uuidv4 returns a random UUID as defined by RFC 9562.
+func uuidv4() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+// valueTemplates are the parsed templates of all values of an input, keyed by name.
+type valueTemplates map[string]*template.Template
+
+// parseValueTemplates parses every value as template, so syntax errors and unknown functions fail the run
+// before any API call instead of in the middle of it.
+func parseValueTemplates(values map[string]string) (valueTemplates, error) {
+	templates := make(valueTemplates, len(values))
+	for name, value := range values {
+		tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of %s: %w", name, err)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// render executes all templates with data. Generators like randAlphaNum yield new values with every call,
+// so each target gets its own. Derived values are passed to mask, if set, so they can be hidden from logs.
+func (t valueTemplates) render(data templateData, mask func(value string)) (map[string]string, error) {
+	values := make(map[string]string, len(t))
+	for _, name := range sortedKeys(t) {
+		tmpl := t[name]
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("failed to render template of %s: %w", name, err)
+		}
+		values[name] = b.String()
+		if mask != nil && isDerived(tmpl) {
+			mask(values[name])
+		}
+	}
+	return values, nil
+}
+
+// isDerived reports whether tmpl contains actions. Values without them render to themselves and aren't derived.
+func isDerived(tmpl *template.Template) bool {
+	if tmpl.Tree == nil {
+		return false
+	}
+	for _, node := range tmpl.Root.Nodes {
+		if node.Type() != parse.NodeText {
+			return true
+		}
+	}
+	return false
+}
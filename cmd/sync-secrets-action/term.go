@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+)
+
+// ANSI escape sequences of the colors used for terminal output.
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+// colorEnabled reports whether output written to f should be colorized.
+// That's only the case for terminals, so logs of CI runs stay plain. NO_COLOR and TERM=dumb opt out.
+func colorEnabled(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// paint wraps s in the given color if enabled.
+func paint(enabled bool, color, s string) string {
+	if !enabled || color == "" {
+		return s
+	}
+	return color + s + colorReset
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// tokenExpirationHeader carries the expiration of personal access tokens in API responses.
+const tokenExpirationHeader = "GitHub-Authentication-Token-Expiration"
+
+// tokenExpiryWarning is how long before its expiration a token is warned about.
+const tokenExpiryWarning = 7 * 24 * time.Hour
+
+// tokenTypes maps the prefixes of GitHub tokens to the kind of token.
+var tokenTypes = []struct {
+	prefix, kind string
+}{
+	{"github_pat_", "fine-grained personal access token"},
+	{"ghp_", "classic personal access token"},
+	{"ghs_", "GitHub App installation token"},
+	{"ghu_", "GitHub App user access token"},
+	{"gho_", "OAuth access token"},
+}
+
+// tokenType returns the kind of a token, as told by its prefix.
+func tokenType(token string) string {
+	for _, t := range tokenTypes {
+		if strings.HasPrefix(token, t.prefix) {
+			return t.kind
+		}
+	}
+	return "token"
+}
+
+// parseTokenExpiration parses the value of tokenExpirationHeader, e.g. 2026-11-01 12:00:00 UTC.
+func parseTokenExpiration(value string) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05 -0700"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// rateLimitsResult is a response of the rate limit endpoint, read once before a run and shared by checkToken, whoami,
+// and the rate limit tracker. Reading the rate limit doesn't count against it.
+type rateLimitsResult struct {
+	limits *github.RateLimits
+	resp   *github.Response
+	err    error
+}
+
+// readRateLimits reads the rate limit and records its state in tracker, so the first request of the run doesn't
+// read it again.
+func readRateLimits(ctx context.Context, client GitHubActionClient, tracker *rateLimitTracker) rateLimitsResult {
+	limits, resp, err := client.Ratelimits(ctx)
+	if err == nil {
+		for _, resource := range []string{coreResource, searchResource} {
+			if rate := resourceRate(limits, resource); rate != nil {
+				tracker.update(resource, *rate)
+			}
+		}
+	}
+	return rateLimitsResult{limits: limits, resp: resp, err: err}
+}
+
+// checkToken verifies the token by the rate limit read before any repository is processed, so an expired or revoked
+// token fails the run with a clear message instead of the failure of the first repository.
+// Tokens expiring within tokenExpiryWarning are warned about. Other failures are left to the requests that follow.
+func checkToken(ctx context.Context, rateLimits rateLimitsResult, token string) error {
+	kind := tokenType(token)
+	if isStatus(rateLimits.err, http.StatusUnauthorized) {
+		return fmt.Errorf("the %s passed as github-token was rejected with 401 Bad credentials, it has expired or was revoked", kind)
+	}
+	resp := rateLimits.resp
+	if resp == nil {
+		return nil
+	}
+	if expiry, ok := parseTokenExpiration(resp.Header.Get(tokenExpirationHeader)); ok && time.Until(expiry) < tokenExpiryWarning {
+		loggerFrom(ctx).Printf("Warning: the %s passed as github-token expires at %s\n", kind, expiry.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v68/github"
+)
+
+// oauthScopesHeader lists the scopes of classic personal access tokens and OAuth tokens in API responses.
+const oauthScopesHeader = "X-OAuth-Scopes"
+
+// WhoamiCmd prints who the token authenticates as.
+type WhoamiCmd struct{}
+
+// Identity describes who a token authenticates as and what it may do.
+type Identity struct {
+	// Login and Type of the authenticated user, empty for installation tokens of GitHub Apps.
+	Login string
+	Type  string
+	// TokenType is the kind of token, as told by its prefix.
+	TokenType string
+	// Scopes of classic personal access tokens and OAuth tokens, nil for other tokens.
+	Scopes []string
+	// Core is the state of the core rate limit, nil if rate limiting is disabled.
+	Core *github.Rate
+}
+
+// whoami determines who the token authenticates as, with the scopes and rate limit of rateLimits. Fine-grained
+// permissions aren't exposed by the API, so only the scopes of classic tokens are known.
+func whoami(ctx context.Context, client GitHubActionClient, rateLimits rateLimitsResult, token string) (Identity, error) {
+	identity := Identity{TokenType: tokenType(token)}
+	if err := rateLimits.err; err != nil && !isStatus(err, http.StatusNotFound) {
+		return identity, fmt.Errorf("failed to get the rate limit: %w", err)
+	}
+	identity.Core = rateLimits.limits.GetCore()
+	if resp := rateLimits.resp; resp != nil {
+		if values, ok := resp.Header[http.CanonicalHeaderKey(oauthScopesHeader)]; ok {
+			identity.Scopes = []string{}
+			for _, scope := range strings.Split(strings.Join(values, ","), ",") {
+				if scope = strings.TrimSpace(scope); scope != "" {
+					identity.Scopes = append(identity.Scopes, scope)
+				}
+			}
+		}
+	}
+	user, _, err := client.AuthenticatedUser(ctx)
+	switch {
+	case err == nil:
+		identity.Login, identity.Type = user.GetLogin(), user.GetType()
+	case !isStatus(err, http.StatusForbidden):
+		// Installation tokens are refused with 403, as they don't belong to a user.
+		return identity, fmt.Errorf("failed to get the authenticated user: %w", err)
+	}
+	return identity, nil
+}
+
+// logIdentity logs the identity, one line per aspect.
+func logIdentity(logger Logger, identity Identity) {
+	if identity.Login != "" {
+		logger.Printf("Authenticated as %s (%s) with a %s\n", identity.Login, identity.Type, identity.TokenType)
+	} else {
+		logger.Printf("Authenticated with a %s\n", identity.TokenType)
+	}
+	switch {
+	case identity.Scopes == nil:
+		logger.Printf("Permissions: not reported by the API for this token, see its settings\n")
+	case len(identity.Scopes) == 0:
+		logger.Printf("Scopes: none\n")
+	default:
+		logger.Printf("Scopes: %s\n", strings.Join(identity.Scopes, ", "))
+	}
+	if identity.Core != nil {
+		logger.Printf("Rate limit: %d of %d requests remaining, resets at %s\n", identity.Core.Remaining, identity.Core.Limit, identity.Core.Reset.Format(time.RFC3339))
+	} else {
+		logger.Printf("Rate limit: disabled\n")
+	}
+}